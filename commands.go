@@ -0,0 +1,426 @@
+// file commands.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+// command is a subcommand of the tool with its own flags.
+type command struct {
+	name    string
+	summary string
+	flags   func(fs *flag.FlagSet, c *cliOptions) // Registers the flags of the command
+	run     func(c *cliOptions) error
+}
+
+// commands are the subcommands of the tool, in the order they are listed in the usage.
+var commands = []*command{
+	{
+		name:    "migrate",
+		summary: "Convert BirdNET-Pi detections to BirdNET-Go notes and copy or move their clips",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.StringVar(&c.mode, "mode", "copy", "Transfer clips by 'copy', keeping the originals, or by 'move', deleting them from the source.")
+			addSourceFlags(fs, c)
+			addSnapshotFlag(fs, c)
+			addClipFlags(fs, c)
+			addOverrideFlag(fs, c)
+			addSpeciesFlags(fs, c)
+			addTargetFlags(fs, c)
+		},
+		run: runMigrate,
+	},
+	{
+		name:    "sync",
+		summary: "Keep copying new detections of a running BirdNET-Pi until interrupted",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.DurationVar(&c.SyncInterval, "interval", time.Minute, "Polling interval for new detections.")
+			addLiveSourceFlag(fs, c)
+			addClipFlags(fs, c)
+			addOverrideFlag(fs, c)
+			addSpeciesFlags(fs, c)
+			addTargetFlags(fs, c)
+		},
+		run: func(c *cliOptions) error { return runMigrator(c, pi2go.OperationSync) },
+	},
+	{
+		name:    "merge",
+		summary: "Merge the notes of a BirdNET-Go database, or the detections of a BirdNET-Pi one, into a BirdNET-Go database",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.StringVar(&c.mode, "mode", "copy", "Transfer clips by 'copy', keeping the originals, or by 'move', deleting them from the source.")
+			addSourceFlags(fs, c)
+			fs.StringVar(&c.SourceFilesDir, "source-dir", "",
+				"Clips of the source: a BirdNET-Go clips directory, or BirdNET-Pi BirdSongs. Empty to merge only the database.")
+			fs.StringVar(&c.TargetFilesDir, "target-dir", "clips", "Directory path for BirdNET-Go clips.")
+			fs.BoolVar(&c.SkipAudioTransfer, "skip-audio-transfer", false, "Skip transferring audio files and only merge the database.")
+			fs.BoolVar(&c.SkipMissingClips, "skip-missing-clips", false,
+				"Leave out notes whose clip is missing from -source-dir instead of merging them without a clip.")
+			fs.StringVar(&c.Station, "station", "", "Tag the notes merged from the source with this station.")
+			fs.Var(locationFlag{&c.Location}, "location", "Replace the latitude and longitude of the notes merged from the source, as lat,lon.")
+			fs.Var(mergeSourcesFlag{&c.Sources}, "add-source",
+				"Merge a further local database after the source, as db=path[,dir=clips][,station=name][,location=lat;lon]. Can be given more than once.")
+			addOverrideFlag(fs, c)
+			addSpeciesFlags(fs, c)
+			addTargetFlags(fs, c)
+		},
+		run: runMerge,
+	},
+	{
+		name:    "rollback",
+		summary: "Undo the most recent run recorded in the manifest of the target database",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.StringVar(&c.RollbackRun, "run", "", "Run to roll back, by its ID in the manifest. Defaults to the most recent run not rolled back yet.")
+			addTargetFlags(fs, c)
+		},
+		run: func(c *cliOptions) error { return runMigrator(c, pi2go.OperationRollback) },
+	},
+	{
+		name:    "export",
+		summary: "Write the notes and clips of a BirdNET-Go database back as a BirdNET-Pi database and BirdSongs directory",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.StringVar(&c.export.NotesDBPath, "from", "birdnet.db", "Path to the BirdNET-Go SQLite database to export.")
+			fs.StringVar(&c.export.ClipsDir, "from-dir", "clips", "Directory path for BirdNET-Go clips to export.")
+			fs.StringVar(&c.export.DetectionsDBPath, "out", "",
+				"Path to the BirdNET-Pi SQLite database to write. Required, and must not exist unless -force is given.")
+			fs.StringVar(&c.export.BirdSongsDir, "out-dir", "", "Directory path for BirdNET-Pi BirdSongs to copy the clips to.")
+			fs.BoolVar(&c.export.Force, "force", false, "Write into an existing -out database, such as to resume an interrupted export.")
+			fs.BoolVar(&c.SkipAudioTransfer, "skip-audio-transfer", false, "Skip copying audio files and only export the database.")
+			fs.Float64Var(&c.export.Overlap, "overlap", 0, "Analysis overlap to record with every detection, the OVERLAP setting of BirdNET-Pi.")
+		},
+		run: runExport,
+	},
+	{
+		name:    "inspect",
+		summary: "Profile a BirdNET-Pi source, its dates, species, locations and clips, before migrating it",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			addSourceFlags(fs, c)
+			addSnapshotFlag(fs, c)
+			fs.StringVar(&c.SourceFilesDir, "source-dir", "", "Directory path for BirdNET-Pi BirdSongs, empty to not check clips.")
+			fs.StringVar(&c.TargetDBPath, "target-db", "", "BirdNET-Go SQLite database to show where a migration into it would resume.")
+			fs.BoolVar(&c.jsonOutput, "json", false, "Print the profile as JSON.")
+		},
+		run: runInspect,
+	},
+	{
+		name:    "diff",
+		summary: "Show the detections or notes of a source that differ from the notes of a BirdNET-Go database",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			addSourceFlags(fs, c)
+			fs.StringVar(&c.TargetDBPath, "target-db", "birdnet.db", "Path to the BirdNET-Go SQLite database.")
+			fs.BoolVar(&c.jsonOutput, "json", false, "Print the differences as JSON.")
+		},
+		run: runDiff,
+	},
+	{
+		name:    "verify",
+		summary: "Check that a BirdNET-Go database is intact and the clips of its notes are present",
+		flags: func(fs *flag.FlagSet, c *cliOptions) {
+			fs.StringVar(&c.TargetDBPath, "target-db", "birdnet.db", "Path to the BirdNET-Go SQLite database.")
+			fs.StringVar(&c.TargetFilesDir, "target-dir", "clips", "Directory path for BirdNET-Go clips, empty to only check the database.")
+		},
+		run: runVerify,
+	},
+}
+
+// cliOptions are the values of the flags of a command.
+type cliOptions struct {
+	pi2go.Options
+
+	configPath string              // Config file holding flag values
+	mode       string              // copy or move, for migrate and merge
+	reportPath string              // JSON report of the run
+	backup     bool                // Back up the target database before writing to it
+	assumeYes  bool                // Run without asking for confirmation
+	jsonOutput bool                // Print results as JSON
+	export     pi2go.ExportOptions // Databases and directories of an export
+}
+
+// errUsage is returned for invalid command lines, after their usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// findCommand returns the command called name, or nil if there is none.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns the flag set of cmd bound to c, printing its usage to out.
+func (cmd *command) newFlagSet(c *cliOptions, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage: birdnet-pi2go %s [flags]\n\n%s.\n\nFlags:\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&c.configPath, "config", "",
+		"YAML (.yaml, .yml) or TOML (.toml) file holding flag values, overridden by flags given on the command line.")
+	cmd.flags(fs, c)
+	return fs
+}
+
+// parse parses the flags of cmd from args and the config file they name.
+func (cmd *command) parse(args []string, out io.Writer) (*cliOptions, error) {
+	c := &cliOptions{}
+	fs := cmd.newFlagSet(c, out)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(out, "Unexpected arguments: %v\n", fs.Args())
+		fs.Usage()
+		return nil, errUsage
+	}
+
+	if c.configPath != "" {
+		if err := applyConfig(fs, c.configPath, cmd.name); err != nil {
+			return nil, err
+		}
+	}
+
+	// With a remote source, only explicitly given paths override the remote defaults.
+	if c.Source != "" {
+		var remoteDBPath, remoteFilesDir string
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "source-db":
+				remoteDBPath = c.SourceDBPath
+			case "source-dir":
+				remoteFilesDir = c.SourceFilesDir
+			}
+		})
+		c.SourceDBPath, c.SourceFilesDir = remoteDBPath, remoteFilesDir
+	}
+
+	if (cmd.name == "migrate" || cmd.name == "merge") && c.mode != "copy" && c.mode != "move" {
+		return nil, fmt.Errorf("invalid mode %q, use copy or move", c.mode)
+	}
+	c.SkipBackup = !c.backup
+
+	return c, nil
+}
+
+// addLiveSourceFlag registers the flag of the live BirdNET-Pi database, the only source sync
+// polls.
+func addLiveSourceFlag(fs *flag.FlagSet, c *cliOptions) {
+	fs.StringVar(&c.SourceDBPath, "source-db", "birds.db", "Path to the BirdNET-Pi SQLite database.")
+}
+
+// addSourceFlags registers the flags selecting the BirdNET-Pi source.
+func addSourceFlags(fs *flag.FlagSet, c *cliOptions) {
+	addLiveSourceFlag(fs, c)
+	fs.StringVar(&c.Source, "source", "",
+		"Remote BirdNET-Pi to migrate from over SFTP, e.g. ssh://pi@birdnetpi.local/home/pi/BirdNET-Pi, "+
+			"or its web server, e.g. http://birdnetpi.local/. -source-db and -source-dir are then remote paths.")
+	fs.StringVar(&c.SSH.KeyPath, "ssh-key", "", "Private key for the remote source, in addition to the SSH agent.")
+	fs.BoolVar(&c.SSH.Insecure, "ssh-insecure", false, "Do not verify the remote host key against known_hosts.")
+	fs.IntVar(&c.HTTPConcurrency, "http-concurrency", 4,
+		"Maximum number of simultaneous requests to a BirdNET-Pi web server source.")
+	fs.StringVar(&c.SourceTextPath, "source-txt", "",
+		"Path to the BirdNET-Pi BirdDB.txt detection log, used instead of the source database.")
+	fs.BoolVar(&c.FillGaps, "fill-gaps", false,
+		"Cross-check -source-txt against the source database and import only detections missing from it.")
+}
+
+// addSnapshotFlag registers the flag migrating from a snapshot of the source database.
+func addSnapshotFlag(fs *flag.FlagSet, c *cliOptions) {
+	fs.BoolVar(&c.Snapshot, "snapshot", true,
+		"Migrate from a consistent snapshot of the source database, allowing BirdNET-Pi to keep running.")
+}
+
+// addClipFlags registers the flags of the clip directories.
+func addClipFlags(fs *flag.FlagSet, c *cliOptions) {
+	fs.StringVar(&c.SourceFilesDir, "source-dir", "", "Directory path for BirdNET-Pi BirdSongs.")
+	fs.StringVar(&c.TargetFilesDir, "target-dir", "clips", "Directory path for BirdNET-Go clips.")
+	fs.BoolVar(&c.SkipAudioTransfer, "skip-audio-transfer", false,
+		"Skip transferring audio files and only perform database migration.")
+}
+
+// addOverrideFlag registers the flag replacing recorded locations and detection parameters.
+func addOverrideFlag(fs *flag.FlagSet, c *cliOptions) {
+	fs.Var(overridesFlag{&c.Overrides}, "override",
+		"Replace the location or detection parameters of the notes written, as [from=date,][to=date,][station=name,]"+
+			"lat=,lon=,threshold= and/or sensitivity=. Can be given more than once, later ones take precedence.")
+}
+
+// addSpeciesFlags registers the flags remapping outdated scientific names and translating
+// common names.
+func addSpeciesFlags(fs *flag.FlagSet, c *cliOptions) {
+	fs.BoolVar(&c.RemapTaxonomy, "remap-taxonomy", false,
+		"Remap scientific names of older BirdNET labels to those BirdNET-Go uses, renaming their clips to match.")
+	fs.StringVar(&c.TaxonomyPath, "taxonomy", "",
+		"CSV of old_scientific_name,scientific_name[,common_name] rows remapped over the bundled table. Implies -remap-taxonomy.")
+	fs.StringVar(&c.Locale, "locale", "",
+		"Translate common names by scientific name with a BirdNET label file at this path, or the bundled labels of a locale code such as 'fi'.")
+}
+
+// addTargetFlags registers the flags of the target database and how it is written to.
+func addTargetFlags(fs *flag.FlagSet, c *cliOptions) {
+	fs.StringVar(&c.TargetDBPath, "target-db", "birdnet.db", "Path to the BirdNET-Go SQLite database.")
+	fs.StringVar(&c.ManifestPath, "manifest", "",
+		"Manifest of inserted notes and transferred clips used by rollback. Defaults to the target database path with .manifest.jsonl appended.")
+	fs.StringVar(&c.reportPath, "report", "",
+		"Write a JSON report of the run, with counts, clip failures and per-species totals, to this file.")
+	fs.BoolVar(&c.backup, "backup", true,
+		"Back up an existing target database next to it before writing to it.")
+	fs.BoolVar(&c.RestoreOnFailure, "restore-on-failure", false,
+		"Restore the target database from its backup without asking if the run fails.")
+	fs.BoolVar(&c.AllowTargetInUse, "allow-target-in-use", false,
+		"Only warn instead of stopping when another process, such as BirdNET-Go, has the target database open.")
+	fs.BoolVar(&c.assumeYes, "yes", false,
+		"Do not ask for confirmation before moving clips or rolling back, nor offer to restore the target database on failure.")
+	fs.BoolVar(&c.assumeYes, "non-interactive", false, "Same as -yes, for unattended runs.")
+}
+
+// runMigrate copies or moves detections and their clips.
+func runMigrate(c *cliOptions) error {
+	return runMigrator(c, pi2go.Operation(c.mode))
+}
+
+// runMerge merges the source into the target, copying or moving the clips of merged notes.
+func runMerge(c *cliOptions) error {
+	c.MoveClips = c.mode == "move"
+	return runMigrator(c, pi2go.OperationMerge)
+}
+
+// runExport writes the target back in BirdNET-Pi format.
+func runExport(c *cliOptions) error {
+	opts := c.export
+	if opts.DetectionsDBPath == "" {
+		return errors.New("-out is required, the path of the BirdNET-Pi database to write")
+	}
+	if c.SkipAudioTransfer {
+		opts.ClipsDir = ""
+	} else if opts.BirdSongsDir == "" {
+		return errors.New("-out-dir is required to export clips, or pass -skip-audio-transfer")
+	}
+
+	ctx, stop := notifyContext()
+	defer stop()
+
+	result, err := pi2go.Export(ctx, opts)
+	if errors.Is(err, pi2go.ErrDetectionsDBExists) {
+		return fmt.Errorf("%s already exists, pass -force to write into it", opts.DetectionsDBPath)
+	}
+	fmt.Printf("Notes: %d read, %d exported, %d already in %s, %d failed.\n",
+		result.Notes, result.Exported, result.Skipped, opts.DetectionsDBPath, result.Failed)
+	if opts.ClipsDir != "" {
+		fmt.Printf("Clips: %d copied (%s), %d already present, %d missing, %d failed.\n",
+			result.ClipsCopied, formatBytes(result.BytesCopied), result.ClipsExisting, result.ClipsMissing, result.ClipErrors)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Interrupted, run the same command again with -force to resume.")
+		os.Exit(130)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export: %w", err)
+	}
+	return nil
+}
+
+// runInspect profiles the source.
+func runInspect(c *cliOptions) error {
+	ctx, stop := notifyContext()
+	defer stop()
+
+	inspection, err := pi2go.Inspect(ctx, c.Options)
+	if err != nil {
+		return fmt.Errorf("failed to inspect: %w", err)
+	}
+
+	if c.jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inspection)
+	}
+	printInspection(inspection, c.TargetDBPath)
+	return nil
+}
+
+// runDiff compares the source with the target, failing if they differ.
+func runDiff(c *cliOptions) error {
+	ctx, stop := notifyContext()
+	defer stop()
+
+	diff, err := pi2go.DiffDatabases(ctx, c.Options)
+	if err != nil {
+		return fmt.Errorf("failed to diff: %w", err)
+	}
+
+	if c.jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diff); err != nil {
+			return err
+		}
+	} else {
+		printDiff(diff)
+	}
+
+	if !diff.Equal() {
+		return errors.New("databases differ")
+	}
+	return nil
+}
+
+// runVerify checks the target database and clips, failing if either has a problem.
+func runVerify(c *cliOptions) error {
+	v, err := pi2go.Verify(c.TargetDBPath, c.TargetFilesDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database %s: integrity %s, %d notes, %d duplicates.\n", c.TargetDBPath, v.Integrity, v.Notes, v.Duplicates)
+	if c.TargetFilesDir != "" {
+		fmt.Printf("Clips in %s: %d of %d present, %d missing.\n", c.TargetFilesDir, v.ClipsPresent, v.WithClip, v.ClipsMissing)
+		for _, path := range v.Missing {
+			fmt.Printf("  missing %s\n", path)
+		}
+	}
+
+	if !v.OK() {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// printUsage lists the commands.
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "birdnet-pi2go: Convert birdnet-pi data to birdnet-go.")
+	fmt.Fprintln(out, "This tool is provided 'AS IS', without warranty of any kind.")
+	fmt.Fprintln(out, "Please ensure you have backed up your data before using this tool.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Usage: birdnet-pi2go <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'birdnet-pi2go <command> -h' for the flags of a command.")
+}
+
+// usageStatus is the exit status for invalid command lines.
+const usageStatus = 2
+
+// exitUsage prints the usage with an error message and exits.
+func exitUsage(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n\n", args...)
+	printUsage(os.Stderr)
+	os.Exit(usageStatus)
+}
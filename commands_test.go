@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+func TestCommandParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		check   func(c *cliOptions) bool
+		wantErr bool
+	}{
+		{
+			name:    "Migrate defaults",
+			command: "migrate",
+			check: func(c *cliOptions) bool {
+				return c.mode == "copy" && c.SourceDBPath == "birds.db" && c.TargetDBPath == "birdnet.db" &&
+					c.TargetFilesDir == "clips" && c.Snapshot && !c.SkipBackup
+			},
+		},
+		{
+			name:    "Move without backup",
+			command: "migrate",
+			args:    []string{"-mode", "move", "-source-dir", "BirdSongs", "-backup=false"},
+			check:   func(c *cliOptions) bool { return c.mode == "move" && c.SourceFilesDir == "BirdSongs" && c.SkipBackup },
+		},
+		{name: "Invalid mode", command: "migrate", args: []string{"-mode", "merge"}, wantErr: true},
+		{name: "Unexpected argument", command: "migrate", args: []string{"birds.db"}, wantErr: true},
+		{name: "Flag of another command", command: "rollback", args: []string{"-source-dir", "BirdSongs"}, wantErr: true},
+		{
+			name:    "Remote source uses remote default paths",
+			command: "migrate",
+			args:    []string{"-source", "ssh://pi@birdnetpi.local/home/pi/BirdNET-Pi"},
+			check:   func(c *cliOptions) bool { return c.SourceDBPath == "" && c.SourceFilesDir == "" },
+		},
+		{
+			name:    "Remote source with explicit database",
+			command: "merge",
+			args:    []string{"-source", "http://birdnetpi.local/", "-source-db", "scripts/birds.db"},
+			check:   func(c *cliOptions) bool { return c.SourceDBPath == "scripts/birds.db" },
+		},
+		{
+			name:    "Merge moving clips",
+			command: "merge",
+			args:    []string{"-source-db", "other.db", "-source-dir", "other/clips", "-mode", "move", "-skip-missing-clips"},
+			check: func(c *cliOptions) bool {
+				return c.mode == "move" && c.SourceFilesDir == "other/clips" && c.TargetFilesDir == "clips" && c.SkipMissingClips
+			},
+		},
+		{
+			name:    "Merge of several stations",
+			command: "merge",
+			args: []string{"-station", "garden", "-location", "60.17,24.94",
+				"-add-source", "db=lake/birds.db,dir=lake/BirdSongs,station=lake,location=61.5;23.8", "-add-source", "db=north.db"},
+			check: func(c *cliOptions) bool {
+				return c.Station == "garden" && *c.Location == pi2go.Location{Latitude: 60.17, Longitude: 24.94} && len(c.Sources) == 2 &&
+					c.Sources[0].Station == "lake" && c.Sources[0].FilesDir == "lake/BirdSongs" && c.Sources[0].Location.Latitude == 61.5 &&
+					c.Sources[1].DBPath == "north.db" && c.Sources[1].Location == nil
+			},
+		},
+		{
+			name:    "Migrate with overrides",
+			command: "migrate",
+			args:    []string{"-override", "lat=60.17,lon=24.94", "-override", "from=2023-06-01,sensitivity=1.25"},
+			check: func(c *cliOptions) bool {
+				return len(c.Overrides) == 2 && *c.Overrides[0].Latitude == 60.17 && c.Overrides[0].Threshold == nil &&
+					c.Overrides[1].From == "2023-06-01" && *c.Overrides[1].Sensitivity == 1.25
+			},
+		},
+		{
+			name:    "Merge with a taxonomy and locale",
+			command: "merge",
+			args:    []string{"-taxonomy", "renames.csv", "-locale", "fi"},
+			check: func(c *cliOptions) bool {
+				return c.TaxonomyPath == "renames.csv" && !c.RemapTaxonomy && c.Locale == "fi"
+			},
+		},
+		{name: "Snapshot of a sync", command: "sync", args: []string{"-snapshot=false"}, wantErr: true},
+		{name: "Remote source of a sync", command: "sync", args: []string{"-source", "ssh://pi@birdnetpi.local"}, wantErr: true},
+		{name: "Text log of a sync", command: "sync", args: []string{"-source-txt", "BirdDB.txt"}, wantErr: true},
+		{name: "Invalid override", command: "sync", args: []string{"-override", "lat=north"}, wantErr: true},
+		{name: "Invalid location", command: "merge", args: []string{"-location", "95,24"}, wantErr: true},
+		{name: "Invalid merge mode", command: "merge", args: []string{"-mode", "link"}, wantErr: true},
+		{
+			name:    "Rollback of a run",
+			command: "rollback",
+			args:    []string{"-run", "20240301T101500.123456Z", "-yes"},
+			check:   func(c *cliOptions) bool { return c.RollbackRun == "20240301T101500.123456Z" && c.assumeYes },
+		},
+		{
+			name:    "Non-interactive",
+			command: "sync",
+			args:    []string{"-non-interactive", "-interval", "5m"},
+			check:   func(c *cliOptions) bool { return c.assumeYes && c.SyncInterval.Minutes() == 5 },
+		},
+		{
+			name:    "Export to BirdSongs",
+			command: "export",
+			args:    []string{"-out", "export.db", "-out-dir", "BirdSongs", "-overlap", "0.5"},
+			check: func(c *cliOptions) bool {
+				return c.export.NotesDBPath == "birdnet.db" && c.export.ClipsDir == "clips" && c.export.DetectionsDBPath == "export.db" &&
+					c.export.BirdSongsDir == "BirdSongs" && c.export.Overlap == 0.5 && !c.export.Force
+			},
+		},
+		{
+			name:    "Diff as JSON",
+			command: "diff",
+			args:    []string{"-source-db", "other.db", "-json"},
+			check: func(c *cliOptions) bool {
+				return c.SourceDBPath == "other.db" && c.TargetDBPath == "birdnet.db" && c.jsonOutput
+			},
+		},
+		{
+			name:    "Inspect without a target",
+			command: "inspect",
+			args:    []string{"-source-dir", "BirdSongs", "-json"},
+			check: func(c *cliOptions) bool {
+				return c.SourceDBPath == "birds.db" && c.SourceFilesDir == "BirdSongs" && c.TargetDBPath == "" && c.jsonOutput
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := findCommand(tt.command).parse(tt.args, io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.check(c) {
+				t.Errorf("parse() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestCommandsHaveOperations(t *testing.T) {
+	t.Parallel()
+
+	// Every operation of the library is reachable from a command
+	operations := map[pi2go.Operation]string{
+		pi2go.OperationCopy:     "migrate",
+		pi2go.OperationMove:     "migrate",
+		pi2go.OperationSync:     "sync",
+		pi2go.OperationMerge:    "merge",
+		pi2go.OperationRollback: "rollback",
+	}
+	for operation, name := range operations {
+		if findCommand(name) == nil {
+			t.Errorf("no %s command for operation %s", name, operation)
+		}
+	}
+
+	if _, err := findCommand("verify").parse([]string{"-h"}, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("parse(-h) error = %v, want %v", err, flag.ErrHelp)
+	}
+}
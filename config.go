@@ -0,0 +1,96 @@
+// file config.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig reads a YAML or TOML config file, chosen by its extension, into a map of flag
+// names to values, with sections of command names holding the values of that command.
+func loadConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config %s is neither YAML (.yaml, .yml) nor TOML (.toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// applyConfig sets the flags of command in fs that were not given on the command line to the
+// values in the config file at path. Top-level values apply to the command, and values in a
+// section named after it override them; sections of other commands are ignored. Values that are
+// not flags of the command are an error, so a misspelled or misplaced option is never ignored.
+func applyConfig(fs *flag.FlagSet, path, command string) error {
+	values, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	effective := make(map[string]any)
+	for key, value := range values {
+		if _, ok := value.(map[string]any); ok {
+			if findCommand(key) == nil {
+				return fmt.Errorf("config %s: unknown section %q, sections are named after commands", path, key)
+			}
+			continue
+		}
+		effective[key] = value
+	}
+	if section, ok := values[command].(map[string]any); ok {
+		for key, value := range section {
+			effective[key] = value
+		}
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	keys := make([]string, 0, len(effective))
+	for key := range effective {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("config %s: %q is not an option of %s, move it to the section of the command it belongs to", path, key, command)
+		}
+		if given[key] {
+			continue
+		}
+
+		// A list sets a flag that can be given more than once for each of its values
+		list, ok := effective[key].([]any)
+		if !ok {
+			list = []any{effective[key]}
+		}
+		for _, value := range list {
+			if err := fs.Set(key, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("config %s: invalid value for %s: %w", path, key, err)
+			}
+		}
+	}
+
+	return nil
+}
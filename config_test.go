@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	t.Parallel()
+
+	yamlConfig := `
+target-db: /data/birdnet.db
+backup: false
+migrate:
+  source: ssh://pi@station1/home/pi/BirdNET-Pi
+  mode: move
+  target-db: /data/station1.db
+sync:
+  interval: 5m
+`
+	tomlConfig := `
+target-db = "/data/birdnet.db"
+backup = false
+
+[migrate]
+source = "ssh://pi@station1/home/pi/BirdNET-Pi"
+mode = "move"
+target-db = "/data/station1.db"
+http-concurrency = 8
+`
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		command string
+		args    []string
+		check   func(c *cliOptions) bool
+		wantErr bool
+	}{
+		{
+			name: "YAML section overrides top level", file: "station.yaml", content: yamlConfig, command: "migrate",
+			check: func(c *cliOptions) bool {
+				return c.mode == "move" && c.TargetDBPath == "/data/station1.db" && c.SkipBackup && c.Source != "" && c.SourceDBPath == ""
+			},
+		},
+		{
+			name: "TOML section overrides top level", file: "station.toml", content: tomlConfig, command: "migrate",
+			check: func(c *cliOptions) bool {
+				return c.mode == "move" && c.TargetDBPath == "/data/station1.db" && c.HTTPConcurrency == 8 && c.SkipBackup
+			},
+		},
+		{
+			name: "Flags override the file", file: "station.yaml", content: yamlConfig, command: "migrate",
+			args: []string{"-mode", "copy", "-backup"},
+			check: func(c *cliOptions) bool {
+				return c.mode == "copy" && !c.SkipBackup && c.TargetDBPath == "/data/station1.db"
+			},
+		},
+		{
+			name: "Sections of other commands are ignored", file: "station.yaml", content: yamlConfig, command: "rollback",
+			check: func(c *cliOptions) bool { return c.TargetDBPath == "/data/birdnet.db" && c.SkipBackup },
+		},
+		{name: "Option of another command", file: "station.yaml", content: "interval: 5m\n", command: "merge", wantErr: true},
+		{name: "Misspelled option", file: "station.yaml", content: "taget-db: birdnet.db\n", command: "merge", wantErr: true},
+		{name: "Unknown section", file: "station.toml", content: "[station1]\nsource = \"x\"\n", command: "merge", wantErr: true},
+		{name: "Invalid value", file: "station.yaml", content: "sync:\n  interval: often\n", command: "sync", wantErr: true},
+		{name: "Unsupported format", file: "station.json", content: "{}", command: "merge", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			args := append([]string{"-config", path}, tt.args...)
+			c, err := findCommand(tt.command).parse(args, io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.check(c) {
+				t.Errorf("parse() = %+v", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// file flags.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+// locationFlag is a flag holding a latitude and longitude as "lat,lon".
+type locationFlag struct {
+	location **pi2go.Location
+}
+
+func (f locationFlag) String() string {
+	if f.location == nil || *f.location == nil {
+		return ""
+	}
+	return formatLocation(*f.location)
+}
+
+func (f locationFlag) Set(value string) error {
+	location, err := parseLocation(value)
+	if err != nil {
+		return err
+	}
+	*f.location = location
+	return nil
+}
+
+// parseLocation parses a latitude and longitude given as "lat,lon".
+func parseLocation(value string) (*pi2go.Location, error) {
+	lat, lon, ok := strings.Cut(value, ",")
+	if !ok {
+		return nil, fmt.Errorf("location %q is not lat,lon", value)
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, fmt.Errorf("invalid latitude %q", lat)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("invalid longitude %q", lon)
+	}
+	return &pi2go.Location{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// formatLocation formats location as parseLocation parses it.
+func formatLocation(location *pi2go.Location) string {
+	return strconv.FormatFloat(location.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(location.Longitude, 'f', -1, 64)
+}
+
+// mergeSourcesFlag is a flag adding a further source to a merge each time it is given, as
+// comma separated db=, dir=, station= and location=lat;lon values.
+type mergeSourcesFlag struct {
+	sources *[]pi2go.MergeSource
+}
+
+func (f mergeSourcesFlag) String() string {
+	if f.sources == nil {
+		return ""
+	}
+	specs := make([]string, len(*f.sources))
+	for i, source := range *f.sources {
+		specs[i] = formatMergeSource(source)
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f mergeSourcesFlag) Set(value string) error {
+	source, err := parseMergeSource(value)
+	if err != nil {
+		return err
+	}
+	*f.sources = append(*f.sources, source)
+	return nil
+}
+
+// parseMergeSource parses a further source of a merge, such as
+// "db=station2/birds.db,dir=station2/BirdSongs,station=lake,location=61.5;23.8".
+func parseMergeSource(value string) (pi2go.MergeSource, error) {
+	var source pi2go.MergeSource
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return source, fmt.Errorf("source field %q is not key=value", field)
+		}
+		switch strings.TrimSpace(key) {
+		case "db":
+			source.DBPath = val
+		case "dir":
+			source.FilesDir = val
+		case "station":
+			source.Station = val
+		case "location":
+			location, err := parseLocation(strings.Replace(val, ";", ",", 1))
+			if err != nil {
+				return source, err
+			}
+			source.Location = location
+		default:
+			return source, fmt.Errorf("unknown source field %q, use db, dir, station or location", key)
+		}
+	}
+	if source.DBPath == "" {
+		return source, errors.New("source has no db=")
+	}
+	return source, nil
+}
+
+// formatMergeSource formats source as parseMergeSource parses it.
+func formatMergeSource(source pi2go.MergeSource) string {
+	fields := []string{"db=" + source.DBPath}
+	if source.FilesDir != "" {
+		fields = append(fields, "dir="+source.FilesDir)
+	}
+	if source.Station != "" {
+		fields = append(fields, "station="+source.Station)
+	}
+	if source.Location != nil {
+		fields = append(fields, "location="+strings.Replace(formatLocation(source.Location), ",", ";", 1))
+	}
+	return strings.Join(fields, ",")
+}
+
+// overridesFlag is a flag adding an override each time it is given, as comma separated from=,
+// to=, station=, lat=, lon=, threshold= and sensitivity= values.
+type overridesFlag struct {
+	overrides *[]pi2go.Override
+}
+
+func (f overridesFlag) String() string {
+	if f.overrides == nil {
+		return ""
+	}
+	specs := make([]string, len(*f.overrides))
+	for i, override := range *f.overrides {
+		specs[i] = formatOverride(override)
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f overridesFlag) Set(value string) error {
+	override, err := parseOverride(value)
+	if err != nil {
+		return err
+	}
+	*f.overrides = append(*f.overrides, override)
+	return nil
+}
+
+// parseOverride parses an override, such as "to=2023-05-31,lat=60.17,lon=24.94,sensitivity=1.25".
+// Dates and values are checked when the run starts.
+func parseOverride(value string) (pi2go.Override, error) {
+	var override pi2go.Override
+	for _, field := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return override, fmt.Errorf("override field %q is not key=value", field)
+		}
+
+		var target **float64
+		switch strings.TrimSpace(key) {
+		case "from":
+			override.From = val
+			continue
+		case "to":
+			override.To = val
+			continue
+		case "station":
+			override.Station = val
+			continue
+		case "lat":
+			target = &override.Latitude
+		case "lon":
+			target = &override.Longitude
+		case "threshold":
+			target = &override.Threshold
+		case "sensitivity":
+			target = &override.Sensitivity
+		default:
+			return override, fmt.Errorf("unknown override field %q, use from, to, station, lat, lon, threshold or sensitivity", key)
+		}
+
+		number, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return override, fmt.Errorf("invalid %s %q", key, val)
+		}
+		*target = &number
+	}
+	return override, nil
+}
+
+// formatOverride formats override as parseOverride parses it.
+func formatOverride(override pi2go.Override) string {
+	var fields []string
+	for _, field := range []struct{ key, value string }{
+		{"from", override.From}, {"to", override.To}, {"station", override.Station},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.key+"="+field.value)
+		}
+	}
+	for _, field := range []struct {
+		key   string
+		value *float64
+	}{
+		{"lat", override.Latitude}, {"lon", override.Longitude},
+		{"threshold", override.Threshold}, {"sensitivity", override.Sensitivity},
+	} {
+		if field.value != nil {
+			fields = append(fields, field.key+"="+strconv.FormatFloat(*field.value, 'f', -1, 64))
+		}
+	}
+	return strings.Join(fields, ",")
+}
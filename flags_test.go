@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+func TestParseMergeSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    pi2go.MergeSource
+		wantErr bool
+	}{
+		{name: "Database only", value: "db=station2.db", want: pi2go.MergeSource{DBPath: "station2.db"}},
+		{
+			name:  "All fields",
+			value: "db=lake/birds.db,dir=lake/BirdSongs,station=lake,location=61.5;-23.8",
+			want: pi2go.MergeSource{DBPath: "lake/birds.db", FilesDir: "lake/BirdSongs", Station: "lake",
+				Location: &pi2go.Location{Latitude: 61.5, Longitude: -23.8}},
+		},
+		{name: "No database", value: "station=lake", wantErr: true},
+		{name: "Unknown field", value: "db=station2.db,site=lake", wantErr: true},
+		{name: "Not key=value", value: "station2.db", wantErr: true},
+		{name: "Location out of range", value: "db=station2.db,location=61.5;200", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseMergeSource(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMergeSource(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMergeSource(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+			if formatted := formatMergeSource(got); formatted != tt.value {
+				t.Errorf("formatMergeSource() = %q, want %q", formatted, tt.value)
+			}
+		})
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	t.Parallel()
+
+	latitude, longitude, sensitivity, threshold := 60.17, 24.94, 1.25, 0.7
+	tests := []struct {
+		name    string
+		value   string
+		want    pi2go.Override
+		wantErr bool
+	}{
+		{name: "Location", value: "lat=60.17,lon=24.94", want: pi2go.Override{Latitude: &latitude, Longitude: &longitude}},
+		{
+			name:  "Date range of a station",
+			value: "from=2023-01-01,to=2023-05-31,station=lake,threshold=0.7,sensitivity=1.25",
+			want:  pi2go.Override{From: "2023-01-01", To: "2023-05-31", Station: "lake", Threshold: &threshold, Sensitivity: &sensitivity},
+		},
+		{name: "Invalid number", value: "lat=north", wantErr: true},
+		{name: "Unknown field", value: "confidence=0.5", wantErr: true},
+		{name: "Not key=value", value: "2023-01-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseOverride(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOverride(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOverride(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+			if formatted := formatOverride(got); formatted != tt.value {
+				t.Errorf("formatOverride() = %q, want %q", formatted, tt.value)
+			}
+		})
+	}
+}
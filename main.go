@@ -2,146 +2,377 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
-)
-
-// FileOperationType defines the type of operation to perform on the audio files.
-type FileOperationType int
+	"syscall"
 
-const (
-	CopyFile FileOperationType = iota
-	MoveFile
+	"github.com/tphakala/birdnet-pi2go/pi2go"
 )
 
 func main() {
-	// Define command-line flags.
-	var (
-		sourceDBPath      string = "birds.db"   // BirdNET-Pi database.
-		targetDBPath      string = "birdnet.db" // BirdNET-Go database.
-		sourceFilesDir    string                // BirdNET-Pi audio files directory.
-		targetFilesDir    string = "clips"      // BirdNET-Go audio files directory.
-		operationFlag     string = "copy"       // copy or move audio clips
-		skipAudioTransfer bool   = false        // skip copying audio files
-	)
-
-	// Register flags.
-	flag.StringVar(&sourceDBPath, "source-db", sourceDBPath, "Path to the BirdNET-Pi SQLite database.")
-	flag.StringVar(&targetDBPath, "target-db", targetDBPath, "Path to the BirdNET-Go SQLite database.")
-	flag.StringVar(&sourceFilesDir, "source-dir", "", "Directory path for BirdNET-Pi BirdSongs.")
-	flag.StringVar(&targetFilesDir, "target-dir", targetFilesDir, "Directory path for BirdNET-Go clips.")
-	// Split the long flag definition into two lines
-	flag.StringVar(&operationFlag, "operation", "",
-		"Operation to perform on audio files: 'copy' or 'move'.")
-	flag.BoolVar(&skipAudioTransfer, "skip-audio-transfer", skipAudioTransfer,
-		"Skip transferring audio files and only perform database migration. true/false.")
-
-	// Parse the provided flags.
-	flag.Parse()
-
-	// Ensure database paths are provided; other parameters are optional.
-	if operationFlag == "" {
-		fmt.Println("birdnet-pi2go: Convert birdnet-pi data to birdnet-go.")
-		fmt.Println("This tool is provided 'AS IS', without warranty of any kind.")
-		fmt.Println("Please ensure you have backed up your data before using this tool.")
-		fmt.Println("Usage:")
-		flag.PrintDefaults() // Print default help messages.
-		os.Exit(1)           // Exit after displaying help message.
-	}
-
-	// Initialize file operation type.
-	var operation FileOperationType
-
-	// Determine the file operation based on the operation flag, if directories are provided.
-
-	switch operationFlag {
-	case "move":
-		if !skipAudioTransfer {
-			if sourceFilesDir == "" {
-				log.Fatal("Source directory is required for move operation.")
-			}
-			// Confirm that the user has backed up their data before proceeding with the move operation.
-			fmt.Print("Have you backed up your data and wish to proceed with the move operation? (yes/no): ")
-			reader := bufio.NewReader(os.Stdin)
-			response, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatal("Failed to read response:", err)
-			}
-
-			if strings.TrimSpace(strings.ToLower(response)) != "yes" {
-				fmt.Println("Operation aborted by the user. Ensure data is backed up before attempting to move files.")
-				os.Exit(1)
-			}
+	args := os.Args[1:]
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		os.Exit(usageStatus)
+	}
+
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 && findCommand(args[1]) != nil {
+			cmd := findCommand(args[1])
+			cmd.newFlagSet(&cliOptions{}, os.Stdout).Usage()
+			return
+		}
+		printUsage(os.Stdout)
+		return
+	case strings.HasPrefix(name, "-"):
+		exitUsage("Flags follow a command; -operation copy or move is now 'migrate -mode copy' or 'migrate -mode move'; " +
+			"-operation merge, sync or rollback is now the command of that name.")
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		exitUsage("Unknown command %q.", name)
+	}
+
+	c, err := cmd.parse(args[1:], os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case errors.Is(err, errUsage):
+		os.Exit(usageStatus)
+	case err != nil:
+		log.Fatal(err)
+	}
+
+	if err := cmd.run(c); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runMigrator runs operation with the options of c, showing its progress and result.
+func runMigrator(c *cliOptions, operation pi2go.Operation) error {
+	opts := c.Options
+	opts.Operation = operation
+
+	// Show progress on stderr, with log lines printed above it
+	progress := newProgressView(os.Stderr)
+	log.SetOutput(progress)
+	defer log.SetOutput(os.Stderr)
+	opts.Progress = progress.Update
+
+	// Show what the run is about to do, and unless running unattended confirm that the user has
+	// backed up their data before proceeding with anything that moves or deletes it.
+	opts.Confirm = func(plan *pi2go.Plan) bool {
+		progress.Clear()
+		printPlan(plan)
+		if !plan.Destructive() || c.assumeYes {
+			return true
 		}
-		operation = MoveFile
-	// Inside the "copy" case in main.go
-	case "copy":
-		if !skipAudioTransfer {
-			if sourceFilesDir == "" {
-				log.Fatal("Source directory is required for copy operation.")
-			}
-
-			if targetFilesDir == "" {
-				log.Fatal("Target directory is required for copy operation.")
-			}
-			// Split the long line into two
-			enoughSpace, err := checkDiskSpace(sourceFilesDir, targetFilesDir)
-			if err != nil {
-				log.Fatal("Failed to check disk space:", err)
-			}
-
-			if !enoughSpace {
-				log.Fatal("Insufficient space on target volume")
-			}
+		return confirm(fmt.Sprintf("Have you backed up your data and wish to proceed with the %s operation?", plan.Operation))
+	}
+
+	migrator, err := pi2go.New(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Operation == pi2go.OperationSync {
+		fmt.Println("Press Ctrl-C to stop syncing.")
+	}
+
+	ctx, stop := notifyContext()
+	defer stop()
+
+	result, err := migrator.Run(ctx)
+	progress.Finish()
+	log.SetOutput(os.Stderr)
+
+	if c.reportPath != "" {
+		if err := pi2go.NewReport(opts, result, err).WriteFile(c.reportPath); err != nil {
+			log.Printf("Failed to write report: %v", err)
 		}
-		operation = CopyFile
-	case "merge":
-		// Merge existing BirdNET-Go database to migrated data.
-		err := MergeDatabases(sourceDBPath, targetDBPath)
-		if err != nil {
-			log.Fatal("Failed to merge databases:", err)
+	}
+	if errors.Is(err, pi2go.ErrInsufficientSpace) {
+		printSpaceEstimate(result.Space)
+		return err
+	}
+	if errors.Is(err, pi2go.ErrNotConfirmed) {
+		fmt.Println("Operation aborted by the user. Ensure data is backed up before attempting to move or delete files.")
+		os.Exit(1)
+	}
+	if errors.Is(err, context.Canceled) {
+		printInterrupted(result, opts.TargetDBPath)
+		os.Exit(130)
+	}
+	if err != nil {
+		if c.assumeYes {
+			printBackup(result)
+		} else {
+			offerRestore(result, opts.TargetDBPath)
+		}
+		return fmt.Errorf("failed to %s: %w", opts.Operation, err)
+	}
+
+	printResult(result)
+	return nil
+}
+
+// stdin reads answers to confirmation prompts.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question and reports whether it was answered with yes. Without an
+// answer, such as when input is not a terminal, the answer is no.
+func confirm(question string) bool {
+	fmt.Print(question + " (yes/no): ")
+	response, err := stdin.ReadString('\n')
+	if err != nil && response == "" {
+		fmt.Println()
+		fmt.Println("No answer given; pass -yes to run without confirmation.")
+		return false
+	}
+
+	return strings.TrimSpace(strings.ToLower(response)) == "yes"
+}
+
+// offerRestore offers to restore the target database from the backup taken before a failed run.
+func offerRestore(result *pi2go.Result, targetDBPath string) {
+	if result.BackupPath == "" || result.Restored {
+		return
+	}
+
+	if !confirm(fmt.Sprintf("The run failed. Restore %s from the backup %s?", targetDBPath, result.BackupPath)) {
+		return
+	}
+	if err := pi2go.RestoreBackup(result.BackupPath, targetDBPath); err != nil {
+		log.Printf("Failed to restore the target database: %v", err)
+		return
+	}
+	fmt.Printf("Restored %s from %s.\n", targetDBPath, result.BackupPath)
+	if result.ClipsTransferred > 0 {
+		fmt.Printf("The %d clips transferred by the run are still in place, roll back run %s to undo them.\n", result.ClipsTransferred, result.RunID)
+	}
+}
+
+// printSpaceEstimate prints what a copy or move will transfer and the space it needs per volume.
+func printSpaceEstimate(estimate *pi2go.SpaceEstimate) {
+	if estimate == nil {
+		return
+	}
+
+	fmt.Printf("Records to migrate: %d, growing the target database by about %s.\n", estimate.Records, formatBytes(estimate.DatabaseBytes))
+	if estimate.Clips+estimate.ClipsInTarget+estimate.ClipsMissing > 0 {
+		fmt.Printf("Clips to transfer: %d (%s), %d already in the target, %d missing from the source.\n",
+			estimate.Clips, formatBytes(estimate.ClipBytes), estimate.ClipsInTarget, estimate.ClipsMissing)
+	}
+	if estimate.InPlace {
+		fmt.Println("Clips are moved within one volume and need no additional space.")
+	}
+	if estimate.BackupBytes > 0 {
+		fmt.Printf("Backup of the target database: %s.\n", formatBytes(estimate.BackupBytes))
+	}
+	for _, volume := range estimate.Volumes {
+		status := "OK"
+		if !volume.Sufficient() {
+			status = "INSUFFICIENT"
 		}
+		fmt.Printf("Space needed on the volume of %s for %s: %s including a %s margin, %s free: %s.\n",
+			volume.Path, strings.Join(volume.For, ", "), formatBytes(volume.Needed), formatBytes(volume.Margin),
+			formatBytes(int64(volume.Free)), status)
+	}
+}
+
+// printBackup prints where the backup taken before a failed run is, for unattended runs that
+// are not offered to restore it.
+func printBackup(result *pi2go.Result) {
+	if result.BackupPath == "" || result.Restored {
 		return
-	default:
-		log.Fatal("Invalid operation. Use 'copy' or 'move'.") // Handle invalid operation value.
 	}
+	fmt.Printf("The target database was backed up to %s before the run.\n", result.BackupPath)
+}
 
-	// Call the conversion and transfer function with the parsed parameters.
-	// If sourceFilesDir and targetFilesDir are empty, file operations are skipped.
-	convertAndTransferData(sourceDBPath, targetDBPath, sourceFilesDir, targetFilesDir, operation, skipAudioTransfer)
+// printPlan prints what a run is about to do, with what it moves or deletes and from where.
+func printPlan(plan *pi2go.Plan) {
+	printSpaceEstimate(plan.Space)
+
+	switch {
+	case plan.Operation == pi2go.OperationRollback:
+		fmt.Printf("Rolling back run %s of %s: %d notes will be deleted.\n", plan.RunID, plan.TargetDBPath, plan.NotesDeleted)
+		if plan.ClipsMoved > 0 {
+			fmt.Printf("%d clips (%s) will be moved back to the BirdNET-Pi source directory.\n", plan.ClipsMoved, formatBytes(plan.BytesMoved))
+		}
+		if plan.ClipsDeleted > 0 {
+			fmt.Printf("%d copied clips (%s) will be deleted from the target directory.\n", plan.ClipsDeleted, formatBytes(plan.BytesDeleted))
+		}
+	case plan.ClipsMoved > 0:
+		source := plan.SourceFilesDir
+		if plan.Source != "" {
+			source = plan.Source + " " + source
+		}
+		fmt.Printf("%d clips (%s) will be moved from %s to %s, deleting them from the source.\n",
+			plan.ClipsMoved, formatBytes(plan.BytesMoved), source, plan.TargetFilesDir)
+	}
 }
 
-// calculateDirSize calculates the total size of all files within a directory.
-func calculateDirSize(dirPath string) (int64, error) {
-	var totalSize int64
-	err := filepath.Walk(dirPath, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// printInspection prints the profile of a source, and where a migration into targetDBPath resumes.
+func printInspection(inspection *pi2go.Inspection, targetDBPath string) {
+	fmt.Printf("Detections: %d, from %s to %s.\n", inspection.Rows, inspection.FirstDate, inspection.LastDate)
+	if len(inspection.ExtraColumns) > 0 {
+		fmt.Printf("Columns not migrated: %s.\n", strings.Join(inspection.ExtraColumns, ", "))
+	}
+	if len(inspection.MissingColumns) > 0 {
+		fmt.Printf("BirdNET-Pi columns missing: %s.\n", strings.Join(inspection.MissingColumns, ", "))
+	}
+	if inspection.BadDates > 0 {
+		fmt.Printf("Rows with an invalid date or time, migrated without a clip: %d.\n", inspection.BadDates)
+		for _, row := range inspection.BadDateRows {
+			fmt.Printf("  rowid %d: %q %q\n", row.RowID, row.Date, row.Time)
+		}
+	}
+
+	fmt.Printf("Species: %d.\n", len(inspection.Species))
+	for _, species := range inspection.Species {
+		fmt.Printf("  %7d  %s (%s)\n", species.Notes, species.CommonName, species.ScientificName)
+	}
+
+	fmt.Println("Confidence:")
+	for _, bucket := range inspection.Confidence {
+		fmt.Printf("  %.1f-%.1f  %d\n", bucket.Min, bucket.Max, bucket.Detections)
+	}
+
+	fmt.Printf("Station locations: %d.\n", len(inspection.Stations))
+	for _, station := range inspection.Stations {
+		fmt.Printf("  %.4f, %.4f  %d detections from %s to %s\n",
+			station.Latitude, station.Longitude, station.Detections, station.FirstDate, station.LastDate)
+	}
+
+	if inspection.ClipsChecked {
+		fmt.Printf("Clips: %d referenced, %d present, %d missing.\n", inspection.ClipsReferenced, inspection.ClipsPresent, inspection.ClipsMissing)
+		for _, year := range inspection.AudioByYear {
+			fmt.Printf("  %s  %d clips, %s\n", year.Year, year.Clips, formatBytes(year.Bytes))
 		}
+	}
 
-		if !info.IsDir() {
-			totalSize += info.Size() // Add file size if it's not a directory.
+	if r := inspection.Resume; r != nil {
+		switch r.From {
+		case "checkpoint":
+			fmt.Printf("%s: resumes after the checkpoint at rowid %d (%s %s)", targetDBPath, r.RowID, r.Date, r.Time)
+		case "snapshot":
+			fmt.Printf("%s: resumes after rowid %d of the previous snapshot run", targetDBPath, r.RowID)
+		case "last note":
+			fmt.Printf("%s: resumes after its latest note, %s %s", targetDBPath, r.Date, r.Time)
+		default:
+			fmt.Printf("%s: starts from the first detection", targetDBPath)
 		}
-		return nil
-	})
-	return totalSize, err // Return the total size and any error encountered.
+		fmt.Printf(", %d detections to migrate, %d notes already in it.\n", r.Pending, r.TargetNotes)
+	}
 }
 
-// checkDiskSpace checks if the target directory has enough free space for transferring files from the source directory.
-func checkDiskSpace(sourceDir, targetDir string) (bool, error) {
-	sourceSize, err := calculateDirSize(sourceDir)
-	if err != nil {
-		return false, err
+// printDiff prints the records only in the source with <, the notes only in the target with >
+// and the records whose matching note differs with ~, followed by a summary.
+func printDiff(diff *pi2go.Diff) {
+	format := func(r *pi2go.DiffRecord) string {
+		return fmt.Sprintf("%s %s %s (%s) confidence %.4f at %.4f, %.4f %s",
+			r.Date, r.Time, r.CommonName, r.ScientificName, r.Confidence, r.Latitude, r.Longitude, r.Verified)
 	}
 
-	freeSpace, err := getFreeSpace(targetDir)
-	if err != nil {
-		return false, err
+	for i := range diff.OnlyInSourceRows {
+		fmt.Printf("< %s\n", format(&diff.OnlyInSourceRows[i]))
+	}
+	for i := range diff.OnlyInTargetRows {
+		fmt.Printf("> %s\n", format(&diff.OnlyInTargetRows[i]))
+	}
+	for i := range diff.ChangedRows {
+		change := &diff.ChangedRows[i]
+		fmt.Printf("~ %s\n  %s differs from note %d: %s\n",
+			format(&change.Source), strings.Join(change.Fields, ", "), change.Target.ID, format(&change.Target))
+	}
+
+	fmt.Printf("Source %s: %d, target notes: %d. Identical: %d, changed: %d, only in source: %d, only in target: %d.\n",
+		diff.SourceTable, diff.SourceRows, diff.TargetRows, diff.Identical, diff.Changed, diff.OnlyInSource, diff.OnlyInTarget)
+	if shown := len(diff.OnlyInSourceRows) + len(diff.OnlyInTargetRows) + len(diff.ChangedRows); shown < diff.OnlyInSource+diff.OnlyInTarget+diff.Changed {
+		fmt.Println("Only the first differences of each kind are listed, the counts include them all.")
+	}
+}
+
+// notifyContext returns a context that is cancelled by the first SIGINT or SIGTERM, letting the
+// batch in progress finish and be checkpointed. A second signal exits immediately.
+func notifyContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nStopping after the current batch, press Ctrl-C again to exit immediately.")
+		cancel()
+
+		if _, ok := <-signals; !ok {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Exiting immediately, the current batch is discarded.")
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+// printInterrupted prints how far an interrupted operation got and how to resume it.
+func printInterrupted(result *pi2go.Result, targetDBPath string) {
+	fmt.Printf("Interrupted after %d of %d records: %d notes inserted, %d clips transferred.\n",
+		result.Processed, result.Total, result.NotesInserted, result.ClipsTransferred)
+	if result.LastRowID > 0 {
+		fmt.Printf("Progress up to source rowid %d (%s %s) is saved in %s.\n",
+			result.LastRowID, result.LastDate, result.LastTime, targetDBPath)
+	}
+	fmt.Println("Run the same command again to resume.")
+}
+
+// printResult prints the summary of a finished copy, move, merge or rollback operation.
+func printResult(result *pi2go.Result) {
+	if result.Operation == pi2go.OperationRollback {
+		fmt.Printf("Rolled back run %s: %d notes removed, %d clips restored.\n", result.RunID, result.NotesRemoved, result.ClipsRestored)
+		return
+	}
+	if result.NotesOverridden > 0 {
+		fmt.Printf("Overrides changed the location or detection parameters of %d notes.\n", result.NotesOverridden)
+	}
+	for _, remap := range result.Remapped {
+		fmt.Printf("Remapped %s to %s (%s) in %d notes.\n", remap.From, remap.To, remap.CommonName, remap.Notes)
+	}
+	if result.NotesTranslated > 0 {
+		fmt.Printf("Translated the common names of %d notes.\n", result.NotesTranslated)
+	}
+	if len(result.Untranslated) > 0 {
+		fmt.Printf("Kept the recorded common names of %d notes of %d species missing from the labels: %s.\n",
+			result.NotesUntranslated, len(result.Untranslated), strings.Join(result.Untranslated, ", "))
+	}
+	if result.ClipsNotRemoved > 0 {
+		fmt.Printf("Warning: %d moved clips could not be removed from the source and were left in place, see the log.\n", result.ClipsNotRemoved)
+	}
+	if result.Operation == pi2go.OperationMerge {
+		fmt.Printf("Notes: %d merged, %d failed, %d skipped for a missing clip. Clips: %d transferred (%s), %d missing, %d failed.\n",
+			result.NotesInserted, result.NoteErrors, result.NotesSkipped, result.ClipsTransferred, formatBytes(result.BytesTransferred), result.ClipsMissing, result.ClipErrors)
+		return
+	}
+	if result.Operation != pi2go.OperationCopy && result.Operation != pi2go.OperationMove {
+		return
 	}
 
-	return uint64(sourceSize) <= freeSpace, nil
+	fmt.Printf("Notes: %d inserted, %d failed. Clips: %d transferred (%s), %d missing, %d failed.\n",
+		result.NotesInserted, result.NoteErrors, result.ClipsTransferred, formatBytes(result.BytesTransferred), result.ClipsMissing, result.ClipErrors)
+	fmt.Println("Data conversion and file transfer completed successfully.")
 }
@@ -0,0 +1,651 @@
+// file archivefs.go
+package pi2go
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errReadOnlyFS is returned by write operations on a read-only filesystem.
+var errReadOnlyFS = errors.New("read-only filesystem")
+
+// archiveFormat identifies the container format of a backup archive.
+type archiveFormat int
+
+const (
+	archiveTar archiveFormat = iota
+	archiveTarGz
+	archiveZip
+)
+
+// archiveFormatFromPath returns the archive format for a path based on its extension.
+func archiveFormatFromPath(name string) (archiveFormat, bool) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz, true
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar, true
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip, true
+	default:
+		return 0, false
+	}
+}
+
+// isArchivePath reports whether a path names a supported backup archive.
+func isArchivePath(name string) bool {
+	_, ok := archiveFormatFromPath(name)
+	return ok
+}
+
+// archiveEntry describes a file or directory inside an archive.
+type archiveEntry struct {
+	name    string // Slash separated path inside the archive
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	isDir   bool
+	index   int       // Position of the entry in the archive
+	offset  int64     // Offset of the file data in an uncompressed tar archive
+	zipFile *zip.File // File handle for zip archives
+}
+
+// archiveFileInfo implements fs.FileInfo for archive entries.
+type archiveFileInfo struct{ entry *archiveEntry }
+
+func (i archiveFileInfo) Name() string       { return path.Base(i.entry.name) }
+func (i archiveFileInfo) Size() int64        { return i.entry.size }
+func (i archiveFileInfo) Mode() fs.FileMode  { return i.entry.mode }
+func (i archiveFileInfo) ModTime() time.Time { return i.entry.modTime }
+func (i archiveFileInfo) IsDir() bool        { return i.entry.isDir }
+func (i archiveFileInfo) Sys() any           { return nil }
+
+// ArchiveFS implements a read-only FileSystem backed by a .tar, .tar.gz or .zip backup archive.
+// Paths are relative to Root, the directory inside the archive that holds the BirdSongs tree.
+// Tar and zip archives support random access; compressed tarballs can only be streamed, so
+// reads are fastest in the order reported by ReadOrder.
+type ArchiveFS struct {
+	Root string
+
+	archivePath string
+	format      archiveFormat
+	entries     map[string]*archiveEntry
+
+	mu        sync.Mutex
+	file      *os.File // Open archive file for tar archives
+	zipReader *zip.ReadCloser
+	gzReader  *gzip.Reader
+	stream    *tar.Reader // Sequential reader for compressed tarballs
+	streamPos int         // Index of the next entry the stream will return
+}
+
+// NewArchiveFS opens and indexes a backup archive. The root is set to the directory that
+// contains Extracted/By_Date, so the filesystem can stand in for the BirdSongs directory.
+func NewArchiveFS(archivePath string) (*ArchiveFS, error) {
+	format, ok := archiveFormatFromPath(archivePath)
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive format: %s", archivePath)
+	}
+
+	a := &ArchiveFS{
+		archivePath: archivePath,
+		format:      format,
+		entries:     make(map[string]*archiveEntry),
+	}
+
+	var err error
+	if format == archiveZip {
+		err = a.indexZip()
+	} else {
+		err = a.indexTar()
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	a.Root = a.findBirdSongsRoot()
+	return a, nil
+}
+
+// indexZip reads the central directory of a zip archive.
+func (a *ArchiveFS) indexZip() error {
+	zr, err := zip.OpenReader(a.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	a.zipReader = zr
+
+	for i, f := range zr.File {
+		a.addEntry(&archiveEntry{
+			name:    f.Name,
+			size:    int64(f.UncompressedSize64),
+			mode:    f.Mode(),
+			modTime: f.Modified,
+			isDir:   f.FileInfo().IsDir(),
+			index:   i,
+			zipFile: f,
+		})
+	}
+
+	return nil
+}
+
+// countingReader tracks how many bytes have been read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// indexTar scans a tar or compressed tar archive once, recording every entry and, for
+// uncompressed archives, the offset of its data for random access.
+func (a *ArchiveFS) indexTar() error {
+	file, err := os.Open(a.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	var r io.Reader = file
+	if a.format == archiveTarGz {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for i := 0; ; i++ {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		a.addEntry(&archiveEntry{
+			name:    header.Name,
+			size:    header.Size,
+			mode:    header.FileInfo().Mode(),
+			modTime: header.ModTime,
+			isDir:   header.Typeflag == tar.TypeDir,
+			index:   i,
+			offset:  counter.n,
+		})
+	}
+
+	if a.format == archiveTar {
+		// Keep the file open for random access reads
+		a.file = file
+		return nil
+	}
+
+	return file.Close()
+}
+
+// addEntry records an entry and synthesizes its parent directories, which archives may omit.
+func (a *ArchiveFS) addEntry(entry *archiveEntry) {
+	entry.name = cleanArchivePath(entry.name)
+	if entry.name == "" {
+		return
+	}
+	a.entries[entry.name] = entry
+
+	for dir := path.Dir(entry.name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := a.entries[dir]; ok {
+			break
+		}
+		a.entries[dir] = &archiveEntry{name: dir, mode: fs.ModeDir | 0o755, isDir: true, index: -1}
+	}
+}
+
+// cleanArchivePath normalizes a path inside an archive to a clean, relative, slash separated form.
+func cleanArchivePath(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// findBirdSongsRoot returns the directory inside the archive that contains Extracted/By_Date.
+func (a *ArchiveFS) findBirdSongsRoot() string {
+	root := ""
+	found := false
+	for name, entry := range a.entries {
+		if !entry.isDir || path.Base(name) != "By_Date" || path.Base(path.Dir(name)) != "Extracted" {
+			continue
+		}
+		candidate := path.Dir(path.Dir(name))
+		if candidate == "." {
+			candidate = ""
+		}
+		// Prefer the shallowest match for a deterministic result
+		if !found || len(candidate) < len(root) || (len(candidate) == len(root) && candidate < root) {
+			root, found = candidate, true
+		}
+	}
+
+	return root
+}
+
+// FindFile returns the archive path of the shallowest file with the given base name.
+func (a *ArchiveFS) FindFile(baseName string) (string, bool) {
+	var match string
+	for name, entry := range a.entries {
+		if entry.isDir || path.Base(name) != baseName {
+			continue
+		}
+		if match == "" || strings.Count(name, "/") < strings.Count(match, "/") ||
+			(strings.Count(name, "/") == strings.Count(match, "/") && name < match) {
+			match = name
+		}
+	}
+
+	return match, match != ""
+}
+
+// ExtractFile copies a file from the archive, addressed by its full archive path, to a local file.
+func (a *ArchiveFS) ExtractFile(archiveName, destPath string) error {
+	entry, ok := a.entries[cleanArchivePath(archiveName)]
+	if !ok || entry.isDir {
+		return fmt.Errorf("%s not found in archive %s", archiveName, a.archivePath)
+	}
+
+	reader, err := a.openEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to extract %s: %w", archiveName, err)
+	}
+
+	return out.Close()
+}
+
+// lookup resolves a path relative to Root to an archive entry.
+func (a *ArchiveFS) lookup(name string) (*archiveEntry, bool) {
+	entry, ok := a.entries[cleanArchivePath(path.Join(a.Root, filepath.ToSlash(name)))]
+	return entry, ok
+}
+
+// openEntry returns a reader for the data of an archive entry.
+func (a *ArchiveFS) openEntry(entry *archiveEntry) (io.ReadCloser, error) {
+	switch a.format {
+	case archiveZip:
+		return entry.zipFile.Open()
+	case archiveTar:
+		return io.NopCloser(io.NewSectionReader(a.file, entry.offset, entry.size)), nil
+	default:
+		return a.openStreamEntry(entry)
+	}
+}
+
+// openStreamEntry reads an entry from a compressed tarball. The stream only moves forward,
+// so it is restarted from the beginning when an earlier entry is requested.
+func (a *ArchiveFS) openStreamEntry(entry *archiveEntry) (io.ReadCloser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stream == nil || entry.index < a.streamPos {
+		if err := a.restartStream(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		header, err := a.stream.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", entry.name, err)
+		}
+		a.streamPos++
+
+		if cleanArchivePath(header.Name) != entry.name {
+			continue
+		}
+
+		// Clips are small, buffering the entry releases the stream for the next read
+		data, err := io.ReadAll(a.stream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", entry.name, err)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// restartStream reopens a compressed tarball at its first entry.
+func (a *ArchiveFS) restartStream() error {
+	a.closeStream()
+
+	file, err := os.Open(a.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to read gzip archive: %w", err)
+	}
+
+	a.file = file
+	a.gzReader = gz
+	a.stream = tar.NewReader(gz)
+	a.streamPos = 0
+	return nil
+}
+
+// closeStream releases the sequential reader of a compressed tarball.
+func (a *ArchiveFS) closeStream() {
+	if a.gzReader != nil {
+		a.gzReader.Close()
+		a.gzReader = nil
+	}
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	a.stream = nil
+}
+
+// Close releases the archive.
+func (a *ArchiveFS) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closeStream()
+	if a.zipReader != nil {
+		err := a.zipReader.Close()
+		a.zipReader = nil
+		return err
+	}
+
+	return nil
+}
+
+// Sequential reports whether the archive can only be read efficiently in ReadOrder.
+func (a *ArchiveFS) Sequential() bool {
+	return a.format == archiveTarGz
+}
+
+// ReadOrder returns the position of a file in the archive, files not in the archive sort last.
+func (a *ArchiveFS) ReadOrder(name string) int {
+	if entry, ok := a.lookup(name); ok && !entry.isDir {
+		return entry.index
+	}
+	return int(^uint(0) >> 1)
+}
+
+// TotalSize returns the uncompressed size of all files below Root.
+func (a *ArchiveFS) TotalSize() int64 {
+	var total int64
+	prefix := a.Root
+	if prefix != "" {
+		prefix += "/"
+	}
+	for name, entry := range a.entries {
+		if !entry.isDir && strings.HasPrefix(name, prefix) {
+			total += entry.size
+		}
+	}
+
+	return total
+}
+
+func (a *ArchiveFS) MkdirAll(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: errReadOnlyFS}
+}
+
+func (a *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	entry, ok := a.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return archiveFileInfo{entry: entry}, nil
+}
+
+func (a *ArchiveFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnlyFS}
+}
+
+func (a *ArchiveFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: errReadOnlyFS}
+}
+
+func (a *ArchiveFS) Open(name string) (io.ReadCloser, error) {
+	entry, ok := a.lookup(name)
+	if !ok || entry.isDir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return a.openEntry(entry)
+}
+
+func (a *ArchiveFS) ReadFile(name string) ([]byte, error) {
+	reader, err := a.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (a *ArchiveFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: errReadOnlyFS}
+}
+
+func (a *ArchiveFS) FileExists(name string) bool {
+	entry, ok := a.lookup(name)
+	return ok && !entry.isDir
+}
+
+// sequentialFS is implemented by filesystems that are only efficient when files are read
+// in a fixed order, such as compressed archives.
+type sequentialFS interface {
+	FileSystem
+	Sequential() bool
+	ReadOrder(name string) int
+}
+
+// MountFS routes operations on paths below MountPoint to Mounted, with the path made relative
+// to the mount point, and everything else to Base. It lets a source directory be read from
+// inside a backup archive while clips are written to the local disk.
+type MountFS struct {
+	Base       FileSystem
+	MountPoint string
+	Mounted    FileSystem
+}
+
+// route returns the filesystem responsible for a path and the path to use with it.
+func (m *MountFS) route(name string) (FileSystem, string) {
+	rel, err := filepath.Rel(filepath.Clean(m.MountPoint), filepath.Clean(name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return m.Base, name
+	}
+	return m.Mounted, rel
+}
+
+func (m *MountFS) MkdirAll(name string, perm fs.FileMode) error {
+	target, p := m.route(name)
+	return target.MkdirAll(p, perm)
+}
+
+func (m *MountFS) Stat(name string) (fs.FileInfo, error) {
+	target, p := m.route(name)
+	return target.Stat(p)
+}
+
+func (m *MountFS) Remove(name string) error {
+	target, p := m.route(name)
+	return target.Remove(p)
+}
+
+func (m *MountFS) Create(name string) (io.WriteCloser, error) {
+	target, p := m.route(name)
+	return target.Create(p)
+}
+
+func (m *MountFS) Open(name string) (io.ReadCloser, error) {
+	target, p := m.route(name)
+	return target.Open(p)
+}
+
+func (m *MountFS) ReadFile(name string) ([]byte, error) {
+	target, p := m.route(name)
+	return target.ReadFile(p)
+}
+
+func (m *MountFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	target, p := m.route(name)
+	return target.WriteFile(p, data, perm)
+}
+
+func (m *MountFS) FileExists(name string) bool {
+	target, p := m.route(name)
+	return target.FileExists(p)
+}
+
+// Sequential reports whether the mounted filesystem must be read in order.
+func (m *MountFS) Sequential() bool {
+	seq, ok := m.Mounted.(sequentialFS)
+	return ok && seq.Sequential()
+}
+
+// ReadOrder returns the read position of a path on the mounted filesystem.
+func (m *MountFS) ReadOrder(name string) int {
+	target, p := m.route(name)
+	if seq, ok := target.(sequentialFS); ok {
+		return seq.ReadOrder(p)
+	}
+	return 0
+}
+
+// sortByReadOrder orders pending clips so that their sources are read in the order
+// preferred by a sequential filesystem.
+func sortByReadOrder(clips []pendingClip, sourceFilesDir string, seqFS sequentialFS) {
+	order := make([]int, len(clips))
+	for i := range clips {
+		order[i] = int(^uint(0) >> 1)
+		detection := clips[i].detection()
+		for _, candidate := range sourceClipPaths(&detection, sourceFilesDir) {
+			if seqFS.FileExists(candidate) {
+				order[i] = seqFS.ReadOrder(candidate)
+				break
+			}
+		}
+	}
+
+	indexes := make([]int, len(clips))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool { return order[indexes[i]] < order[indexes[j]] })
+
+	sorted := make([]pendingClip, len(clips))
+	for i, idx := range indexes {
+		sorted[i] = clips[idx]
+	}
+	copy(clips, sorted)
+}
+
+// prepareArchiveSource resolves source paths that point at backup archives. A database inside
+// an archive is extracted to a temporary file, and a BirdSongs directory inside an archive is
+// returned as archiveFS, to be mounted at the archive path so the rest of the migration is
+// unchanged. The returned cleanup function closes the archives and removes temporary files.
+func prepareArchiveSource(sourceDBPath, sourceFilesDir string, logger *log.Logger) (dbPath string, archiveFS *ArchiveFS, cleanup func(), err error) {
+	var cleanups []func()
+	cleanup = func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	dbPath = sourceDBPath
+	archives := make(map[string]*ArchiveFS)
+	openArchive := func(archivePath string) (*ArchiveFS, error) {
+		if a, ok := archives[archivePath]; ok {
+			return a, nil
+		}
+		a, err := NewArchiveFS(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		archives[archivePath] = a
+		cleanups = append(cleanups, func() { a.Close() })
+		return a, nil
+	}
+
+	if isArchivePath(sourceDBPath) {
+		a, err := openArchive(sourceDBPath)
+		if err != nil {
+			cleanup()
+			return "", nil, nil, err
+		}
+
+		name, ok := a.FindFile("birds.db")
+		if !ok {
+			cleanup()
+			return "", nil, nil, fmt.Errorf("birds.db not found in archive %s", sourceDBPath)
+		}
+
+		tempDir, err := os.MkdirTemp("", "birdnet-pi2go-archive-")
+		if err != nil {
+			cleanup()
+			return "", nil, nil, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		cleanups = append(cleanups, func() { os.RemoveAll(tempDir) })
+
+		dbPath = filepath.Join(tempDir, "birds.db")
+		if err := a.ExtractFile(name, dbPath); err != nil {
+			cleanup()
+			return "", nil, nil, err
+		}
+		logger.Printf("Extracted %s from %s", name, sourceDBPath)
+	}
+
+	if isArchivePath(sourceFilesDir) {
+		archiveFS, err = openArchive(sourceFilesDir)
+		if err != nil {
+			cleanup()
+			return "", nil, nil, err
+		}
+		if _, err := archiveFS.Stat(filepath.Join("Extracted", "By_Date")); err != nil {
+			cleanup()
+			return "", nil, nil, fmt.Errorf("Extracted/By_Date not found in archive %s", sourceFilesDir)
+		}
+		logger.Printf("Reading BirdSongs from %s:/%s", sourceFilesDir, archiveFS.Root)
+	}
+
+	return dbPath, archiveFS, cleanup, nil
+}
@@ -0,0 +1,321 @@
+package pi2go
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// archiveTestFile is a file to be written into a test archive
+type archiveTestFile struct {
+	name    string
+	content []byte
+}
+
+// createTestArchive writes files into a new archive whose format follows the file extension
+func createTestArchive(t *testing.T, archivePath string, files []archiveTestFile) {
+	t.Helper()
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer out.Close()
+
+	format, _ := archiveFormatFromPath(archivePath)
+	if format == archiveZip {
+		zw := zip.NewWriter(out)
+		for _, f := range files {
+			w, err := zw.Create(f.name)
+			if err != nil {
+				t.Fatalf("Failed to add %s to zip: %v", f.name, err)
+			}
+			if _, err := w.Write(f.content); err != nil {
+				t.Fatalf("Failed to write %s to zip: %v", f.name, err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Failed to close zip: %v", err)
+		}
+		return
+	}
+
+	var w io.Writer = out
+	if format == archiveTarGz {
+		gz := gzip.NewWriter(out)
+		defer gz.Close()
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to add %s to tar: %v", f.name, err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			t.Fatalf("Failed to write %s to tar: %v", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+}
+
+// birdSongsArchiveFiles returns the files of a typical BirdNET-Pi home directory backup
+func birdSongsArchiveFiles(dbContent []byte) []archiveTestFile {
+	return []archiveTestFile{
+		{"home/pi/BirdNET-Pi/scripts/birds.db", dbContent},
+		{"home/pi/BirdSongs/Extracted/By_Date/2023-01-16/Great_Tit/tit.mp3", []byte("tit audio")},
+		{"home/pi/BirdSongs/Extracted/By_Date/2023-01-15/Test Bird/test.mp3", []byte("test audio")},
+		{"home/pi/BirdSongs/Extracted/Charts/chart.png", []byte("chart")},
+	}
+}
+
+func TestArchiveFS(t *testing.T) {
+	t.Parallel()
+
+	for _, ext := range []string{".tar", ".tar.gz", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			t.Parallel()
+
+			archivePath := filepath.Join(t.TempDir(), "backup"+ext)
+			createTestArchive(t, archivePath, birdSongsArchiveFiles([]byte("db")))
+
+			archiveFS, err := NewArchiveFS(archivePath)
+			if err != nil {
+				t.Fatalf("NewArchiveFS() error = %v", err)
+			}
+			defer archiveFS.Close()
+
+			if archiveFS.Root != "home/pi/BirdSongs" {
+				t.Errorf("Root = %q, want home/pi/BirdSongs", archiveFS.Root)
+			}
+
+			testClip := filepath.Join("Extracted", "By_Date", "2023-01-15", "Test Bird", "test.mp3")
+			titClip := filepath.Join("Extracted", "By_Date", "2023-01-16", "Great_Tit", "tit.mp3")
+
+			// Read out of archive order to exercise restarting compressed streams
+			for _, name := range []string{testClip, titClip, testClip} {
+				if !archiveFS.FileExists(name) {
+					t.Errorf("FileExists(%s) = false, want true", name)
+				}
+				if _, err := archiveFS.ReadFile(name); err != nil {
+					t.Errorf("ReadFile(%s) error = %v", name, err)
+				}
+			}
+
+			data, err := archiveFS.ReadFile(testClip)
+			if err != nil || string(data) != "test audio" {
+				t.Errorf("ReadFile() = %q, %v, want %q", data, err, "test audio")
+			}
+
+			info, err := archiveFS.Stat(filepath.Join("Extracted", "By_Date"))
+			if err != nil || !info.IsDir() {
+				t.Errorf("Stat() of synthesized directory = %v, %v, want directory", info, err)
+			}
+
+			if archiveFS.FileExists(filepath.Join("Extracted", "missing.mp3")) {
+				t.Errorf("FileExists() of missing file = true, want false")
+			}
+
+			if err := archiveFS.WriteFile("new.mp3", []byte("x"), 0o644); !errors.Is(err, errReadOnlyFS) {
+				t.Errorf("WriteFile() error = %v, want %v", err, errReadOnlyFS)
+			}
+			if err := archiveFS.Remove(testClip); !errors.Is(err, errReadOnlyFS) {
+				t.Errorf("Remove() error = %v, want %v", err, errReadOnlyFS)
+			}
+
+			if got, want := archiveFS.Sequential(), ext == ".tar.gz"; got != want {
+				t.Errorf("Sequential() = %v, want %v", got, want)
+			}
+			if archiveFS.ReadOrder(titClip) >= archiveFS.ReadOrder(testClip) {
+				t.Errorf("ReadOrder() does not follow archive order")
+			}
+
+			if size := archiveFS.TotalSize(); size != int64(len("tit audio")+len("test audio")+len("chart")) {
+				t.Errorf("TotalSize() = %d", size)
+			}
+
+			name, ok := archiveFS.FindFile("birds.db")
+			if !ok || name != "home/pi/BirdNET-Pi/scripts/birds.db" {
+				t.Errorf("FindFile(birds.db) = %q, %v", name, ok)
+			}
+		})
+	}
+
+	if _, err := NewArchiveFS(filepath.Join(t.TempDir(), "backup.rar")); err == nil {
+		t.Errorf("NewArchiveFS() with unsupported format did not return an error")
+	}
+}
+
+func TestMountFSTransferFromArchive(t *testing.T) {
+	t.Parallel()
+
+	archivePath := filepath.Join(t.TempDir(), "backup.zip")
+	createTestArchive(t, archivePath, birdSongsArchiveFiles([]byte("db")))
+
+	archiveFS, err := NewArchiveFS(archivePath)
+	if err != nil {
+		t.Fatalf("NewArchiveFS() error = %v", err)
+	}
+	defer archiveFS.Close()
+
+	mockFS := NewMockFS()
+	mountFS := &MountFS{Base: mockFS, MountPoint: archivePath, Mounted: archiveFS}
+
+	detection := &Detection{
+		Date:       "2023-01-15",
+		Time:       "13:45:30",
+		SciName:    "Testus birdus",
+		ComName:    "Test Bird",
+		Confidence: 0.85,
+		FileName:   "test.mp3",
+	}
+
+	if err := handleFileTransferWithFS(detection, archivePath, "/clips", CopyFile, mountFS); err != nil {
+		t.Fatalf("handleFileTransferWithFS() error = %v", err)
+	}
+
+	data, err := mockFS.ReadFile(filepath.Join("/clips", "2023", "01", "testus_birdus_85p_20230115T134530Z.mp3"))
+	if err != nil || string(data) != "test audio" {
+		t.Errorf("transferred clip = %q, %v, want %q", data, err, "test audio")
+	}
+
+	// Moving out of an archive must fail and leave the archive untouched
+	if err := handleFileTransferWithFS(detection, archivePath, "/clips", MoveFile, mountFS); err != nil {
+		t.Logf("move from archive returned: %v", err)
+	}
+	if !mountFS.FileExists(sourceClipPaths(detection, archivePath)[0]) {
+		t.Errorf("clip disappeared from archive after move")
+	}
+}
+
+func TestConvertAndTransferDataFromArchive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Build a source database and pack it together with the clips into a backup
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "13:45:30", SciName: "Testus birdus", ComName: "Test Bird", Confidence: 0.85, FileName: "test.mp3"},
+		{Date: "2023-01-16", Time: "09:15:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.92, FileName: "tit.mp3"},
+	})
+	dbContent, err := os.ReadFile(sourceDBPath)
+	if err != nil {
+		t.Fatalf("Failed to read source database: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	archivePath := filepath.Join(tempDir, "backup.tar.gz")
+	createTestArchive(t, archivePath, birdSongsArchiveFiles(dbContent))
+
+	dbPath, archiveFS, cleanup, err := prepareArchiveSource(archivePath, archivePath, log.Default())
+	if err != nil {
+		t.Fatalf("prepareArchiveSource() error = %v", err)
+	}
+	if archiveFS == nil {
+		t.Fatalf("prepareArchiveSource() did not mount the archive")
+	}
+
+	cleanup()
+
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("extracted database was not removed after cleanup")
+	}
+
+	// The migration prepares and cleans up the source itself
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	result := runMigration(t, Options{Operation: OperationCopy, SourceDBPath: archivePath, SourceFilesDir: archivePath, TargetDBPath: targetDBPath, TargetFilesDir: targetFilesDir})
+	if result.ClipsTransferred != 2 {
+		t.Errorf("clips transferred = %d, want 2", result.ClipsTransferred)
+	}
+	if _, ok := DefaultFS.(OsFS); !ok {
+		t.Errorf("DefaultFS was replaced by the migration")
+	}
+
+	verifyNoteCount(t, targetDBPath, 2)
+
+	for name, want := range map[string]string{
+		filepath.Join(targetFilesDir, "2023", "01", "testus_birdus_85p_20230115T134530Z.mp3"): "test audio",
+		filepath.Join(targetFilesDir, "2023", "01", "parus_major_92p_20230116T091500Z.mp3"):   "tit audio",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("clip %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+}
+
+func TestResumeTransfersFromArchive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "13:45:30", SciName: "Testus birdus", ComName: "Test Bird", Confidence: 0.85, FileName: "test.mp3"},
+		{Date: "2023-01-16", Time: "09:15:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.92, FileName: "tit.mp3"},
+	})
+	dbContent, err := os.ReadFile(sourceDBPath)
+	if err != nil {
+		t.Fatalf("Failed to read source database: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	archivePath := filepath.Join(tempDir, "backup.tar.gz")
+	createTestArchive(t, archivePath, birdSongsArchiveFiles(dbContent))
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	opts := Options{Operation: OperationCopy, SourceDBPath: archivePath, SourceFilesDir: archivePath, TargetDBPath: targetDBPath, TargetFilesDir: targetFilesDir}
+
+	// Stop after the first deferred clip, when all notes and the checkpoint are committed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts.Progress = func(p Progress) {
+		if p.Stage == StageTransferring && p.ClipsTransferred > 0 {
+			cancel()
+		}
+	}
+	m, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := m.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if result.NotesInserted != 2 || result.ClipsTransferred != 1 {
+		t.Fatalf("interrupted Run() inserted %d notes and transferred %d clips, want 2 and 1", result.NotesInserted, result.ClipsTransferred)
+	}
+
+	// The resumed run inserts nothing again and transfers the remaining clip
+	opts.Progress = nil
+	result = runMigration(t, opts)
+	if result.NotesInserted != 0 || result.ClipsTransferred != 1 {
+		t.Errorf("resumed Run() inserted %d notes and transferred %d clips, want 0 and 1", result.NotesInserted, result.ClipsTransferred)
+	}
+	verifyNoteCount(t, targetDBPath, 2)
+
+	for name, want := range map[string]string{
+		filepath.Join(targetFilesDir, "2023", "01", "testus_birdus_85p_20230115T134530Z.mp3"): "test audio",
+		filepath.Join(targetFilesDir, "2023", "01", "parus_major_92p_20230116T091500Z.mp3"):   "tit audio",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("clip %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	if state := loadTestState(t, targetDBPath, checkpointPrefix+migrationStateKey(archivePath)); state != nil {
+		t.Errorf("checkpoint after resumed run = %+v, want none", state)
+	}
+}
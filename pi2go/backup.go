@@ -0,0 +1,159 @@
+// file backup.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// backupSuffix ends the name of target database backups, which are named after the target
+// database and the ID of the run they were taken for.
+const backupSuffix = ".bak"
+
+// backupTarget takes a backup of the target database before the run writes to it, unless
+// backups are turned off or there is no target database yet.
+func (m *migration) backupTarget() error {
+	if m.opts.SkipBackup {
+		return nil
+	}
+
+	backupPath, err := backupDB(m.opts.TargetDBPath, m.opts.TargetDBPath+"."+m.result.RunID+backupSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to back up target database: %w", err)
+	}
+	if backupPath != "" {
+		m.logger().Printf("Backed up %s to %s", m.opts.TargetDBPath, backupPath)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.BackupPath = backupPath
+	return nil
+}
+
+// restoreTarget restores the target database from the backup taken before a failed run.
+func (m *migration) restoreTarget() {
+	if m.result.BackupPath == "" {
+		return
+	}
+
+	if err := RestoreBackup(m.result.BackupPath, m.opts.TargetDBPath); err != nil {
+		m.logger().Printf("Failed to restore %s from %s: %v", m.opts.TargetDBPath, m.result.BackupPath, err)
+		return
+	}
+	m.logger().Printf("Restored %s from %s", m.opts.TargetDBPath, m.result.BackupPath)
+	if m.result.ClipsTransferred > 0 {
+		m.logger().Printf("The %d clips transferred by the run are still in place, roll back run %s to undo them", m.result.ClipsTransferred, m.result.RunID)
+	}
+	m.result.Restored = true
+}
+
+// backupDB copies the database at dbPath to backupPath with VACUUM INTO, which reads it in a
+// single transaction, so the copy is consistent even if another connection is writing to it.
+// The backup is verified to open as an intact database. If dbPath does not exist there is
+// nothing to back up and an empty path is returned.
+func backupDB(dbPath, backupPath string) (string, error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+dbPath+"?_pragma=busy_timeout(5000)"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return "", err
+	}
+	defer closeDB(db)
+
+	if err := db.Exec("VACUUM INTO ?", backupPath).Error; err != nil {
+		return "", err
+	}
+	if err := verifyBackup(backupPath); err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+
+	return backupPath, nil
+}
+
+// verifyBackup checks that the backup at path opens and passes SQLite's integrity check.
+func verifyBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("backup not accessible: %w", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer closeDB(db)
+
+	var result string
+	if err := db.Raw("PRAGMA quick_check").Scan(&result).Error; err != nil {
+		return fmt.Errorf("failed to check backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup %s is damaged: %s", path, result)
+	}
+
+	return nil
+}
+
+// RestoreBackup replaces the database at targetDBPath with the backup at backupPath, which is
+// kept. No other process may have the target database open.
+func RestoreBackup(backupPath, targetDBPath string) error {
+	if err := verifyBackup(backupPath); err != nil {
+		return err
+	}
+
+	// Copy next to the target and rename it into place, so the target is never half restored
+	tempPath := targetDBPath + ".restore"
+	if err := copyDBFile(backupPath, tempPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := os.Rename(tempPath, targetDBPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace target database: %w", err)
+	}
+
+	// Journals left by the replaced database would be applied to the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(targetDBPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale journal: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// copyDBFile copies the database file at src to dst on the local disk, whatever Options.FS is,
+// and flushes it to disk.
+func copyDBFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
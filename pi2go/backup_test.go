@@ -0,0 +1,135 @@
+package pi2go
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupDB(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	backupPath := filepath.Join(tempDir, "birdnet.db.bak")
+
+	// Nothing to back up before the target exists
+	if path, err := backupDB(targetDBPath, backupPath); err != nil || path != "" {
+		t.Fatalf("backupDB() without target = %q, %v, want no backup", path, err)
+	}
+
+	insertTestNotes(t, targetDBPath, 3)
+	path, err := backupDB(targetDBPath, backupPath)
+	if err != nil || path != backupPath {
+		t.Fatalf("backupDB() = %q, %v, want %q", path, err, backupPath)
+	}
+	verifyNoteCount(t, backupPath, 3)
+
+	// The target changes, restoring brings back the backed up notes
+	insertTestNotes(t, targetDBPath, 2)
+	if err := RestoreBackup(backupPath, targetDBPath); err != nil {
+		t.Fatalf("RestoreBackup() error = %v", err)
+	}
+	verifyNoteCount(t, targetDBPath, 3)
+
+	if _, err := backupDB(targetDBPath, backupPath); err == nil {
+		t.Error("backupDB() overwrote an existing backup")
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	damaged := filepath.Join(tempDir, "damaged.db")
+	os.WriteFile(damaged, []byte("not a database"), 0o644)
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "Missing", path: filepath.Join(tempDir, "missing.db")},
+		{name: "Not a database", path: damaged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := verifyBackup(tt.path); err == nil {
+				t.Error("verifyBackup() succeeded")
+			}
+			if err := RestoreBackup(tt.path, filepath.Join(tempDir, "birdnet.db")); err == nil {
+				t.Error("RestoreBackup() succeeded")
+			}
+		})
+	}
+}
+
+func TestRestoreOnFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	insertTestNotes(t, targetDBPath, 3)
+
+	// A source without detections fails after the target has been opened for writing
+	sourceDBPath := filepath.Join(tempDir, "birds.db")
+	insertTestNotes(t, sourceDBPath, 1)
+
+	tests := []struct {
+		name         string
+		opts         Options
+		wantBackup   bool
+		wantRestored bool
+	}{
+		{name: "Restored", opts: Options{RestoreOnFailure: true}, wantBackup: true, wantRestored: true},
+		{name: "Kept", opts: Options{}, wantBackup: true},
+		{name: "No backup", opts: Options{SkipBackup: true, RestoreOnFailure: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Operation, opts.SourceDBPath, opts.TargetDBPath, opts.SkipAudioTransfer = OperationCopy, sourceDBPath, targetDBPath, true
+
+			m, err := New(opts)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := m.Run(context.Background())
+			if err == nil {
+				t.Fatal("Run() succeeded without a detections table")
+			}
+
+			if (result.BackupPath != "") != tt.wantBackup || result.Restored != tt.wantRestored {
+				t.Errorf("BackupPath = %q, Restored = %v, want backup %v and restored %v", result.BackupPath, result.Restored, tt.wantBackup, tt.wantRestored)
+			}
+			if tt.wantBackup {
+				verifyNoteCount(t, result.BackupPath, 3)
+			}
+			verifyNoteCount(t, targetDBPath, 3)
+		})
+	}
+}
+
+// insertTestNotes adds count notes to the BirdNET-Go database at dbPath, creating it if needed.
+func insertTestNotes(t *testing.T, dbPath string, count int) {
+	t.Helper()
+
+	db, err := initializeAndMigrateTargetDB(dbPath, createGormLogger(log.Default()))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer closeDB(db)
+
+	for i := range count {
+		if err := db.Create(&Note{Date: "2023-01-15", Time: "10:00:00", ScientificName: "Corvus corax", Confidence: float64(i) / 10}).Error; err != nil {
+			t.Fatalf("Failed to insert note: %v", err)
+		}
+	}
+}
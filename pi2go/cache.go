@@ -0,0 +1,59 @@
+// file cache.go
+package pi2go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheKey returns a short stable name for a source, to be used in file names.
+func cacheKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
+
+// downloadCacheDir returns the directory the downloads of a remote source are kept in, below
+// the user cache directory and named after the source, so a run started after an interrupted
+// one finds its partial downloads. The directory is created if needed.
+func downloadCacheDir(source string) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+
+	dir := filepath.Join(base, "birdnet-pi2go", "downloads", cacheKey(source))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create download cache directory: %w", err)
+	}
+	return dir, nil
+}
+
+// removeDownload removes a downloaded file and its cache directory, which is left in place
+// while it holds the partial downloads of an interrupted run.
+func removeDownload(dir, path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+	os.Remove(dir)
+}
+
+// openPartFile opens localPath.part to append a download to. A partial download is only
+// continued if the remote file, of size bytes last modified at modTime, has not changed since
+// the partial download was last written to; otherwise it is started over. A negative size or
+// zero modTime means unknown, which also starts it over.
+func openPartFile(localPath string, size int64, modTime time.Time) (*os.File, error) {
+	partPath := localPath + ".part"
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+		if size < info.Size() || modTime.IsZero() || modTime.After(info.ModTime()) {
+			if err := os.Remove(partPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
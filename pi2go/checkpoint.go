@@ -0,0 +1,155 @@
+// file checkpoint.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// checkpointPrefix keys the progress of an unfinished copy or move in the migration state table.
+	checkpointPrefix = "checkpoint:"
+
+	// mergeCheckpointPrefix keys the progress of an unfinished merge.
+	mergeCheckpointPrefix = "merge:"
+)
+
+// rowDetection is a source detection together with its rowid, which serves as a resume cursor.
+type rowDetection struct {
+	RowID     int64  `gorm:"column:rowid"`
+	RawDate   string `gorm:"column:raw_date"` // Date as stored, the driver parses DATE columns into timestamps
+	Detection `gorm:"embedded"`
+}
+
+// sourceKey identifies a source across runs, independently of where it was downloaded or
+// extracted to. Detections imported from a text log have no stable rowids, so they get none.
+func sourceKey(opts *Options) string {
+	switch {
+	case opts.SourceTextPath != "":
+		return ""
+	case opts.Source != "":
+		return strings.TrimSpace(opts.Source + " " + opts.SourceDBPath)
+	default:
+		return migrationStateKey(opts.SourceDBPath)
+	}
+}
+
+// saveCheckpoint records that all source records up to and including last have been completed.
+// The date is stored as it appears in the source when known, so it compares equal to it on resume.
+func saveCheckpoint(tx *gorm.DB, key string, last *rowDetection) error {
+	date := last.Date
+	if last.RawDate != "" {
+		date = last.RawDate
+	}
+	state := &MigrationState{Source: key, LastRowID: last.RowID, LastDate: date, LastTime: last.Time}
+	if err := saveMigrationState(tx, state); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// clearCheckpoint removes the checkpoint of a finished run.
+func clearCheckpoint(targetDB *gorm.DB, key string) error {
+	if err := targetDB.Where("source = ?", key).Delete(&MigrationState{}).Error; err != nil {
+		return fmt.Errorf("failed to clear checkpoint: %w", err)
+	}
+	return nil
+}
+
+// formulateCheckpointQuery constructs a SQL WHERE clause selecting the detections that follow
+// checkpoint in migration order, limited to those added after afterRowID if it is set.
+func formulateCheckpointQuery(checkpoint *MigrationState, afterRowID int64) (whereClause string, params []any) {
+	whereClause = "(date > ? OR (date = ? AND time > ?) OR (date = ? AND time = ? AND rowid > ?))"
+	params = []any{checkpoint.LastDate, checkpoint.LastDate, checkpoint.LastTime, checkpoint.LastDate, checkpoint.LastTime, checkpoint.LastRowID}
+
+	if afterRowID > 0 {
+		whereClause += " AND rowid > ?"
+		params = append(params, afterRowID)
+	}
+
+	return whereClause, params
+}
+
+// resumeSelection constructs the SQL WHERE clause selecting the detections a copy or move still
+// has to migrate: those after the checkpoint of an interrupted run, otherwise those after the
+// high-water mark recorded by a previous snapshot run, otherwise those after the latest note in
+// the target database. Any of them may be nil.
+func resumeSelection(checkpoint, state *MigrationState, lastNote *Note) (whereClause string, params []any) {
+	switch {
+	case checkpoint != nil:
+		var afterRowID int64
+		if state != nil {
+			afterRowID = state.LastRowID
+		}
+		return formulateCheckpointQuery(checkpoint, afterRowID)
+	case state != nil:
+		return formulateRowIDQuery(state.LastRowID)
+	default:
+		return formulateQuery(lastNote)
+	}
+}
+
+// resumePoint is where a copy or move of the source would resume, as read from the target.
+type resumePoint struct {
+	checkpoint *MigrationState // Checkpoint of an interrupted run
+	state      *MigrationState // High-water mark of a previous snapshot run
+	lastNote   *Note           // Latest note in the target, looked up only without the above
+	notes      int64           // Notes in the target
+	size       int64           // Size of the target database file
+}
+
+// readResumePoint reads where a copy or move would resume from the target database, the way
+// convertAndTransferData does but without writing to it. A missing target resumes at the start.
+func (m *migration) readResumePoint() (*resumePoint, error) {
+	resume := &resumePoint{}
+	stat, err := os.Stat(m.opts.TargetDBPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return resume, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resume.size = stat.Size()
+
+	targetDB, err := gorm.Open(sqlite.Open("file:"+m.opts.TargetDBPath+"?mode=ro&_pragma=busy_timeout(5000)"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target database: %w", err)
+	}
+	defer closeDB(targetDB)
+
+	if targetDB.Migrator().HasTable(&MigrationState{}) {
+		if m.sourceKey != "" {
+			if resume.checkpoint, err = findMigrationState(targetDB, checkpointPrefix+m.sourceKey); err != nil {
+				return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+			}
+		}
+		if m.opts.Snapshot {
+			if resume.state, err = findMigrationState(targetDB, migrationStateKey(m.opts.SourceDBPath)); err != nil {
+				return nil, fmt.Errorf("failed to read migration state: %w", err)
+			}
+		}
+	}
+	if targetDB.Migrator().HasTable(&Note{}) {
+		if err := targetDB.Model(&Note{}).Count(&resume.notes).Error; err != nil {
+			return nil, fmt.Errorf("failed to count target notes: %w", err)
+		}
+		if resume.checkpoint == nil && resume.state == nil {
+			if resume.lastNote, err = findLastEntryInTargetDB(targetDB); err != nil {
+				return nil, fmt.Errorf("failed to find last entry in target database: %w", err)
+			}
+		}
+	}
+
+	return resume, nil
+}
+
+// selection returns the SQL WHERE clause selecting the detections still to migrate.
+func (r *resumePoint) selection() (whereClause string, params []any) {
+	return resumeSelection(r.checkpoint, r.state, r.lastNote)
+}
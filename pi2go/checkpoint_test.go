@@ -0,0 +1,251 @@
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// checkpointTestDetections returns count detections where runs of seven share the same date and
+// time, so batch boundaries fall between detections that only their rowid orders.
+func checkpointTestDetections(count int) []Detection {
+	detections := make([]Detection, count)
+	for i := range detections {
+		second := i / 7
+		detections[i] = Detection{
+			Date:       "2023-01-15",
+			Time:       fmt.Sprintf("%02d:%02d:%02d", second/3600, second/60%60, second%60),
+			SciName:    fmt.Sprintf("Species %d", i),
+			ComName:    fmt.Sprintf("Bird %d", i),
+			Confidence: 0.9,
+			FileName:   fmt.Sprintf("clip%d.mp3", i),
+		}
+	}
+	return detections
+}
+
+// setupBirdNETPiSourceDB creates a source database with the column types of BirdNET-Pi, whose
+// DATE column the driver returns as timestamps, holding the given detections.
+func setupBirdNETPiSourceDB(t *testing.T, detections []Detection) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "birds.db")
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to create source database: %v", err)
+	}
+	defer closeDB(db)
+
+	err = db.Exec(`CREATE TABLE detections (Date DATE, Time TIME, Sci_Name VARCHAR(100) NOT NULL, Com_Name VARCHAR(100) NOT NULL,
+		Confidence FLOAT, Lat FLOAT, Lon FLOAT, Cutoff FLOAT, Week INT, Sens FLOAT, Overlap FLOAT, File_Name VARCHAR(100) NOT NULL)`).Error
+	if err != nil {
+		t.Fatalf("Failed to create detections table: %v", err)
+	}
+	for i := range detections {
+		insertMockDetection(t, db, &detections[i])
+	}
+
+	return dbPath
+}
+
+// cancelAfterFirstBatch returns a context and a progress callback that cancels it once the
+// first batch of the given stage has been reported.
+func cancelAfterFirstBatch(stage Stage) (context.Context, ProgressFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, func(p Progress) {
+		if p.Stage == stage && p.Processed > 0 {
+			cancel()
+		}
+	}
+}
+
+// verifyDistinctNotes checks the target holds exactly count notes, none of them duplicated.
+func verifyDistinctNotes(t *testing.T, targetDBPath string, count int64) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+
+	var total, distinct int64
+	db.Model(&Note{}).Count(&total)
+	db.Raw("SELECT COUNT(DISTINCT scientific_name) FROM notes").Scan(&distinct)
+	if total != count || distinct != count {
+		t.Errorf("target has %d notes, %d distinct, want %d", total, distinct, count)
+	}
+}
+
+// loadTestState returns the migration state stored under key in the target database.
+func loadTestState(t *testing.T, targetDBPath, key string) *MigrationState {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+
+	state, err := loadMigrationState(db, key)
+	if err != nil {
+		t.Fatalf("loadMigrationState() error = %v", err)
+	}
+	return state
+}
+
+func TestFormulateCheckpointQuery(t *testing.T) {
+	t.Parallel()
+
+	checkpoint := &MigrationState{LastRowID: 42, LastDate: "2023-01-15", LastTime: "10:00:00"}
+
+	tests := []struct {
+		name       string
+		afterRowID int64
+		wantClause string
+		wantParams []any
+	}{
+		{
+			name:       "Checkpoint only",
+			wantClause: "(date > ? OR (date = ? AND time > ?) OR (date = ? AND time = ? AND rowid > ?))",
+			wantParams: []any{"2023-01-15", "2023-01-15", "10:00:00", "2023-01-15", "10:00:00", int64(42)},
+		},
+		{
+			name:       "Checkpoint after snapshot high-water mark",
+			afterRowID: 7,
+			wantClause: "(date > ? OR (date = ? AND time > ?) OR (date = ? AND time = ? AND rowid > ?)) AND rowid > ?",
+			wantParams: []any{"2023-01-15", "2023-01-15", "10:00:00", "2023-01-15", "10:00:00", int64(42), int64(7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			whereClause, params := formulateCheckpointQuery(checkpoint, tt.afterRowID)
+			if whereClause != tt.wantClause {
+				t.Errorf("formulateCheckpointQuery() whereClause = %q, want %q", whereClause, tt.wantClause)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("formulateCheckpointQuery() params = %v, want %v", params, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestSourceKey(t *testing.T) {
+	t.Parallel()
+
+	absDBPath, _ := filepath.Abs("birds.db")
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "Local database", opts: Options{SourceDBPath: "birds.db"}, want: absDBPath},
+		{name: "Archive", opts: Options{SourceDBPath: "/backup/pi.tar.gz"}, want: "/backup/pi.tar.gz"},
+		{name: "Remote source", opts: Options{Source: "ssh://pi@birdnetpi.local"}, want: "ssh://pi@birdnetpi.local"},
+		{name: "Remote database", opts: Options{Source: "http://birdnetpi.local/", SourceDBPath: "db/birds.db"}, want: "http://birdnetpi.local/ db/birds.db"},
+		{name: "Text log", opts: Options{SourceDBPath: "birds.db", SourceTextPath: "BirdDB.txt"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := sourceKey(&tt.opts); got != tt.want {
+				t.Errorf("sourceKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeAfterCancellation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	for _, snapshot := range []bool{false, true} {
+		t.Run(fmt.Sprintf("snapshot=%v", snapshot), func(t *testing.T) {
+			t.Parallel()
+
+			sourceDBPath := setupBirdNETPiSourceDB(t, checkpointTestDetections(2500))
+			targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+			opts := Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: snapshot}
+
+			// The first run is interrupted after its first batch
+			ctx, progress := cancelAfterFirstBatch(StageMigrating)
+			opts.Progress = progress
+			m, err := New(opts)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := m.Run(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			if result.NotesInserted != 1000 || result.LastRowID != 1000 {
+				t.Errorf("interrupted Run() result = %+v, want 1000 notes up to rowid 1000", result)
+			}
+			verifyDistinctNotes(t, targetDBPath, 1000)
+
+			checkpointKey := checkpointPrefix + migrationStateKey(sourceDBPath)
+			if state := loadTestState(t, targetDBPath, checkpointKey); state == nil || state.LastRowID != 1000 || state.LastDate != "2023-01-15" {
+				t.Fatalf("checkpoint after interruption = %+v, want rowid 1000 of 2023-01-15", state)
+			}
+
+			// The next run continues exactly after the checkpoint and clears it
+			opts.Progress = nil
+			result = runMigration(t, opts)
+			if result.Total != 1500 || result.NotesInserted != 1500 {
+				t.Errorf("resumed Run() result = %+v, want the remaining 1500 notes", result)
+			}
+			verifyDistinctNotes(t, targetDBPath, 2500)
+
+			if state := loadTestState(t, targetDBPath, checkpointKey); state != nil {
+				t.Errorf("checkpoint after completion = %+v, want none", state)
+			}
+		})
+	}
+}
+
+func TestMergeResumeAfterCancellation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	t.Parallel()
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, checkpointTestDetections(2500))
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+
+	ctx, progress := cancelAfterFirstBatch(StageMerging)
+	m, err := New(Options{Operation: OperationMerge, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, Progress: progress})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := m.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if result.NotesInserted != 1000 || result.LastRowID != 1000 {
+		t.Errorf("interrupted merge result = %+v, want 1000 notes up to rowid 1000", result)
+	}
+
+	result = runMigration(t, Options{Operation: OperationMerge, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath})
+	if result.Total != 1500 || result.NotesInserted != 1500 {
+		t.Errorf("resumed merge result = %+v, want the remaining 1500 notes", result)
+	}
+	verifyDistinctNotes(t, targetDBPath, 2500)
+
+	if state := loadTestState(t, targetDBPath, mergeCheckpointPrefix+migrationStateKey(sourceDBPath)); state != nil {
+		t.Errorf("merge checkpoint after completion = %+v, want none", state)
+	}
+}
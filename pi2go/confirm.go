@@ -0,0 +1,71 @@
+// file confirm.go
+package pi2go
+
+// Plan describes what a run is about to do, for Options.Confirm to approve before the run
+// writes anything. Paths are as given in Options.
+type Plan struct {
+	Operation      Operation
+	RunID          string // Run a rollback undoes
+	Source         string // Remote source, with any password removed
+	SourceDBPath   string
+	SourceFilesDir string
+	TargetDBPath   string
+	TargetFilesDir string
+
+	Space *SpaceEstimate // Records and clips a copy or move transfers and the space it needs
+
+	// What the run takes away from where it is now: the source clips of a move, or the notes
+	// of a rollback, the clips it moves back and the copied clips it deletes.
+	NotesDeleted int
+	ClipsMoved   int
+	BytesMoved   int64
+	ClipsDeleted int
+	BytesDeleted int64
+}
+
+// Destructive reports whether the run deletes notes or clips, or moves clips away.
+func (p *Plan) Destructive() bool {
+	return p.NotesDeleted > 0 || p.ClipsMoved > 0 || p.ClipsDeleted > 0
+}
+
+// newPlan returns the plan of the run with the paths it was given and nothing counted yet.
+func (m *migration) newPlan() *Plan {
+	return &Plan{
+		Operation:      m.opts.Operation,
+		Source:         redactURL(m.opts.Source),
+		SourceDBPath:   m.opts.SourceDBPath,
+		SourceFilesDir: m.opts.SourceFilesDir,
+		TargetDBPath:   m.opts.TargetDBPath,
+		TargetFilesDir: m.opts.TargetFilesDir,
+	}
+}
+
+// rollbackPlan counts the notes and clips the rollback of run header, with records, takes away.
+func (m *migration) rollbackPlan(header ManifestEntry, records []ManifestEntry) *Plan {
+	plan := m.newPlan()
+	plan.RunID = header.Run
+
+	for i := range records {
+		if records[i].Note != nil {
+			plan.NotesDeleted++
+		}
+		if clip := records[i].Clip; clip != nil {
+			if clip.moved(header.Operation) {
+				plan.ClipsMoved++
+				plan.BytesMoved += clip.Size
+			} else {
+				plan.ClipsDeleted++
+				plan.BytesDeleted += clip.Size
+			}
+		}
+	}
+	return plan
+}
+
+// confirm asks Options.Confirm to approve plan, returning ErrNotConfirmed if it does not.
+func (m *migration) confirm(plan *Plan) error {
+	if m.opts.Confirm != nil && !m.opts.Confirm(plan) {
+		return ErrNotConfirmed
+	}
+	return nil
+}
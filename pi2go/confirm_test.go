@@ -0,0 +1,92 @@
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfirmPlan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(2)
+	_, sourceDBPath := setupSnapshotSourceDB(t, detections)
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	for i := range detections {
+		path := sourceClipPaths(&detections[i], sourceFilesDir)[0]
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, make([]byte, 100), 0o644)
+	}
+
+	// run runs opts, answering confirmation with answer, and returns the plan it was asked about
+	run := func(opts Options, answer bool) (*Plan, *Result, error) {
+		var plan *Plan
+		opts.Confirm = func(p *Plan) bool {
+			plan = p
+			return answer
+		}
+		m, err := New(opts)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		result, err := m.Run(context.Background())
+		if plan == nil {
+			t.Fatalf("%s run was not confirmed", opts.Operation)
+		}
+		return plan, result, err
+	}
+
+	// A declined move leaves the source clips and creates no target
+	moveOpts := Options{Operation: OperationMove, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SourceFilesDir: sourceFilesDir, TargetFilesDir: targetFilesDir}
+	plan, _, err := run(moveOpts, false)
+	if !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("declined move error = %v, want %v", err, ErrNotConfirmed)
+	}
+	if !plan.Destructive() || plan.ClipsMoved != 2 || plan.BytesMoved != 200 || plan.SourceFilesDir != sourceFilesDir {
+		t.Errorf("move plan = %+v, want 2 clips of 200 bytes moved from %s", plan, sourceFilesDir)
+	}
+	if n := countFiles(t, sourceFilesDir); n != 2 {
+		t.Errorf("%d source clips left after a declined move, want 2", n)
+	}
+	if _, err := os.Stat(targetDBPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("target database created by a declined move")
+	}
+
+	// A copy takes nothing away
+	copyOpts := moveOpts
+	copyOpts.Operation = OperationCopy
+	plan, copied, err := run(copyOpts, true)
+	if err != nil {
+		t.Fatalf("copy error = %v", err)
+	}
+	if plan.Destructive() || plan.Space == nil || plan.Space.Clips != 2 {
+		t.Errorf("copy plan = %+v, want 2 clips copied and nothing taken away", plan)
+	}
+
+	// A rollback of the copy deletes its notes and clips, but only once confirmed
+	rollbackOpts := Options{Operation: OperationRollback, TargetDBPath: targetDBPath}
+	plan, _, err = run(rollbackOpts, false)
+	if !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("declined rollback error = %v, want %v", err, ErrNotConfirmed)
+	}
+	if plan.RunID != copied.RunID || plan.NotesDeleted != 2 || plan.ClipsDeleted != 2 || plan.BytesDeleted != 200 {
+		t.Errorf("rollback plan = %+v, want 2 notes and 2 clips of 200 bytes of run %s deleted", plan, copied.RunID)
+	}
+	verifyDistinctNotes(t, targetDBPath, 2)
+	if n := countFiles(t, targetFilesDir); n != 2 {
+		t.Errorf("%d target clips left after a declined rollback, want 2", n)
+	}
+
+	if _, _, err := run(rollbackOpts, true); err != nil {
+		t.Fatalf("rollback error = %v", err)
+	}
+	verifyDistinctNotes(t, targetDBPath, 0)
+}
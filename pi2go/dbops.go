@@ -0,0 +1,754 @@
+// Package pi2go converts BirdNET-Pi detections and audio clips to the BirdNET-Go data model.
+// file dbops.go
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Note represents a single observation data point.
+type Note struct {
+	// Standard GORM Model fields: ID, Date, etc.
+	ID             uint   `gorm:"primaryKey"`
+	Date           string `gorm:"index:idx_notes_date_commonname_confidence"`
+	Time           string
+	ScientificName string  `gorm:"index"`
+	CommonName     string  `gorm:"index;index:idx_notes_date_commonname_confidence"`
+	Confidence     float64 `gorm:"index:idx_notes_date_commonname_confidence"`
+	Latitude       float64
+	Longitude      float64
+	Threshold      float64
+	Sensitivity    float64
+	ClipName       string
+	Verified       string `gorm:"type:varchar(20);default:'unverified'"` // Status of the note verification
+}
+
+// Detection represents a detection event, directly mapping to the database structure.
+type Detection struct {
+	// Fields map directly to database columns with additional annotations for GORM.
+	Date       string  `gorm:"column:Date"`
+	Time       string  `gorm:"column:Time"`
+	SciName    string  `gorm:"column:Sci_Name"`
+	ComName    string  `gorm:"column:Com_Name"`
+	Confidence float64 `gorm:"column:Confidence"`
+	Lat        float64 `gorm:"column:Lat"`
+	Lon        float64 `gorm:"column:Lon"`
+	Cutoff     float64 `gorm:"column:Cutoff"`
+	Week       int     `gorm:"column:Week"`
+	Sens       float64 `gorm:"column:Sens"`
+	Overlap    float64 `gorm:"column:Overlap"`
+	FileName   string  `gorm:"column:File_Name"`
+}
+
+// TableName overrides the default table name.
+func (Detection) TableName() string {
+	return "detections"
+}
+
+// convertAndTransferData handles the main logic for data conversion and transfer.
+// When snapshot is set, the source database is first copied to a consistent snapshot and
+// the snapshot's high-water mark is recorded in the target so a later run resumes exactly
+// after the last migrated detection.
+func (m *migration) convertAndTransferData() error {
+	sourceDBPath, targetDBPath := m.opts.SourceDBPath, m.opts.TargetDBPath
+	newLogger := createGormLogger(m.logger())
+
+	// Check if source database file exists
+	if _, err := os.Stat(sourceDBPath); os.IsNotExist(err) {
+		return fmt.Errorf("source database file does not exist: %s", sourceDBPath)
+	}
+
+	// Take a consistent snapshot of the source database and read from it instead
+	readDBPath := sourceDBPath
+	var highWater int64
+	if m.opts.Snapshot {
+		snap, err := createSourceSnapshot(sourceDBPath)
+		if err != nil {
+			return fmt.Errorf("error creating source database snapshot: %w", err)
+		}
+		defer snap.Cleanup()
+
+		readDBPath = snap.Path
+		highWater = snap.HighWaterRowID
+		m.logger().Printf("Snapshot of %s taken at detections rowid %d", sourceDBPath, highWater)
+	}
+
+	// Connect to source database in read-only mode
+	sourceDB, err := initializeAndMigrateSourceDB(readDBPath, newLogger)
+	if err != nil {
+		return err
+	}
+	defer closeDB(sourceDB)
+
+	// Check if detections table exists
+	var count int64
+	err = sourceDB.Raw("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='detections'").Count(&count).Error
+	if err != nil || count == 0 {
+		return fmt.Errorf("detections table not found in source database: %s", sourceDBPath)
+	}
+
+	sourceRows, err := getTotalRecordCount(sourceDB, "")
+	if err != nil {
+		return err
+	}
+	m.setSourceRows(int64(sourceRows))
+
+	targetDB, err := initializeAndMigrateTargetDB(targetDBPath, newLogger)
+	if err != nil {
+		return err
+	}
+	defer closeDB(targetDB)
+	m.recordTargetRows(targetDB, false)
+	defer m.recordTargetRows(targetDB, true)
+
+	// Resume after the checkpoint of an interrupted run, or from the recorded high-water mark
+	// if a previous snapshot run left one, otherwise from the latest note in the target database
+	stateKey := migrationStateKey(sourceDBPath)
+	var state *MigrationState
+	if m.opts.Snapshot {
+		if state, err = loadMigrationState(targetDB, stateKey); err != nil {
+			return fmt.Errorf("error loading migration state: %w", err)
+		}
+	}
+
+	var checkpoint *MigrationState
+	if m.sourceKey != "" {
+		if checkpoint, err = loadMigrationState(targetDB, checkpointPrefix+m.sourceKey); err != nil {
+			return fmt.Errorf("error loading checkpoint: %w", err)
+		}
+	}
+
+	var lastNote *Note
+	switch {
+	case checkpoint != nil:
+		m.logger().Printf("Resuming interrupted run after detection %s %s (rowid %d)", checkpoint.LastDate, checkpoint.LastTime, checkpoint.LastRowID)
+		m.setLast(checkpoint.LastRowID, checkpoint.LastDate, checkpoint.LastTime)
+	case state != nil:
+		m.logger().Printf("Resuming after detections rowid %d (%s %s)", state.LastRowID, state.LastDate, state.LastTime)
+	default:
+		if lastNote, err = findLastEntryInTargetDB(targetDB); err != nil {
+			return fmt.Errorf("error finding last entry in target database: %w", err)
+		}
+	}
+	whereClause, params := resumeSelection(checkpoint, state, lastNote)
+
+	totalCount, err := getTotalRecordCount(sourceDB, whereClause, params...)
+	if err != nil {
+		return err
+	}
+	m.setTotal(totalCount)
+	m.report(StageMigrating)
+
+	if err := m.processRecordsInBatches(sourceDB, targetDB, totalCount, whereClause, params); err != nil {
+		return err
+	}
+
+	if m.sourceKey != "" {
+		if err := clearCheckpoint(targetDB, checkpointPrefix+m.sourceKey); err != nil {
+			return err
+		}
+	}
+
+	// Record the snapshot high-water mark for the next incremental run
+	if m.opts.Snapshot {
+		lastDate, lastTime, err := lastDetectionUpTo(sourceDB, highWater)
+		if err != nil {
+			m.logger().Printf("Error reading last migrated detection: %v", err)
+		}
+		state := &MigrationState{Source: stateKey, LastRowID: highWater, LastDate: lastDate, LastTime: lastTime}
+		if err := saveMigrationState(targetDB, state); err != nil {
+			return fmt.Errorf("error saving migration state: %w", err)
+		}
+		m.setLast(highWater, lastDate, lastTime)
+	}
+
+	return nil
+}
+
+// openTargetDB opens the target database of the run. A sync runs next to BirdNET-Go, so its
+// target is opened as a live database; the others tune it for bulk inserts.
+func (m *migration) openTargetDB() (*gorm.DB, error) {
+	if m.opts.Operation == OperationSync {
+		return openLiveTargetDB(m.opts.TargetDBPath, createGormLogger(m.logger()))
+	}
+	return initializeAndMigrateTargetDB(m.opts.TargetDBPath, createGormLogger(m.logger()))
+}
+
+// initializeAndMigrateTargetDB prepares the target database for data insertion.
+func initializeAndMigrateTargetDB(targetDBPath string, newLogger logger.Interface) (*gorm.DB, error) {
+	targetDB, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		return nil, fmt.Errorf("target db open: %w", err)
+	}
+
+	// Enable foreign key constraint enforcement for SQLite
+	if err := targetDB.Exec("PRAGMA foreign_keys = ON").Error; err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to enable foreign key support in SQLite: %w", err)
+	}
+
+	// Set SQLite to use MEMORY journal mode, reduces sdcard wear and improves performance
+	if err := targetDB.Exec("PRAGMA journal_mode = MEMORY").Error; err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to enable MEMORY journal mode in SQLite: %w", err)
+	}
+
+	// Set SQLite to use NORMAL synchronous mode
+	if err := targetDB.Exec("PRAGMA synchronous = OFF").Error; err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to set synchronous mode in SQLite: %w", err)
+	}
+
+	// Set SQLIte to use MEMORY temp store mode
+	if err := targetDB.Exec("PRAGMA temp_store = MEMORY").Error; err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to set temp store mode in SQLite: %w", err)
+	}
+
+	// Increase cache size
+	if err := targetDB.Exec("PRAGMA cache_size = -128000").Error; err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to set cache size in SQLite: %w", err)
+	}
+
+	// Perform auto-migration to create the tables if they do not exist.
+	if err := targetDB.AutoMigrate(&Note{}, &NoteMetadata{}); err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("automigrate: %w", err)
+	}
+
+	return targetDB, nil
+}
+
+// createGormLogger configures and returns a new GORM logger instance writing to runLogger.
+func createGormLogger(runLogger *log.Logger) logger.Interface {
+	return logger.New(
+		runLogger,
+		logger.Config{
+			SlowThreshold: 1 * time.Second,
+			LogLevel:      logger.Error,
+			Colorful:      false,
+			// Lookups for resume points miss routinely, that is not worth an error line
+			IgnoreRecordNotFoundError: true,
+		},
+	)
+}
+
+// getTotalRecordCount returns the total number of records in the source database
+// that match the given whereClause and parameters.
+func getTotalRecordCount(sourceDB *gorm.DB, whereClause string, params ...interface{}) (int, error) {
+	var totalCount int64
+	query := sourceDB.Model(&Detection{})
+
+	if whereClause != "" {
+		query = query.Where(whereClause, params...)
+	}
+
+	if err := query.Count(&totalCount).Error; err != nil {
+		return 0, fmt.Errorf("error counting source records: %w", err)
+	}
+
+	return int(totalCount), nil
+}
+
+// processRecordsInBatches processes records from the source database in batches,
+// converting each record to a Note and optionally transferring files. Each batch is inserted
+// in one transaction together with a checkpoint, committed once its clip transfers are done,
+// and the run stops before the next batch when it is cancelled.
+func (m *migration) processRecordsInBatches(sourceDB, targetDB *gorm.DB, totalCount int, whereClause string, params []any) error {
+	const batchSize = 1000 // Define the size of each batch
+
+	// Sources that can only be read sequentially, such as compressed archives, get their clips
+	// transferred after all notes are inserted, in the order they are stored in the source.
+	// The deferred clips are queued in the target with the checkpoint of their batch, so a
+	// resumed run transfers those an interrupted one did not get to.
+	skipAudioTransfer := m.opts.SkipAudioTransfer
+	seqFS, sequential := m.fileSystem().(sequentialFS)
+	sequential = sequential && seqFS.Sequential() && !skipAudioTransfer
+	var deferredTransfers []pendingClip
+	pendingKey := ""
+	if sequential && m.sourceKey != "" {
+		pendingKey = checkpointPrefix + m.sourceKey
+		if err := targetDB.AutoMigrate(&pendingClip{}); err != nil {
+			return fmt.Errorf("failed to migrate target database: %w", err)
+		}
+		if err := targetDB.Where("source = ?", pendingKey).Order("id").Find(&deferredTransfers).Error; err != nil {
+			return fmt.Errorf("failed to load pending clips: %w", err)
+		}
+		if len(deferredTransfers) > 0 {
+			m.logger().Printf("Resuming %d clip transfers of an interrupted run", len(deferredTransfers))
+		}
+	}
+
+	for offset := 0; offset < totalCount; offset += batchSize {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+
+		batchDetections, err := fetchBatch(sourceDB, offset, batchSize, whereClause, params)
+		if err != nil {
+			return err
+		}
+		if len(batchDetections) == 0 {
+			break
+		}
+
+		// Keep the last record as read, processing normalizes dates in place
+		last := batchDetections[len(batchDetections)-1]
+
+		var clips []pendingClip
+		err = targetDB.Transaction(func(tx *gorm.DB) error {
+			clips = nil
+			var transfers sync.WaitGroup
+			for i := range batchDetections {
+				m.processDetection(tx, &batchDetections[i].Detection, skipAudioTransfer || sequential, &transfers)
+				if sequential {
+					clips = append(clips, newPendingClip(pendingKey, m.id(), &batchDetections[i].Detection))
+				}
+			}
+			transfers.Wait()
+			m.syncManifest()
+
+			if pendingKey != "" && len(clips) > 0 {
+				if err := tx.Create(&clips).Error; err != nil {
+					return fmt.Errorf("failed to queue clips: %w", err)
+				}
+			}
+			if m.sourceKey == "" {
+				return nil
+			}
+			return saveCheckpoint(tx, checkpointPrefix+m.sourceKey, &last)
+		})
+		if err != nil {
+			return err
+		}
+		deferredTransfers = append(deferredTransfers, clips...)
+		m.setLast(last.RowID, last.RawDate, last.Time)
+		m.report(StageMigrating)
+	}
+
+	if len(deferredTransfers) > 0 {
+		m.report(StageTransferring)
+		sortByReadOrder(deferredTransfers, m.opts.SourceFilesDir, seqFS)
+		for i := range deferredTransfers {
+			if err := m.ctx.Err(); err != nil {
+				if pendingKey != "" {
+					m.logger().Printf("Stopped with %d clips not transferred from %s, resuming transfers them",
+						len(deferredTransfers)-i, m.opts.SourceFilesDir)
+				} else {
+					m.logger().Printf("Stopped with %d clips not transferred from %s, resuming does not transfer them again",
+						len(deferredTransfers)-i, m.opts.SourceFilesDir)
+				}
+				return err
+			}
+			p := &deferredTransfers[i]
+			detection := p.detection()
+			transfer, err := transferClipWithFS(&detection, m.opts.SourceFilesDir, m.opts.TargetFilesDir, m.fileOperation(), seqFS)
+			m.recordClip(&detection, transfer, err)
+			m.recordManifest(nil, transfer)
+			if pendingKey != "" {
+				if err := targetDB.Delete(p).Error; err != nil {
+					m.logger().Printf("Failed to remove pending clip %d: %v", p.ID, err)
+				}
+			}
+			m.report(StageTransferring)
+		}
+	}
+
+	return nil
+}
+
+// fetchBatch retrieves a specific batch of Detection records and their rowids from the
+// source database, based on the provided offset and batchSize.
+func fetchBatch(sourceDB *gorm.DB, offset, batchSize int, whereClause string, params []any) ([]rowDetection, error) {
+	var detections []rowDetection
+
+	query := sourceDB.Model(&Detection{}).Select("rowid, CAST(date AS TEXT) AS raw_date, *").Order("date ASC, time ASC, rowid ASC").Offset(offset).Limit(batchSize)
+
+	if whereClause != "" {
+		query = query.Where(whereClause, params...)
+	}
+
+	if err := query.Find(&detections).Error; err != nil {
+		return nil, fmt.Errorf("error fetching batch: %w", err)
+	}
+
+	return detections, nil
+}
+
+// processDetection takes a single Detection record, converts it to a Note,
+// inserts it into the target database, and optionally handles file transfer
+// if audio transfer is not skipped.
+func (m *migration) processDetection(targetDB *gorm.DB, detection *Detection, skipAudioTransfer bool, transfers *sync.WaitGroup) {
+	note := m.convertDetection(detection)
+	m.applyOverrides(&note, "")
+	err := targetDB.Create(&note).Error
+	inserted := &note
+	if err != nil {
+		m.logger().Printf("Error inserting note: %v", err)
+		inserted = nil
+	} else if err := saveMetadata(targetDB, &note, detectionMetadata(detection, "")); err != nil {
+		m.logger().Printf("Error saving metadata of note %d: %v", note.ID, err)
+	}
+	m.recordNote(note.ScientificName, note.CommonName, err)
+
+	if skipAudioTransfer {
+		m.recordManifest(inserted, clipTransfer{})
+		return
+	}
+
+	transfers.Add(1)
+	go func() {
+		defer transfers.Done()
+		transfer, err := transferClipWithFS(detection, m.opts.SourceFilesDir, m.opts.TargetFilesDir, m.fileOperation(), m.fileSystem())
+		m.recordClip(detection, transfer, err)
+		m.recordManifest(inserted, transfer)
+		m.report(StageMigrating)
+	}()
+}
+
+// convertDetectionToNote converts a Detection record into a Note record,
+// preparing it for insertion into the target database.
+func convertDetectionToNote(detection *Detection) Note {
+	// Try parsing the date in both RFC3339 and simple date format
+	parsedDate, err := time.Parse(time.RFC3339, detection.Date)
+	if err != nil {
+		// If RFC3339 fails, try simple date format
+		parsedDate, err = time.Parse("2006-01-02", detection.Date)
+	}
+
+	// Only update the date format if parsing was successful, keeping the original value otherwise
+	if err == nil {
+		detection.Date = parsedDate.Format("2006-01-02")
+	}
+
+	// Construct the path for the clip
+	year := parsedDate.Format("2006")
+	month := parsedDate.Format("01")
+	clipName := filepath.Join(year, month, GenerateClipName(detection))
+
+	return Note{
+		Date:           detection.Date,
+		Time:           detection.Time,
+		ScientificName: detection.SciName,
+		CommonName:     detection.ComName,
+		Confidence:     detection.Confidence,
+		Latitude:       detection.Lat,
+		Longitude:      detection.Lon,
+		Threshold:      detection.Cutoff,
+		Sensitivity:    detection.Sens,
+		ClipName:       clipName,
+		Verified:       "unverified",
+	}
+}
+
+// findLastEntryInTargetDB queries the target database for the most recent Note entry.
+func findLastEntryInTargetDB(targetDB *gorm.DB) (*Note, error) {
+	var lastNote Note
+	result := targetDB.Order("date DESC, time DESC").First(&lastNote)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			// The database is empty. This is not an error condition for this function.
+			return nil, nil // Return nils to indicate no records found gracefully.
+		}
+		// For other types of errors, return the error as is.
+		return nil, result.Error
+	}
+
+	return &lastNote, nil
+}
+
+// formulateQuery constructs a SQL WHERE clause and its corresponding parameters
+// based on the most recent Note entry in the target database.
+func formulateQuery(lastNote *Note) (whereClause string, params []any) {
+	if lastNote != nil {
+		whereClause = "date > ? OR (date = ? AND time > ?)"
+		params = []any{lastNote.Date, lastNote.Date, lastNote.Time}
+		return whereClause, params
+	}
+
+	return "", nil
+}
+
+// initializeAndMigrateSourceDB prepares the source database for read-only operations.
+func initializeAndMigrateSourceDB(sourceDBPath string, newLogger logger.Interface) (*gorm.DB, error) {
+	// Open the source database in read-only mode to prevent modifications
+	sourceDB, err := gorm.Open(sqlite.Open(sourceDBPath+"?mode=ro"), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		return nil, fmt.Errorf("source db open: %w", err)
+	}
+
+	// Configure SQLite for optimal read performance
+	if err := sourceDB.Exec("PRAGMA journal_mode = OFF").Error; err != nil {
+		newLogger.Error(context.Background(), "failed to set journal mode in source SQLite: %v", err)
+	}
+
+	if err := sourceDB.Exec("PRAGMA synchronous = OFF").Error; err != nil {
+		newLogger.Error(context.Background(), "failed to set synchronous mode in source SQLite: %v", err)
+	}
+
+	if err := sourceDB.Exec("PRAGMA cache_size = -32000").Error; err != nil {
+		newLogger.Error(context.Background(), "failed to set cache size in source SQLite: %v", err)
+	}
+
+	return sourceDB, nil
+}
+
+// MergeDatabases merges data from sourceDB into targetDB.
+// It can handle both source databases with Notes tables and Detections tables.
+func MergeDatabases(sourceDBPath, targetDBPath string) error {
+	m := &migration{ctx: context.Background()}
+	return m.mergeDatabases(sourceDBPath, targetDBPath)
+}
+
+// mergeDatabases implements MergeDatabases, counting merged records and stopping when the run is cancelled.
+func (m *migration) mergeDatabases(sourceDBPath, targetDBPath string) error {
+	// Check if source and target are the same path
+	if sourceDBPath == targetDBPath {
+		return fmt.Errorf("source and target database paths cannot be the same")
+	}
+
+	// Check if source database file exists
+	if _, err := os.Stat(sourceDBPath); os.IsNotExist(err) {
+		return fmt.Errorf("source database file does not exist: %s", sourceDBPath)
+	}
+
+	// Connect to the source database in read-only mode
+	sourceDB, err := initializeAndMigrateSourceDB(sourceDBPath, createGormLogger(m.logger()))
+	if err != nil {
+		return err
+	}
+	defer closeDB(sourceDB)
+
+	// Connect to the target database
+	targetDB, err := initializeAndMigrateTargetDB(targetDBPath, createGormLogger(m.logger()))
+	if err != nil {
+		return err
+	}
+	defer closeDB(targetDB)
+	if !m.targetCounted {
+		m.recordTargetRows(targetDB, false)
+		m.targetCounted = true
+	}
+	defer m.recordTargetRows(targetDB, true)
+
+	// A previous merge of the same source that was interrupted is continued after its checkpoint
+	checkpointKey := mergeCheckpointPrefix + migrationStateKey(sourceDBPath)
+
+	// Check if the source database has a Notes table
+	hasNotesTable := true
+	var notesCount int64
+	if err := sourceDB.Model(&Note{}).Count(&notesCount).Error; err != nil {
+		hasNotesTable = false
+	} else if notesCount == 0 {
+		// If Notes table exists but is empty, check if Detections table exists and has data
+		var detectionsTableExists int64
+		err := sourceDB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='detections'").Count(&detectionsTableExists).Error
+		if err == nil && detectionsTableExists > 0 {
+			var detectionsCount int64
+			if err := sourceDB.Raw("SELECT COUNT(*) FROM detections").Count(&detectionsCount).Error; err == nil && detectionsCount > 0 {
+				// Detections table exists and has data, prefer using it
+				hasNotesTable = false
+				return m.mergeDetections(sourceDB, targetDB, checkpointKey)
+			}
+		}
+	}
+
+	// If source has Notes table with data, process it as Notes
+	if hasNotesTable && notesCount > 0 {
+		return m.mergeNotes(sourceDB, targetDB, checkpointKey)
+	} else if hasNotesTable && notesCount == 0 {
+		// Notes table exists but is empty, return success without doing anything
+		m.logger().Println("Source database has an empty Notes table, nothing to merge.")
+		return nil
+	}
+
+	// Check if it has a Detections table
+	var detectionsTableExists int64
+	err = sourceDB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='detections'").Count(&detectionsTableExists).Error
+	if err != nil || detectionsTableExists == 0 {
+		return fmt.Errorf("source database doesn't have a valid Notes or Detections table")
+	}
+
+	var detectionsCount int64
+	if err := sourceDB.Raw("SELECT COUNT(*) FROM detections").Count(&detectionsCount).Error; err != nil {
+		return fmt.Errorf("error counting detections in source database: %w", err)
+	}
+
+	if detectionsCount == 0 {
+		m.logger().Println("Source database has an empty Detections table, nothing to merge.")
+		return nil
+	}
+
+	// Process Detections table
+	return m.mergeDetections(sourceDB, targetDB, checkpointKey)
+}
+
+// mergeNotes merges notes from sourceDB into targetDB in id order. Each batch is inserted in one
+// transaction together with a checkpoint under checkpointKey, which is cleared once all are merged.
+func (m *migration) mergeNotes(sourceDB, targetDB *gorm.DB, checkpointKey string) error {
+	// Define the batch size
+	const batchSize = 1000
+
+	cursor, err := m.loadMergeCheckpoint(sourceDB, targetDB, checkpointKey, "notes", "id")
+	if err != nil {
+		return err
+	}
+
+	for !m.cancelled() {
+		// Retrieve a batch of notes from the source database
+		var notes []Note
+		if err := sourceDB.Where("id > ?", cursor).Order("id").Limit(batchSize).Find(&notes).Error; err != nil {
+			return fmt.Errorf("failed to retrieve batch of notes: %w", err)
+		}
+		if len(notes) == 0 {
+			break
+		}
+
+		metadata, err := readMetadata(sourceDB, notes)
+		if err != nil {
+			return err
+		}
+
+		last := &rowDetection{RowID: int64(notes[len(notes)-1].ID)}
+		last.Date, last.Time = notes[len(notes)-1].Date, notes[len(notes)-1].Time
+
+		err = targetDB.Transaction(func(tx *gorm.DB) error {
+			// Insert each note in the batch into the target database without the ID field
+			for i := range notes {
+				newNote := Note{
+					Date:           notes[i].Date,
+					Time:           notes[i].Time,
+					ScientificName: notes[i].ScientificName,
+					CommonName:     notes[i].CommonName,
+					Confidence:     notes[i].Confidence,
+					Latitude:       notes[i].Latitude,
+					Longitude:      notes[i].Longitude,
+					Threshold:      notes[i].Threshold,
+					Sensitivity:    notes[i].Sensitivity,
+					ClipName:       notes[i].ClipName,
+					Verified:       notes[i].Verified,
+				}
+				noteMetadata := metadata[notes[i].ID]
+				if m.source.Station != "" {
+					noteMetadata.Station = m.source.Station
+				}
+				m.remapNote(&newNote)
+				m.mergeNote(tx, &newNote, noteMetadata, []string{filepath.Join(m.opts.SourceFilesDir, notes[i].ClipName)})
+			}
+
+			m.syncManifest()
+			return saveCheckpoint(tx, checkpointKey, last)
+		})
+		if err != nil {
+			return err
+		}
+		cursor = last.RowID
+		m.setLast(last.RowID, last.Date, last.Time)
+		m.report(StageMerging)
+	}
+
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if err := clearCheckpoint(targetDB, checkpointKey); err != nil {
+		return err
+	}
+
+	m.logger().Println("Database merge completed successfully with batching.")
+	return nil
+}
+
+// mergeDetections merges detections from sourceDB into targetDB in rowid order, converting them to
+// Notes. Batches are checkpointed under checkpointKey like in mergeNotes.
+func (m *migration) mergeDetections(sourceDB, targetDB *gorm.DB, checkpointKey string) error {
+	// Define the batch size
+	const batchSize = 1000
+
+	cursor, err := m.loadMergeCheckpoint(sourceDB, targetDB, checkpointKey, "detections", "rowid")
+	if err != nil {
+		return err
+	}
+
+	for !m.cancelled() {
+		// Retrieve a batch of detections from the source database
+		var detections []rowDetection
+		if err := sourceDB.Raw("SELECT rowid, * FROM detections WHERE rowid > ? ORDER BY rowid LIMIT ?", cursor, batchSize).Scan(&detections).Error; err != nil {
+			return fmt.Errorf("failed to retrieve batch of detections: %w", err)
+		}
+		if len(detections) == 0 {
+			break
+		}
+
+		// Keep the last record as read, conversion normalizes dates in place
+		last := detections[len(detections)-1]
+
+		err := targetDB.Transaction(func(tx *gorm.DB) error {
+			// Convert and insert each detection into the target database
+			for j := range detections {
+				note := m.convertDetection(&detections[j].Detection)
+				var candidates []string
+				if detections[j].FileName != "" {
+					candidates = sourceClipPaths(&detections[j].Detection, m.opts.SourceFilesDir)
+				}
+				m.mergeNote(tx, &note, detectionMetadata(&detections[j].Detection, m.source.Station), candidates)
+			}
+
+			m.syncManifest()
+			return saveCheckpoint(tx, checkpointKey, &last)
+		})
+		if err != nil {
+			return err
+		}
+		cursor = last.RowID
+		m.setLast(last.RowID, last.Date, last.Time)
+		m.report(StageMerging)
+	}
+
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if err := clearCheckpoint(targetDB, checkpointKey); err != nil {
+		return err
+	}
+
+	m.logger().Println("Database merge (detections to notes) completed successfully with batching.")
+	return nil
+}
+
+// loadMergeCheckpoint returns the source key after which an interrupted merge continues, or zero,
+// and adds the number of source rows and of those left to merge to the totals of the run.
+func (m *migration) loadMergeCheckpoint(sourceDB, targetDB *gorm.DB, checkpointKey, table, keyColumn string) (int64, error) {
+	checkpoint, err := loadMigrationState(targetDB, checkpointKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load merge checkpoint: %w", err)
+	}
+
+	var cursor int64
+	if checkpoint != nil {
+		m.logger().Printf("Resuming interrupted merge after %s %s %d", table, keyColumn, checkpoint.LastRowID)
+		cursor = checkpoint.LastRowID
+		m.setLast(checkpoint.LastRowID, checkpoint.LastDate, checkpoint.LastTime)
+	}
+
+	var rows, remaining int64
+	query := fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(%s > ?), 0) FROM %s", keyColumn, table)
+	if err := sourceDB.Raw(query, cursor).Row().Scan(&rows, &remaining); err != nil {
+		return 0, fmt.Errorf("failed to count %s to merge: %w", table, err)
+	}
+	m.addMergeRows(rows, int(remaining))
+
+	return cursor, nil
+}
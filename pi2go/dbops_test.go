@@ -1,4 +1,4 @@
-package main
+package pi2go
 
 import (
 	"fmt"
@@ -835,7 +835,7 @@ func TestMergeWithRealBirdsDB(t *testing.T) {
 	}
 
 	// Get a count of records in birds.db for verification later
-	birdsDB := initializeAndMigrateSourceDB("birds.db", logger.New(
+	birdsDB, err := initializeAndMigrateSourceDB("birds.db", logger.New(
 		nil,
 		logger.Config{
 			SlowThreshold: 1 * time.Second,
@@ -843,6 +843,9 @@ func TestMergeWithRealBirdsDB(t *testing.T) {
 			Colorful:      false,
 		},
 	))
+	if err != nil {
+		t.Fatalf("Failed to open birds.db: %v", err)
+	}
 
 	var detectionsCount int64
 	if err := birdsDB.Raw("SELECT COUNT(*) FROM detections").Count(&detectionsCount).Error; err != nil {
@@ -945,7 +948,7 @@ func TestBatchSizePerformance(t *testing.T) {
 	batchSizes := []int{100, 500, 1000, 5000, 10000}
 
 	// Get a count of records in birds.db for verification later
-	birdsDB := initializeAndMigrateSourceDB("birds.db", logger.New(
+	birdsDB, err := initializeAndMigrateSourceDB("birds.db", logger.New(
 		nil,
 		logger.Config{
 			SlowThreshold: 1 * time.Second,
@@ -953,6 +956,9 @@ func TestBatchSizePerformance(t *testing.T) {
 			Colorful:      false,
 		},
 	))
+	if err != nil {
+		t.Fatalf("Failed to open birds.db: %v", err)
+	}
 
 	var detectionsCount int64
 	if err := birdsDB.Raw("SELECT COUNT(*) FROM detections").Count(&detectionsCount).Error; err != nil {
@@ -1007,10 +1013,16 @@ func TestBatchSizePerformance(t *testing.T) {
 		// Create a custom merge function with the specific batch size
 		mergeFn := func() error {
 			// Connect to the source database in read-only mode
-			sourceDB := initializeAndMigrateSourceDB("birds.db", createGormLogger())
+			sourceDB, err := initializeAndMigrateSourceDB("birds.db", createGormLogger(log.Default()))
+			if err != nil {
+				return err
+			}
 
 			// Connect to the target database
-			targetDB := initializeAndMigrateTargetDB(targetDBPath, createGormLogger())
+			targetDB, err := initializeAndMigrateTargetDB(targetDBPath, createGormLogger(log.Default()))
+			if err != nil {
+				return err
+			}
 
 			// Check if it has a Detections table
 			var detectionsCount int64
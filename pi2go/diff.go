@@ -0,0 +1,327 @@
+// file diff.go
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// maxDiffRows limits how many rows of each kind are kept in a Diff; its counts include them all.
+const maxDiffRows = 1000
+
+// Diff is how the records of a source database differ from the notes of a target database.
+// Records are matched on date, time and scientific name after conversion to notes.
+type Diff struct {
+	SourceTable string `json:"source_table"` // "detections" or "notes"
+	SourceRows  int    `json:"source_rows"`
+	TargetRows  int    `json:"target_rows"`
+	Identical   int    `json:"identical"` // Records matched by a note with the same values
+
+	OnlyInSource     int          `json:"only_in_source"`
+	OnlyInTarget     int          `json:"only_in_target"`
+	Changed          int          `json:"changed"` // Records matched by a note with other values
+	OnlyInSourceRows []DiffRecord `json:"only_in_source_rows,omitempty"`
+	OnlyInTargetRows []DiffRecord `json:"only_in_target_rows,omitempty"`
+	ChangedRows      []DiffChange `json:"changed_rows,omitempty"`
+}
+
+// Equal reports whether every source record matches a note with the same values and the target
+// holds no other notes.
+func (d *Diff) Equal() bool {
+	return d.OnlyInSource == 0 && d.OnlyInTarget == 0 && d.Changed == 0
+}
+
+// DiffRecord is a source record or target note, as a note.
+type DiffRecord struct {
+	ID             int64   `json:"id"` // Rowid of a detection or ID of a note
+	Date           string  `json:"date"`
+	Time           string  `json:"time"`
+	ScientificName string  `json:"scientific_name"`
+	CommonName     string  `json:"common_name"`
+	Confidence     float64 `json:"confidence"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Verified       string  `json:"verified"`
+}
+
+// DiffChange is a source record and the note matching it, with the fields whose values differ.
+type DiffChange struct {
+	Source DiffRecord `json:"source"`
+	Target DiffRecord `json:"target"`
+	Fields []string   `json:"fields"` // "confidence", "latitude", "longitude" or "verified"
+}
+
+// DiffDatabases compares the source of opts, the detections of a BirdNET-Pi database or the
+// notes of a BirdNET-Go one, with the notes of opts.TargetDBPath without writing to either.
+// Detections are converted as a migration converts them. Records sharing a date, time and
+// species are paired with identical ones first and then in order of confidence, and the unpaired
+// ones reported as only in one of the databases. Remote, archive and text log sources are prepared as for a Run.
+func DiffDatabases(ctx context.Context, opts Options) (*Diff, error) {
+	if opts.Source == "" && opts.SourceDBPath == "" && opts.SourceTextPath == "" {
+		return nil, errors.New("a source database, text log or remote source is required")
+	}
+	if opts.TargetDBPath == "" {
+		return nil, errors.New("a target database is required")
+	}
+
+	m := &migration{ctx: ctx, opts: opts}
+	cleanup, err := m.prepareSource()
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	quiet := logger.Default.LogMode(logger.Silent)
+	sourceDB, err := openLiveSourceDB(m.opts.SourceDBPath, quiet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer closeDB(sourceDB)
+
+	// Like a merge, a source with notes is a BirdNET-Go database
+	diff := &Diff{SourceTable: "detections"}
+	var sourceNotes int64
+	if sourceDB.Migrator().HasTable(&Note{}) {
+		if err := sourceDB.Model(&Note{}).Count(&sourceNotes).Error; err != nil {
+			return nil, fmt.Errorf("failed to count source notes: %w", err)
+		}
+	}
+	switch {
+	case sourceNotes > 0, !sourceDB.Migrator().HasTable(&Detection{}) && sourceDB.Migrator().HasTable(&Note{}):
+		diff.SourceTable = "notes"
+	case !sourceDB.Migrator().HasTable(&Detection{}):
+		return nil, errors.New("source database doesn't have a valid Notes or Detections table")
+	}
+
+	targetDB, err := openLiveSourceDB(m.opts.TargetDBPath, quiet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target database: %w", err)
+	}
+	defer closeDB(targetDB)
+	if !targetDB.Migrator().HasTable(&Note{}) {
+		return nil, errors.New("target database has no notes table")
+	}
+
+	sourceDates, err := diffDates(sourceDB, diff.SourceTable)
+	if err != nil {
+		return nil, err
+	}
+	targetDates, err := diffDates(targetDB, "notes")
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare a day at a time, holding only the records of that day in memory
+	dates := make([]string, 0, len(sourceDates)+len(targetDates))
+	for date := range sourceDates {
+		dates = append(dates, date)
+	}
+	for date := range targetDates {
+		if _, ok := sourceDates[date]; !ok {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+
+	for _, date := range dates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		source, err := diffRecords(sourceDB, diff.SourceTable, sourceDates[date])
+		if err != nil {
+			return nil, err
+		}
+		target, err := diffRecords(targetDB, "notes", targetDates[date])
+		if err != nil {
+			return nil, err
+		}
+		diff.SourceRows += len(source)
+		diff.TargetRows += len(target)
+		diff.compare(source, target)
+	}
+
+	return diff, nil
+}
+
+// diffDates returns the dates of the records in table, normalized as convertDetectionToNote
+// does, each with the dates as stored that normalize to it.
+func diffDates(db *gorm.DB, table string) (map[string][]string, error) {
+	var stored []string
+	if err := db.Raw(fmt.Sprintf("SELECT DISTINCT CAST(date AS TEXT) FROM %s", table)).Scan(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to read dates of %s: %w", table, err)
+	}
+
+	dates := make(map[string][]string)
+	for _, date := range stored {
+		normalized := normalizeDate(date)
+		dates[normalized] = append(dates[normalized], date)
+	}
+	return dates, nil
+}
+
+// diffRecords reads the records of table stored under the given dates, converting detections.
+func diffRecords(db *gorm.DB, table string, dates []string) ([]DiffRecord, error) {
+	if len(dates) == 0 {
+		return nil, nil
+	}
+
+	if table == "notes" {
+		var notes []Note
+		if err := db.Where("date IN ?", dates).Find(&notes).Error; err != nil {
+			return nil, fmt.Errorf("failed to read notes: %w", err)
+		}
+		records := make([]DiffRecord, len(notes))
+		for i := range notes {
+			records[i] = newDiffRecord(int64(notes[i].ID), &notes[i])
+		}
+		return records, nil
+	}
+
+	var detections []rowDetection
+	err := db.Raw("SELECT rowid, CAST(date AS TEXT) AS raw_date, * FROM detections WHERE date IN ?", dates).Scan(&detections).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read detections: %w", err)
+	}
+	records := make([]DiffRecord, len(detections))
+	for i := range detections {
+		if detections[i].RawDate != "" {
+			detections[i].Date = detections[i].RawDate
+		}
+		note := convertDetectionToNote(&detections[i].Detection)
+		records[i] = newDiffRecord(detections[i].RowID, &note)
+	}
+	return records, nil
+}
+
+// newDiffRecord returns note as a DiffRecord with the given ID.
+func newDiffRecord(id int64, note *Note) DiffRecord {
+	return DiffRecord{
+		ID:             id,
+		Date:           note.Date,
+		Time:           note.Time,
+		ScientificName: note.ScientificName,
+		CommonName:     note.CommonName,
+		Confidence:     note.Confidence,
+		Latitude:       note.Latitude,
+		Longitude:      note.Longitude,
+		Verified:       note.Verified,
+	}
+}
+
+// compare adds the differences between the source and target records of one day to d.
+func (d *Diff) compare(source, target []DiffRecord) {
+	type key struct{ date, time, scientificName string }
+	groups := make(map[key][2][]DiffRecord)
+	var keys []key
+	for side, records := range [2][]DiffRecord{source, target} {
+		for _, record := range records {
+			k := key{record.Date, record.Time, record.ScientificName}
+			group, ok := groups[k]
+			if !ok {
+				keys = append(keys, k)
+			}
+			group[side] = append(group[side], record)
+			groups[k] = group
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].date != keys[j].date {
+			return keys[i].date < keys[j].date
+		}
+		if keys[i].time != keys[j].time {
+			return keys[i].time < keys[j].time
+		}
+		return keys[i].scientificName < keys[j].scientificName
+	})
+
+	for _, k := range keys {
+		group := groups[k]
+		for side := range group {
+			records := group[side]
+			sort.SliceStable(records, func(i, j int) bool {
+				if records[i].Confidence != records[j].Confidence {
+					return records[i].Confidence < records[j].Confidence
+				}
+				return records[i].ID < records[j].ID
+			})
+		}
+
+		// Identical records pair up first, so a record added or removed among several of the
+		// same key is not reported as a chain of changes
+		sourceRecords, targetRecords := group[0], group[1]
+		sourceRecords, targetRecords = d.pairIdentical(sourceRecords, targetRecords)
+		for i := 0; i < len(sourceRecords) || i < len(targetRecords); i++ {
+			switch {
+			case i >= len(targetRecords):
+				d.OnlyInSource++
+				if len(d.OnlyInSourceRows) < maxDiffRows {
+					d.OnlyInSourceRows = append(d.OnlyInSourceRows, sourceRecords[i])
+				}
+			case i >= len(sourceRecords):
+				d.OnlyInTarget++
+				if len(d.OnlyInTargetRows) < maxDiffRows {
+					d.OnlyInTargetRows = append(d.OnlyInTargetRows, targetRecords[i])
+				}
+			default:
+				fields := changedFields(&sourceRecords[i], &targetRecords[i])
+				if len(fields) == 0 {
+					d.Identical++
+					continue
+				}
+				d.Changed++
+				if len(d.ChangedRows) < maxDiffRows {
+					d.ChangedRows = append(d.ChangedRows, DiffChange{Source: sourceRecords[i], Target: targetRecords[i], Fields: fields})
+				}
+			}
+		}
+	}
+}
+
+// pairIdentical counts the source records with an identical target record, pairing each target
+// record once, and returns the records of both that are left.
+func (d *Diff) pairIdentical(source, target []DiffRecord) (unpairedSource, unpairedTarget []DiffRecord) {
+	paired := make([]bool, len(target))
+	for i := range source {
+		found := false
+		for j := range target {
+			if !paired[j] && len(changedFields(&source[i], &target[j])) == 0 {
+				paired[j], found = true, true
+				d.Identical++
+				break
+			}
+		}
+		if !found {
+			unpairedSource = append(unpairedSource, source[i])
+		}
+	}
+	for j := range target {
+		if !paired[j] {
+			unpairedTarget = append(unpairedTarget, target[j])
+		}
+	}
+	return unpairedSource, unpairedTarget
+}
+
+// changedFields lists the compared fields whose values differ between a and b.
+func changedFields(a, b *DiffRecord) []string {
+	var fields []string
+	if a.Confidence != b.Confidence {
+		fields = append(fields, "confidence")
+	}
+	if a.Latitude != b.Latitude {
+		fields = append(fields, "latitude")
+	}
+	if a.Longitude != b.Longitude {
+		fields = append(fields, "longitude")
+	}
+	if a.Verified != b.Verified {
+		fields = append(fields, "verified")
+	}
+	return fields
+}
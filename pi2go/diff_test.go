@@ -0,0 +1,78 @@
+package pi2go
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDiffDatabases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, Lat: 60.1, Lon: 24.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.7, Lat: 60.1, Lon: 24.9, FileName: "b.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, Lat: 60.1, Lon: 24.9, FileName: "c.mp3"},
+		{Date: "2023-01-16", Time: "12:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.85, Lat: 60.1, Lon: 24.9, FileName: "d.mp3"},
+	}
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, detections)
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true})
+
+	diff, err := DiffDatabases(context.Background(), Options{SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath})
+	if err != nil {
+		t.Fatalf("DiffDatabases() error = %v", err)
+	}
+	if !diff.Equal() || diff.SourceTable != "detections" || diff.Identical != 4 || diff.SourceRows != 4 || diff.TargetRows != 4 {
+		t.Errorf("DiffDatabases() after migrating = %+v, want 4 identical records", diff)
+	}
+
+	// A BirdNET-Go database compared with itself is identical too
+	diff, err = DiffDatabases(context.Background(), Options{SourceDBPath: targetDBPath, TargetDBPath: targetDBPath})
+	if err != nil {
+		t.Fatalf("DiffDatabases() of notes error = %v", err)
+	}
+	if !diff.Equal() || diff.SourceTable != "notes" || diff.Identical != 4 {
+		t.Errorf("DiffDatabases() of notes = %+v, want 4 identical notes", diff)
+	}
+
+	targetDB, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(targetDB)
+	targetDB.Model(&Note{}).Where("scientific_name = ?", "Parus major").Updates(map[string]any{"verified": "correct", "latitude": 61.5})
+	targetDB.Where("scientific_name = ?", "Pica pica").Delete(&Note{})
+	targetDB.Create(&Note{Date: "2023-01-17", Time: "09:00:00", ScientificName: "Sitta europaea", CommonName: "Eurasian Nuthatch", Verified: "unverified"})
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.5, FileName: "e.mp3"})
+
+	diff, err = DiffDatabases(context.Background(), Options{SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath})
+	if err != nil {
+		t.Fatalf("DiffDatabases() error = %v", err)
+	}
+	if diff.Equal() || diff.Identical != 2 || diff.Changed != 1 || diff.OnlyInSource != 2 || diff.OnlyInTarget != 1 {
+		t.Fatalf("DiffDatabases() = %+v, want 2 identical, 1 changed, 2 only in source, 1 only in target", diff)
+	}
+	if change := diff.ChangedRows[0]; change.Source.ScientificName != "Parus major" || !reflect.DeepEqual(change.Fields, []string{"latitude", "verified"}) {
+		t.Errorf("DiffDatabases() changed = %+v, want latitude and verified of Parus major", change)
+	}
+
+	// Of the three ravens sharing a key, the two migrated ones pair with their notes
+	if rows := diff.OnlyInSourceRows; len(rows) != 2 || rows[0].ScientificName != "Corvus corax" || rows[0].Confidence != 0.5 || rows[1].ScientificName != "Pica pica" {
+		t.Errorf("DiffDatabases() only in source = %+v, want the new raven and the magpie", rows)
+	}
+	if rows := diff.OnlyInTargetRows; len(rows) != 1 || rows[0].ScientificName != "Sitta europaea" {
+		t.Errorf("DiffDatabases() only in target = %+v, want the nuthatch", rows)
+	}
+
+	if _, err := DiffDatabases(context.Background(), Options{SourceDBPath: sourceDBPath}); err == nil {
+		t.Error("DiffDatabases() without a target succeeded")
+	}
+}
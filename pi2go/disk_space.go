@@ -0,0 +1,249 @@
+// file disk_space.go
+package pi2go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// defaultNoteSize estimates how much a note grows the target database, including its
+	// indexes, when the target holds too few notes to measure it.
+	defaultNoteSize = 512
+
+	// minNotesToMeasure is how many notes the target must hold for its size per note to be used.
+	minNotesToMeasure = 100
+
+	// spaceMarginPercent is the safety margin added to what a run writes to a volume, and
+	// minSpaceMargin the least margin added.
+	spaceMarginPercent = 10
+	minSpaceMargin     = 50 << 20
+)
+
+// SpaceEstimate is the disk space a copy or move needs, worked out before it writes anything
+// from the detections it will migrate and the clips it will transfer.
+type SpaceEstimate struct {
+	Records       int   // Source records the run will migrate
+	Clips         int   // Clips the run will transfer
+	ClipBytes     int64 // Size of those clips
+	ClipsInTarget int   // Clips already in the target, overwritten without taking more space
+	InTargetBytes int64 // Size of the source clips of those
+	ClipsMissing  int   // Clips referenced by the records but not found in the source
+	InPlace       bool  // Clips are moved within one volume, which takes no more space
+	DatabaseBytes int64 // Estimated growth of the target database
+	BackupBytes   int64 // Size of the backup taken of the target database
+
+	Volumes []VolumeSpace // Space needed on each volume the run writes to
+}
+
+// VolumeSpace is the space a run needs on one volume.
+type VolumeSpace struct {
+	Path   string   // Target directory on the volume
+	Needed int64    // Bytes the run writes to the volume, including Margin
+	Margin int64    // Safety margin included in Needed
+	Free   uint64   // Bytes available on the volume
+	For    []string // What is written to the volume, "clips", "database" or "backup"
+}
+
+// Sufficient reports whether the volume has the space needed.
+func (v VolumeSpace) Sufficient() bool {
+	return uint64(v.Needed) <= v.Free
+}
+
+// estimateSpace works out the space the copy or move needs from the detections it will migrate,
+// selected the way convertAndTransferData resumes, without writing to the source or target.
+func (m *migration) estimateSpace() (*SpaceEstimate, error) {
+	estimate := &SpaceEstimate{}
+	quiet := logger.Default.LogMode(logger.Silent)
+
+	sourceDB, err := openLiveSourceDB(m.opts.SourceDBPath, quiet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer closeDB(sourceDB)
+	if !sourceDB.Migrator().HasTable(&Detection{}) {
+		// Nothing to estimate, the run itself reports the missing table
+		return estimate, nil
+	}
+
+	resume, err := m.readResumePoint()
+	if err != nil {
+		return nil, err
+	}
+	whereClause, params := resume.selection()
+	if estimate.Records, err = getTotalRecordCount(sourceDB, whereClause, params...); err != nil {
+		return nil, err
+	}
+
+	noteSize := int64(defaultNoteSize)
+	if resume.notes >= minNotesToMeasure {
+		noteSize = resume.size / resume.notes
+	}
+	estimate.DatabaseBytes = int64(estimate.Records) * noteSize
+	if !m.opts.SkipBackup {
+		estimate.BackupBytes = resume.size
+	}
+
+	if !m.opts.SkipAudioTransfer {
+		if err := m.estimateClips(sourceDB, whereClause, params, estimate); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.estimateVolumes(estimate); err != nil {
+		return nil, err
+	}
+	return estimate, nil
+}
+
+// estimateClips counts the clips of the selected detections and the size of those the run will
+// transfer, leaving out clips missing from the source and clips already in the target.
+func (m *migration) estimateClips(sourceDB *gorm.DB, whereClause string, params []any, estimate *SpaceEstimate) error {
+	const batchSize = 1000
+
+	for offset := 0; offset < estimate.Records; offset += batchSize {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := fetchBatch(sourceDB, offset, batchSize, whereClause, params)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			// Normalize the date as convertDetectionToNote does before the clip is transferred
+			detection := batch[i].Detection
+			if parsed, err := time.Parse(time.RFC3339, detection.Date); err == nil {
+				detection.Date = parsed.Format("2006-01-02")
+			}
+			if to, ok := m.remapping(detection.SciName); ok {
+				detection.SciName = to.scientificName
+			}
+
+			targetPath, err := targetClipPath(&detection, m.opts.TargetFilesDir)
+			if err != nil {
+				// The transfer fails on the date too, writing nothing
+				continue
+			}
+
+			var size int64 = -1
+			for _, candidate := range sourceClipPaths(&detection, m.opts.SourceFilesDir) {
+				if info, err := m.fileSystem().Stat(candidate); err == nil {
+					size = info.Size()
+					break
+				}
+			}
+
+			switch {
+			case size < 0:
+				estimate.ClipsMissing++
+			case m.fileSystem().FileExists(targetPath):
+				estimate.ClipsInTarget++
+				estimate.InTargetBytes += size
+			default:
+				estimate.Clips++
+				estimate.ClipBytes += size
+			}
+		}
+	}
+
+	return nil
+}
+
+// estimateVolumes works out the space needed on the volumes of the target database and clips
+// directory, which are combined if they are the same volume, and how much each has free.
+func (m *migration) estimateVolumes(estimate *SpaceEstimate) error {
+	type need struct {
+		dir   string
+		bytes int64
+		what  string
+	}
+	needs := []need{
+		{filepath.Dir(m.opts.TargetDBPath), estimate.DatabaseBytes, "database"},
+		{filepath.Dir(m.opts.TargetDBPath), estimate.BackupBytes, "backup"},
+	}
+
+	if !m.opts.SkipAudioTransfer {
+		if m.opts.Operation == OperationMove && m.localClips {
+			inPlace, err := sameVolume(m.opts.SourceFilesDir, m.opts.TargetFilesDir)
+			if err != nil {
+				return err
+			}
+			estimate.InPlace = inPlace
+		}
+		if !estimate.InPlace {
+			needs = append(needs, need{m.opts.TargetFilesDir, estimate.ClipBytes, "clips"})
+		}
+	}
+
+	byVolume := make(map[string]int)
+	for _, n := range needs {
+		if n.bytes == 0 {
+			continue
+		}
+
+		dir := existingDir(n.dir)
+		id, err := volumeID(dir)
+		if err != nil {
+			return fmt.Errorf("failed to identify volume of %s: %w", dir, err)
+		}
+
+		i, ok := byVolume[id]
+		if !ok {
+			free, err := getFreeSpace(dir)
+			if err != nil {
+				return fmt.Errorf("failed to read free space of %s: %w", dir, err)
+			}
+			i = len(estimate.Volumes)
+			byVolume[id] = i
+			estimate.Volumes = append(estimate.Volumes, VolumeSpace{Path: n.dir, Free: free})
+		}
+		estimate.Volumes[i].Needed += n.bytes
+		estimate.Volumes[i].For = append(estimate.Volumes[i].For, n.what)
+	}
+
+	for i := range estimate.Volumes {
+		volume := &estimate.Volumes[i]
+		volume.Margin = max(volume.Needed*spaceMarginPercent/100, minSpaceMargin)
+		volume.Needed += volume.Margin
+	}
+
+	return nil
+}
+
+// sameVolume reports whether the paths a and b, or their nearest existing parents, are on the
+// same volume.
+func sameVolume(a, b string) (bool, error) {
+	idA, err := volumeID(existingDir(a))
+	if err != nil {
+		return false, err
+	}
+	idB, err := volumeID(existingDir(b))
+	if err != nil {
+		return false, err
+	}
+	return idA == idB, nil
+}
+
+// existingDir returns path, or its nearest parent directory that exists.
+func existingDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
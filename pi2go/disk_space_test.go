@@ -0,0 +1,167 @@
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetFreeSpace(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary directory
+	tempDir := t.TempDir()
+
+	// Test the getFreeSpace function
+	freeSpace, err := getFreeSpace(tempDir)
+	if err != nil {
+		t.Fatalf("getFreeSpace() error = %v", err)
+	}
+
+	// We can't predict the exact free space, but it should be greater than zero
+	if freeSpace <= 0 {
+		t.Errorf("getFreeSpace() = %d, want > 0", freeSpace)
+	}
+
+	// Test with a non-existent directory
+	nonExistentDir := filepath.Join(tempDir, "non_existent")
+	_, err = getFreeSpace(nonExistentDir)
+	if err == nil {
+		t.Errorf("getFreeSpace() with non-existent directory did not return an error")
+	}
+
+	// Test with an invalid path (platform-specific behavior)
+	var invalidPath string
+	if filepath.Separator == '/' {
+		// Unix-like systems
+		invalidPath = "\000" // null character in path
+	} else {
+		// Windows
+		invalidPath = "\\\\?\\invalid:character"
+	}
+
+	_, err = getFreeSpace(invalidPath)
+	if err == nil {
+		t.Errorf("getFreeSpace() with invalid path did not return an error")
+	}
+}
+
+func TestEstimateSpace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(4)
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, detections[:1])
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	for i, size := range []int{100, 200, 300} { // The last detection has no clip
+		path := sourceClipPaths(&detections[i], sourceFilesDir)[0]
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, make([]byte, size), 0o644)
+	}
+
+	// The first detection is migrated already, the clip of the second is in the target. The
+	// detections are all at one time, the runs resume after the snapshot high-water mark.
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, SkipBackup: true, Snapshot: true})
+	for i := range detections[1:] {
+		insertMockDetection(t, sourceDB, &detections[1+i])
+	}
+	inTarget, _ := targetClipPath(&detections[1], targetFilesDir)
+	os.MkdirAll(filepath.Dir(inTarget), 0o755)
+	os.WriteFile(inTarget, make([]byte, 200), 0o644)
+	stat, _ := os.Stat(targetDBPath)
+	targetSize := stat.Size()
+
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		want    SpaceEstimate // Estimate without its volumes
+		wantFor []string      // What the single volume of the test is needed for
+	}{
+		{
+			name:    "Copy",
+			modify:  func(o *Options) {},
+			want:    SpaceEstimate{Records: 3, Clips: 1, ClipBytes: 300, ClipsInTarget: 1, InTargetBytes: 200, ClipsMissing: 1, DatabaseBytes: 3 * defaultNoteSize, BackupBytes: targetSize},
+			wantFor: []string{"database", "backup", "clips"},
+		},
+		{
+			name:    "Move within one volume",
+			modify:  func(o *Options) { o.Operation = OperationMove },
+			want:    SpaceEstimate{Records: 3, Clips: 1, ClipBytes: 300, ClipsInTarget: 1, InTargetBytes: 200, ClipsMissing: 1, InPlace: true, DatabaseBytes: 3 * defaultNoteSize, BackupBytes: targetSize},
+			wantFor: []string{"database", "backup"},
+		},
+		{
+			name:    "Copy without backup",
+			modify:  func(o *Options) { o.SkipBackup = true },
+			want:    SpaceEstimate{Records: 3, Clips: 1, ClipBytes: 300, ClipsInTarget: 1, InTargetBytes: 200, ClipsMissing: 1, DatabaseBytes: 3 * defaultNoteSize},
+			wantFor: []string{"database", "clips"},
+		},
+		{
+			name:    "Database only",
+			modify:  func(o *Options) { o.SkipAudioTransfer = true },
+			want:    SpaceEstimate{Records: 3, DatabaseBytes: 3 * defaultNoteSize, BackupBytes: targetSize},
+			wantFor: []string{"database", "backup"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{
+				Operation:      OperationCopy,
+				SourceDBPath:   sourceDBPath,
+				TargetDBPath:   targetDBPath,
+				SourceFilesDir: sourceFilesDir,
+				TargetFilesDir: targetFilesDir,
+				Snapshot:       true,
+			}
+			tt.modify(&opts)
+
+			// Declining the run leaves the source and target untouched
+			var estimate *SpaceEstimate
+			opts.Confirm = func(plan *Plan) bool {
+				estimate = plan.Space
+				return false
+			}
+			m, _ := New(opts)
+			result, err := m.Run(context.Background())
+			if !errors.Is(err, ErrNotConfirmed) {
+				t.Fatalf("Run() error = %v, want %v", err, ErrNotConfirmed)
+			}
+			if estimate == nil || result.Space != estimate {
+				t.Fatalf("Confirm() got estimate %+v, result has %+v", estimate, result.Space)
+			}
+
+			if len(estimate.Volumes) != 1 {
+				t.Fatalf("estimate volumes = %+v, want one", estimate.Volumes)
+			}
+			volume := estimate.Volumes[0]
+			written := tt.want.DatabaseBytes + tt.want.BackupBytes
+			if !tt.want.InPlace {
+				written += tt.want.ClipBytes
+			}
+			if volume.Margin != minSpaceMargin || volume.Needed != written+minSpaceMargin || !reflect.DeepEqual(volume.For, tt.wantFor) {
+				t.Errorf("volume = %+v, want %d bytes for %v and a margin of %d", volume, written+minSpaceMargin, tt.wantFor, minSpaceMargin)
+			}
+
+			estimate.Volumes = nil
+			if !reflect.DeepEqual(*estimate, tt.want) {
+				t.Errorf("estimate = %+v, want %+v", *estimate, tt.want)
+			}
+
+			verifyDistinctNotes(t, targetDBPath, 1)
+			if n := countFiles(t, targetFilesDir); n != 1 {
+				t.Errorf("%d clips in the target, want 1", n)
+			}
+			if matches, _ := filepath.Glob(targetDBPath + ".*" + backupSuffix); len(matches) > 0 {
+				t.Errorf("backups %v taken of a declined run", matches)
+			}
+		})
+	}
+}
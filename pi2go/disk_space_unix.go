@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package pi2go
+
+import (
+	"strconv"
+	"syscall"
+)
+
+func getFreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	// Calculate free space available.
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// volumeID identifies the filesystem path is on by its device number.
+func volumeID(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(uint64(stat.Dev), 10), nil //nolint:unconvert // Dev is not 64 bits everywhere
+}
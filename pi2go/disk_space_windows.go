@@ -1,9 +1,11 @@
 //go:build windows
 // +build windows
 
-package main
+package pi2go
 
 import (
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	"golang.org/x/sys/windows"
@@ -24,3 +26,13 @@ func getFreeSpace(path string) (uint64, error) {
 
 	return freeBytesAvailable, nil
 }
+
+// volumeID identifies the volume path is on by its drive letter or UNC share.
+func volumeID(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(filepath.VolumeName(absPath)), nil
+}
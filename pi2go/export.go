@@ -0,0 +1,253 @@
+// file export.go
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// birdNETPiSchema creates the detections table of BirdNET-Pi and its indexes.
+const birdNETPiSchema = `CREATE TABLE IF NOT EXISTS detections (
+	Date DATE, Time TIME, Sci_Name VARCHAR(100) NOT NULL, Com_Name VARCHAR(100) NOT NULL,
+	Confidence FLOAT, Lat FLOAT, Lon FLOAT, Cutoff FLOAT, Week INT, Sens FLOAT, Overlap FLOAT,
+	File_Name VARCHAR(100) NOT NULL);
+CREATE INDEX IF NOT EXISTS "detections_Com_Name" ON "detections" ("Com_Name");
+CREATE INDEX IF NOT EXISTS "detections_Date_Time" ON "detections" ("Date" DESC, "Time" DESC);`
+
+// ErrDetectionsDBExists is returned by Export when the BirdNET-Pi database to write already
+// exists and ExportOptions.Force is not set.
+var ErrDetectionsDBExists = errors.New("BirdNET-Pi database already exists")
+
+// ExportOptions configures an export of a BirdNET-Go database back to BirdNET-Pi.
+type ExportOptions struct {
+	NotesDBPath      string  // BirdNET-Go database to export
+	ClipsDir         string  // BirdNET-Go clips directory, empty to export only the database
+	DetectionsDBPath string  // BirdNET-Pi database to write, created by the export
+	BirdSongsDir     string  // BirdNET-Pi BirdSongs directory the clips are copied to
+	Overlap          float64 // Analysis overlap of detections whose note has none in its metadata
+	Force            bool    // Write into a DetectionsDBPath that exists, such as to resume an export
+
+	FS     FileSystem  // Filesystem the clips are copied on, the local one when nil
+	Logger *log.Logger // Receives the messages of the export, log.Default() when nil
+}
+
+// fileSystem returns the filesystem the clips of the export are copied on.
+func (o *ExportOptions) fileSystem() FileSystem {
+	if o.FS == nil {
+		return OsFS{}
+	}
+	return o.FS
+}
+
+// logger returns the logger of the export.
+func (o *ExportOptions) logger() *log.Logger {
+	if o.Logger == nil {
+		return log.Default()
+	}
+	return o.Logger
+}
+
+// ExportResult counts what an export did.
+type ExportResult struct {
+	Notes         int   // Notes read from the BirdNET-Go database
+	Exported      int   // Detections inserted into the BirdNET-Pi database
+	Skipped       int   // Notes already in the BirdNET-Pi database, such as from an earlier export
+	Failed        int   // Notes that could not be inserted
+	ClipsCopied   int   // Clips copied to the BirdSongs directory
+	ClipsExisting int   // Clips already in the BirdSongs directory
+	ClipsMissing  int   // Clips of notes not found in the clips directory
+	ClipErrors    int   // Clips that could not be copied
+	BytesCopied   int64 // Size of the copied clips
+}
+
+// Export writes the notes of a BirdNET-Go database as detections of a BirdNET-Pi database, and
+// copies their clips into the BirdNET-Pi directory layout under names BirdNET-Pi gives them, as
+// the inverse of a migration. The BirdNET-Go database and clips are not modified. Export returns
+// ErrDetectionsDBExists if the BirdNET-Pi database exists, unless opts.Force is set; notes whose
+// date, time and species are already in it are then not inserted again, so an interrupted export
+// is resumed by running it again with Force. Cancelling ctx stops it after the batch in
+// progress; the result is returned even if Export fails.
+func Export(ctx context.Context, opts ExportOptions) (*ExportResult, error) {
+	result := &ExportResult{}
+	if opts.NotesDBPath == "" || opts.DetectionsDBPath == "" {
+		return result, errors.New("a BirdNET-Go database to export and a BirdNET-Pi database to write are required")
+	}
+	if opts.ClipsDir != "" && opts.BirdSongsDir == "" {
+		return result, errors.New("a BirdSongs directory is required to export clips")
+	}
+	if opts.NotesDBPath == opts.DetectionsDBPath {
+		return result, errors.New("source and target database paths cannot be the same")
+	}
+	if _, err := os.Stat(opts.NotesDBPath); err != nil {
+		return result, fmt.Errorf("BirdNET-Go database not accessible: %w", err)
+	}
+	if _, err := os.Stat(opts.DetectionsDBPath); err == nil && !opts.Force {
+		return result, fmt.Errorf("%s: %w", opts.DetectionsDBPath, ErrDetectionsDBExists)
+	}
+
+	quiet := logger.Default.LogMode(logger.Silent)
+	notesDB, err := openLiveSourceDB(opts.NotesDBPath, quiet)
+	if err != nil {
+		return result, fmt.Errorf("failed to open BirdNET-Go database: %w", err)
+	}
+	defer closeDB(notesDB)
+	if !notesDB.Migrator().HasTable(&Note{}) {
+		return result, errors.New("BirdNET-Go database has no notes table")
+	}
+
+	detectionsDB, err := gorm.Open(sqlite.Open(opts.DetectionsDBPath), &gorm.Config{Logger: quiet})
+	if err != nil {
+		return result, fmt.Errorf("failed to open BirdNET-Pi database: %w", err)
+	}
+	defer closeDB(detectionsDB)
+	if err := detectionsDB.Exec(birdNETPiSchema).Error; err != nil {
+		return result, fmt.Errorf("failed to create detections table: %w", err)
+	}
+
+	const batchSize = 1000
+	var cursor uint
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var notes []Note
+		if err := notesDB.Where("id > ?", cursor).Order("id").Limit(batchSize).Find(&notes).Error; err != nil {
+			return result, fmt.Errorf("failed to read notes: %w", err)
+		}
+		if len(notes) == 0 {
+			break
+		}
+		cursor = notes[len(notes)-1].ID
+
+		metadata, err := readMetadata(notesDB, notes)
+		if err != nil {
+			return result, err
+		}
+
+		detections := make([]Detection, len(notes))
+		err = detectionsDB.Transaction(func(tx *gorm.DB) error {
+			for i := range notes {
+				detections[i] = convertNoteToDetection(&notes[i], opts.Overlap)
+				restoreMetadata(&detections[i], metadata[notes[i].ID])
+				result.Notes++
+
+				var existing int64
+				err := tx.Model(&Detection{}).Where("Date = ? AND Time = ? AND Sci_Name = ?",
+					detections[i].Date, detections[i].Time, detections[i].SciName).Count(&existing).Error
+				if err != nil {
+					return fmt.Errorf("failed to look up detection: %w", err)
+				}
+				if existing > 0 {
+					result.Skipped++
+					continue
+				}
+
+				if err := tx.Create(&detections[i]).Error; err != nil {
+					opts.logger().Printf("Error inserting detection: %v", err)
+					result.Failed++
+					continue
+				}
+				result.Exported++
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		if opts.ClipsDir == "" {
+			continue
+		}
+		for i := range notes {
+			if notes[i].ClipName != "" {
+				exportClip(&notes[i], &detections[i], &opts, result)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// exportClip copies the clip of note to where BirdNET-Pi keeps the clip of detection.
+func exportClip(note *Note, detection *Detection, opts *ExportOptions, result *ExportResult) {
+	source := filepath.Join(opts.ClipsDir, note.ClipName)
+	target := birdNETPiClipPath(detection, opts.BirdSongsDir)
+	fs := opts.fileSystem()
+
+	info, err := fs.Stat(source)
+	if err != nil {
+		result.ClipsMissing++
+		return
+	}
+	if fs.FileExists(target) {
+		result.ClipsExisting++
+		return
+	}
+
+	if err := fs.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		opts.logger().Printf("Failed to create subdirectories: %v", err)
+		result.ClipErrors++
+		return
+	}
+	if err := copyFileWithFS(source, target, fs); err != nil {
+		opts.logger().Printf("Failed to copy %s to %s: %v", source, target, err)
+		result.ClipErrors++
+		return
+	}
+	result.ClipsCopied++
+	result.BytesCopied += info.Size()
+}
+
+// convertNoteToDetection converts a Note record into a BirdNET-Pi Detection, the inverse of
+// convertDetectionToNote. Notes do not keep the week of the detection, which is restored as
+// BirdNET-Pi records it, the ISO week of its date, nor the analysis overlap, which is given;
+// restoreMetadata replaces them with those kept in the metadata of the note.
+func convertNoteToDetection(note *Note, overlap float64) Detection {
+	var week int
+	if parsedDate, err := time.Parse("2006-01-02", note.Date); err == nil {
+		_, week = parsedDate.ISOWeek()
+	}
+
+	ext := filepath.Ext(note.ClipName)
+	if ext == "" {
+		ext = ".mp3"
+	}
+
+	detection := Detection{
+		Date:       note.Date,
+		Time:       note.Time,
+		SciName:    note.ScientificName,
+		ComName:    note.CommonName,
+		Confidence: note.Confidence,
+		Lat:        note.Latitude,
+		Lon:        note.Longitude,
+		Cutoff:     note.Threshold,
+		Week:       week,
+		Sens:       note.Sensitivity,
+		Overlap:    overlap,
+	}
+	detection.FileName = GenerateBirdNETPiFileName(&detection, ext)
+
+	return detection
+}
+
+// restoreMetadata replaces the week and overlap of detection with those kept in the metadata
+// of the note it was converted from, if any.
+func restoreMetadata(detection *Detection, metadata NoteMetadata) {
+	if metadata.Week != nil {
+		detection.Week = *metadata.Week
+	}
+	if metadata.Overlap != nil {
+		detection.Overlap = *metadata.Overlap
+	}
+}
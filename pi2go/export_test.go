@@ -0,0 +1,150 @@
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestConvertNoteToDetection(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		note    Note
+		overlap float64
+		want    Detection
+	}{
+		{
+			name: "Full note",
+			note: Note{Date: "2023-06-01", Time: "05:12:00", ScientificName: "Parus major", CommonName: "Great Tit", Confidence: 0.81,
+				Latitude: 60.1, Longitude: 24.9, Threshold: 0.7, Sensitivity: 1.25, ClipName: "2023/06/parus_major_81p_20230601T051200Z.wav"},
+			overlap: 0.5,
+			want: Detection{Date: "2023-06-01", Time: "05:12:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.81,
+				Lat: 60.1, Lon: 24.9, Cutoff: 0.7, Week: 22, Sens: 1.25, Overlap: 0.5, FileName: "Great_Tit-81-2023-06-01-birdnet-05:12:00.wav"},
+		},
+		{
+			name: "Without a clip, ISO week of the next year",
+			note: Note{Date: "2024-12-30", Time: "23:59:59", ScientificName: "Corvus corax", CommonName: "Common Raven", Confidence: 0.9},
+			want: Detection{Date: "2024-12-30", Time: "23:59:59", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9,
+				Week: 1, FileName: "Common_Raven-90-2024-12-30-birdnet-23:59:59.mp3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := convertNoteToDetection(&tt.note, tt.overlap); got != tt.want {
+				t.Errorf("convertNoteToDetection() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExportRoundTrip migrates a BirdNET-Pi database and its clips to BirdNET-Go and exports them
+// back, expecting the same detections and clips as BirdNET-Pi stored them, including weeks and
+// overlaps the notes keep in their metadata.
+func TestExportRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	const overlap = 0.25
+	detections := []Detection{
+		{Date: "2023-01-15", Time: "07:30:05", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9512,
+			Lat: 60.1699, Lon: 24.9384, Cutoff: 0.7, Week: 2, Sens: 1.25, Overlap: overlap},
+		{Date: "2023-01-15", Time: "08:00:00", SciName: "Accipiter cooperii", ComName: "Cooper's Hawk", Confidence: 0.7049,
+			Lat: 60.1699, Lon: 24.9384, Cutoff: 0.7, Week: 3, Sens: 1.25, Overlap: overlap},
+		{Date: "2023-06-01", Time: "05:12:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.81,
+			Lat: 61.4978, Lon: 23.761, Cutoff: 0.6, Week: 22, Sens: 1, Overlap: overlap},
+		// Named by BirdNET-Pi itself, the export names the clip the same
+		{Date: "2023-06-01", Time: "05:30:15", SciName: "Cyanistes caeruleus", ComName: "Eurasian Blue Tit", Confidence: 0.8712,
+			Lat: 61.4978, Lon: 23.761, Cutoff: 0.6, Week: 22, Sens: 1, Overlap: overlap,
+			FileName: "Eurasian_Blue_Tit-87-2023-06-01-birdnet-05:30:15.mp3"},
+	}
+	for i := range detections {
+		if detections[i].FileName == "" {
+			detections[i].FileName = GenerateBirdNETPiFileName(&detections[i], ".mp3")
+		}
+	}
+
+	tempDir := t.TempDir()
+	sourceDBPath := setupBirdNETPiSourceDB(t, detections)
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	for i := range detections {
+		path := birdNETPiClipPath(&detections[i], sourceFilesDir)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(fmt.Sprintf("audio %d", i)), 0o644)
+	}
+
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, SourceFilesDir: sourceFilesDir,
+		TargetDBPath: targetDBPath, TargetFilesDir: targetFilesDir})
+
+	exportOpts := ExportOptions{
+		NotesDBPath:      targetDBPath,
+		ClipsDir:         targetFilesDir,
+		DetectionsDBPath: filepath.Join(tempDir, "exported", "birds.db"),
+		BirdSongsDir:     filepath.Join(tempDir, "exported", "BirdSongs"),
+		Overlap:          0.5, // Only used for notes without metadata
+	}
+	os.MkdirAll(filepath.Dir(exportOpts.DetectionsDBPath), 0o755)
+	result, err := Export(context.Background(), exportOpts)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if result.Exported != 4 || result.ClipsCopied != 4 || result.ClipsMissing != 0 || result.BytesCopied != 28 {
+		t.Errorf("Export() = %+v, want 4 detections and clips exported", result)
+	}
+
+	exportedDB, err := gorm.Open(sqlite.Open(exportOpts.DetectionsDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open exported database: %v", err)
+	}
+	defer closeDB(exportedDB)
+	batch, err := fetchBatch(exportedDB, 0, 10, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to read exported detections: %v", err)
+	}
+	exported := make([]Detection, len(batch))
+	for i := range batch {
+		exported[i] = batch[i].Detection
+		exported[i].Date = batch[i].RawDate
+	}
+	if !reflect.DeepEqual(exported, detections) {
+		t.Errorf("Exported detections = %+v, want %+v", exported, detections)
+	}
+
+	for i := range detections {
+		got, err := os.ReadFile(birdNETPiClipPath(&detections[i], exportOpts.BirdSongsDir))
+		if want := fmt.Sprintf("audio %d", i); err != nil || string(got) != want {
+			t.Errorf("Exported clip of %s = %q, %v, want %q", detections[i].FileName, got, err, want)
+		}
+	}
+
+	// Exporting again needs Force, and then adds nothing
+	if _, err := Export(context.Background(), exportOpts); !errors.Is(err, ErrDetectionsDBExists) {
+		t.Errorf("Export() into the existing database error = %v, want ErrDetectionsDBExists", err)
+	}
+	exportOpts.Force = true
+	result, err = Export(context.Background(), exportOpts)
+	if err != nil {
+		t.Fatalf("Export() again error = %v", err)
+	}
+	if result.Exported != 0 || result.Skipped != 4 || result.ClipsCopied != 0 || result.ClipsExisting != 4 {
+		t.Errorf("Export() again = %+v, want everything skipped", result)
+	}
+
+	if _, err := Export(context.Background(), ExportOptions{NotesDBPath: targetDBPath, DetectionsDBPath: targetDBPath}); err == nil {
+		t.Error("Export() into the exported database succeeded")
+	}
+}
@@ -0,0 +1,325 @@
+// file fileops.go
+package pi2go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileOperationType defines the type of operation to perform on the audio files.
+type FileOperationType int
+
+const (
+	CopyFile FileOperationType = iota
+	MoveFile
+)
+
+// FileSystem defines an interface for file operations that can be mocked in tests
+type FileSystem interface {
+	MkdirAll(path string, perm fs.FileMode) error
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	FileExists(name string) bool
+}
+
+// OsFS implements FileSystem using the os package
+type OsFS struct{}
+
+func (fs OsFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (fs OsFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (fs OsFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (fs OsFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (fs OsFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (fs OsFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (fs OsFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (fs OsFS) FileExists(name string) bool {
+	_, err := os.Stat(name)
+	return !os.IsNotExist(err)
+}
+
+// DefaultFS is the filesystem of the helpers that take none. Runs use Options.FS instead.
+var DefaultFS FileSystem = OsFS{}
+
+// sourceClipPaths returns the possible locations of a detection's clip in the BirdNET-Pi BirdSongs directory.
+// The species directory may use the common name as is, or with spaces replaced by underscores and apostrophes removed.
+func sourceClipPaths(detection *Detection, sourceFilesDir string) []string {
+	return []string{
+		filepath.Join(sourceFilesDir, "Extracted", "By_Date", detection.Date, detection.ComName, detection.FileName),
+		birdNETPiClipPath(detection, sourceFilesDir),
+	}
+}
+
+// birdNETPiClipPath returns where BirdNET-Pi itself stores a detection's clip in the BirdSongs directory.
+func birdNETPiClipPath(detection *Detection, sourceFilesDir string) string {
+	return filepath.Join(sourceFilesDir, "Extracted", "By_Date", detection.Date, formatBirdNETPiName(detection.ComName), detection.FileName)
+}
+
+// formatBirdNETPiName formats a common name as BirdNET-Pi does in directory and file names:
+// spaces replaced by underscores and apostrophes removed.
+func formatBirdNETPiName(comName string) string {
+	comNameFormatted := strings.ReplaceAll(comName, " ", "_")
+	return strings.ReplaceAll(comNameFormatted, "'", "")
+}
+
+// errSourceFileNotFound is returned when the audio clip of a detection does not exist in the source directory.
+var errSourceFileNotFound = errors.New("source file not found")
+
+// handleFileTransfer processes a detection record, copying or moving the audio file to the target location
+func handleFileTransfer(detection *Detection, sourceFilesDir, targetFilesDir string, operation FileOperationType) error {
+	return handleFileTransferWithFS(detection, sourceFilesDir, targetFilesDir, operation, DefaultFS)
+}
+
+// handleFileTransferWithFS processes a detection record, copying or moving the audio file using the provided filesystem.
+// Failures are logged and also returned so callers can track or retry them.
+func handleFileTransferWithFS(detection *Detection, sourceFilesDir, targetFilesDir string, operation FileOperationType, fs FileSystem) error {
+	_, err := transferClipWithFS(detection, sourceFilesDir, targetFilesDir, operation, fs)
+	return err
+}
+
+// clipTransfer describes a transferred clip.
+type clipTransfer struct {
+	Source string
+	Target string
+	Size   int64
+	SHA256 string // Hex encoded SHA-256 of the clip
+	Moved  bool   // The clip was moved, not copied
+
+	RemoveErr error // Why a move left the source clip in place, after copying it
+}
+
+// transferClipWithFS implements handleFileTransferWithFS and also describes the transferred clip.
+// Successful transfers are not logged, they are counted by the caller.
+func transferClipWithFS(detection *Detection, sourceFilesDir, targetFilesDir string, operation FileOperationType, fs FileSystem) (clipTransfer, error) {
+	// Find the source audio file
+	sourceFilePath, err := findSourceClip(sourceClipPaths(detection, sourceFilesDir), fs)
+	if err != nil {
+		return clipTransfer{}, err
+	}
+
+	// Construct the full target path
+	targetFilePath, err := targetClipPath(detection, targetFilesDir)
+	if err != nil {
+		return clipTransfer{}, err
+	}
+
+	return transferFileWithFS(sourceFilePath, targetFilePath, operation, fs)
+}
+
+// findSourceClip returns the first of candidates that exists on fs, failing with
+// errSourceFileNotFound if none does.
+func findSourceClip(candidates []string, fs FileSystem) (string, error) {
+	for _, candidate := range candidates {
+		if fs.FileExists(candidate) {
+			return candidate, nil
+		}
+	}
+
+	missingPath := candidates[len(candidates)-1]
+	return "", fmt.Errorf("%w: %s", errSourceFileNotFound, missingPath)
+}
+
+// transferFileWithFS copies or moves the clip at sourceFilePath to targetFilePath, creating its
+// directory, and describes the transferred clip.
+func transferFileWithFS(sourceFilePath, targetFilePath string, operation FileOperationType, fs FileSystem) (clipTransfer, error) {
+	targetSubDir := filepath.Dir(targetFilePath)
+
+	// Ensure target directory exists
+	err := fs.MkdirAll(targetSubDir, 0o755)
+	if err != nil {
+		return clipTransfer{}, fmt.Errorf("failed to create subdirectories: %w", err)
+	}
+
+	// Perform the file operation based on the specified operation type
+	var data []byte
+	var moved bool
+	var removeErr error
+	switch operation {
+	case CopyFile:
+		// Read the source file
+		data, err = fs.ReadFile(sourceFilePath)
+		if err != nil {
+			return clipTransfer{}, fmt.Errorf("failed to read source file: %w", err)
+		}
+
+		// Write to the target file
+		err = fs.WriteFile(targetFilePath, data, 0o644)
+		if err != nil {
+			return clipTransfer{}, fmt.Errorf("failed to write target file: %w", err)
+		}
+
+	case MoveFile:
+		// Read the source file
+		data, err = fs.ReadFile(sourceFilePath)
+		if err != nil {
+			return clipTransfer{}, fmt.Errorf("failed to read source file: %w", err)
+		}
+
+		// Write to the target file
+		err = fs.WriteFile(targetFilePath, data, 0o644)
+		if err != nil {
+			return clipTransfer{}, fmt.Errorf("failed to write target file: %w", err)
+		}
+
+		// Remove the source file. If that fails the clip is left in place and described as
+		// copied, so a rollback does not restore it over the original.
+		if removeErr = fs.Remove(sourceFilePath); removeErr == nil {
+			moved = true
+		}
+
+	default:
+		return clipTransfer{}, fmt.Errorf("unsupported file operation: %v", operation)
+	}
+
+	sum := sha256.Sum256(data)
+	return clipTransfer{
+		Source: sourceFilePath,
+		Target: targetFilePath,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+		Moved:  moved,
+
+		RemoveErr: removeErr,
+	}, nil
+}
+
+// targetClipPath returns where the clip of a detection is stored below targetFilesDir, in a
+// year/month subdirectory under a name that follows the BirdNET-Go naming convention.
+func targetClipPath(detection *Detection, targetFilesDir string) (string, error) {
+	parsedDate, err := time.Parse("2006-01-02T15:04:05", detection.Date+"T"+detection.Time)
+	if err != nil {
+		return "", err
+	}
+
+	year := parsedDate.Format("2006")
+	month := parsedDate.Format("01")
+	return filepath.Join(targetFilesDir, year, month, GenerateClipName(detection)), nil
+}
+
+// performFileOperationWithFS abstracts the logic for copying or moving files using the provided filesystem
+func performFileOperationWithFS(sourceFilePath, targetFilePath string, operation FileOperationType, fs FileSystem) error {
+	switch operation {
+	case CopyFile:
+		return copyFileWithFS(sourceFilePath, targetFilePath, fs)
+	case MoveFile:
+		return moveFileWithFS(sourceFilePath, targetFilePath, fs)
+	default:
+		return fmt.Errorf("unsupported file operation")
+	}
+}
+
+// copyFileWithFS handles the copying of a file using the provided filesystem
+func copyFileWithFS(src, dst string, fs FileSystem) error {
+	sourceFile, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := fs.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destinationFile.Close()
+
+	// Perform the actual file copy operation.
+	_, err = io.Copy(destinationFile, sourceFile)
+	return err
+}
+
+// moveFileWithFS handles moving a file using the provided filesystem
+func moveFileWithFS(src, dst string, fs FileSystem) error {
+	// First copy the file
+	if err := copyFileWithFS(src, dst, fs); err != nil {
+		return err
+	}
+	// Then remove the source
+	return fs.Remove(src)
+}
+
+// GenerateClipName generates a standardized filename for audio clips. It returns an empty name
+// if the date and time of detection cannot be parsed.
+func GenerateClipName(detection *Detection) string {
+	// Custom layout to parse the detection date and time.
+	const customLayout = "2006-01-02T15:04:05"
+
+	dateTime := detection.Date + "T" + detection.Time
+
+	parsedDate, err := time.Parse(customLayout, dateTime)
+	if err != nil {
+		return ""
+	}
+
+	// Format the date and time for the filename in the format YYYYMMDDTHHMMSSZ.
+	formattedDateTime := parsedDate.Format("20060102T150405Z")
+
+	// Generate the new filename with the formatted date, time, and confidence level.
+	confidencePercentage := fmt.Sprintf("%dp", int(detection.Confidence*100))
+	newFileName := fmt.Sprintf("%s_%s_%s%s", formatClipSciName(detection.SciName), confidencePercentage, formattedDateTime, filepath.Ext(detection.FileName))
+
+	return newFileName
+}
+
+// formatClipSciName formats a scientific name for a clip name: lowercase, spaces to
+// underscores, hyphens and colons removed.
+func formatClipSciName(sciName string) string {
+	sciNameFormatted := strings.ToLower(sciName)
+	sciNameFormatted = strings.ReplaceAll(sciNameFormatted, " ", "_")
+	sciNameFormatted = strings.ReplaceAll(sciNameFormatted, "-", "")
+	return strings.ReplaceAll(sciNameFormatted, ":", "")
+}
+
+// GenerateBirdNETPiFileName generates the filename BirdNET-Pi gives the clip of a detection, in
+// the format Common_Name-<confidence percentage>-YYYY-MM-DD-birdnet-HH:MM:SS<ext>, with the
+// confidence rounded to the nearest percent. It is the inverse of GenerateClipName, which
+// carries the extension over from this name.
+func GenerateBirdNETPiFileName(detection *Detection, ext string) string {
+	confidencePercentage := int(math.Round(detection.Confidence * 100))
+	return fmt.Sprintf("%s-%d-%s-birdnet-%s%s", formatBirdNETPiName(detection.ComName), confidencePercentage, detection.Date, detection.Time, ext)
+}
+
+// For backward compatibility, keep these functions that use the OS filesystem directly
+func performFileOperation(sourceFilePath, targetFilePath string, operation FileOperationType) error {
+	return performFileOperationWithFS(sourceFilePath, targetFilePath, operation, DefaultFS)
+}
+
+func copyFile(src, dst string) error {
+	return copyFileWithFS(src, dst, DefaultFS)
+}
+
+func moveFile(src, dst string) error {
+	return moveFileWithFS(src, dst, DefaultFS)
+}
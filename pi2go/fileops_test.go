@@ -1,4 +1,4 @@
-package main
+package pi2go
 
 import (
 	"bytes"
@@ -63,6 +63,52 @@ func TestGenerateClipName(t *testing.T) {
 	}
 }
 
+func TestGenerateBirdNETPiFileName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		detection Detection
+		ext       string
+		want      string
+	}{
+		{
+			name:      "Basic detection",
+			detection: Detection{Date: "2023-01-01", Time: "12:34:56", ComName: "Common Raven", Confidence: 0.95},
+			ext:       ".mp3",
+			want:      "Common_Raven-95-2023-01-01-birdnet-12:34:56.mp3",
+		},
+		{
+			name:      "Apostrophe in name",
+			detection: Detection{Date: "2023-05-02", Time: "06:00:01", ComName: "Cooper's Hawk", Confidence: 0.7},
+			ext:       ".wav",
+			want:      "Coopers_Hawk-70-2023-05-02-birdnet-06:00:01.wav",
+		},
+		{
+			name:      "Confidence is rounded",
+			detection: Detection{Date: "2023-05-02", Time: "06:00:01", ComName: "Great Tit", Confidence: 0.8766},
+			ext:       ".mp3",
+			want:      "Great_Tit-88-2023-05-02-birdnet-06:00:01.mp3",
+		},
+		{
+			name:      "Half a percent is rounded up",
+			detection: Detection{Date: "2023-05-01", Time: "06:12:00", ComName: "Cooper's Hawk", Confidence: 0.915},
+			ext:       ".mp3",
+			want:      "Coopers_Hawk-92-2023-05-01-birdnet-06:12:00.mp3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := GenerateBirdNETPiFileName(&tt.detection, tt.ext)
+			if got != tt.want {
+				t.Errorf("GenerateBirdNETPiFileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCopyFile(t *testing.T) {
 	t.Parallel()
 
@@ -378,7 +424,7 @@ func TestHandleFileTransferWithRealData(t *testing.T) {
 	t.Parallel()
 
 	// Connect to the birds.db database using our read-only method
-	db := initializeAndMigrateSourceDB("birds.db", logger.New(
+	db, err := initializeAndMigrateSourceDB("birds.db", logger.New(
 		nil, // Don't log to stdout during tests
 		logger.Config{
 			SlowThreshold: 1 * time.Second,
@@ -386,10 +432,13 @@ func TestHandleFileTransferWithRealData(t *testing.T) {
 			Colorful:      false,
 		},
 	))
+	if err != nil {
+		t.Fatalf("Failed to open birds.db: %v", err)
+	}
 
 	// Get a sample of detections from the database
 	var detections []Detection
-	err := db.Limit(5).Find(&detections).Error
+	err = db.Limit(5).Find(&detections).Error
 	if err != nil {
 		t.Fatalf("Failed to fetch detections from birds.db: %v", err)
 	}
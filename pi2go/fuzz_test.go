@@ -1,4 +1,4 @@
-package main
+package pi2go
 
 import (
 	"fmt"
@@ -0,0 +1,663 @@
+// file httpfs.go
+package pi2go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// httpRetries is how many times a failed request is retried.
+	httpRetries = 3
+
+	// defaultHTTPConcurrency is the default number of simultaneous requests to the web server.
+	defaultHTTPConcurrency = 4
+)
+
+// httpRetryDelay is the initial delay between retries, doubled after every attempt. It is a
+// variable so tests of failing sources do not have to wait for it.
+var httpRetryDelay = time.Second
+
+// listingLinkPattern matches links in an HTML directory listing.
+var listingLinkPattern = regexp.MustCompile(`(?i)<a\s[^>]*href="([^"]+)"`)
+
+// isHTTPSource reports whether a source spec refers to a BirdNET-Pi web server.
+func isHTTPSource(spec string) bool {
+	return strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://")
+}
+
+// httpEntry is a file or directory found in a directory listing.
+type httpEntry struct {
+	name    string
+	size    int64 // -1 if the listing did not include the size
+	modTime time.Time
+	isDir   bool
+}
+
+// httpFileInfo implements fs.FileInfo for web server entries.
+type httpFileInfo struct{ entry httpEntry }
+
+func (i httpFileInfo) Name() string       { return i.entry.name }
+func (i httpFileInfo) Size() int64        { return i.entry.size }
+func (i httpFileInfo) ModTime() time.Time { return i.entry.modTime }
+func (i httpFileInfo) IsDir() bool        { return i.entry.isDir }
+func (i httpFileInfo) Sys() any           { return nil }
+
+func (i httpFileInfo) Mode() fs.FileMode {
+	if i.entry.isDir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// httpStatusError is returned for unexpected HTTP responses.
+type httpStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, http.StatusText(e.StatusCode))
+}
+
+// temporary reports whether the request may succeed when retried.
+func (e *httpStatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// HTTPFS implements a read-only FileSystem on top of the BirdNET-Pi web server, whose document
+// root is the BirdSongs/Extracted directory. Paths are relative to the BirdSongs directory,
+// so Extracted/By_Date/... is fetched from <BaseURL>/By_Date/... Files are found through the
+// server's directory listings and read with resumable, retried requests, at most Concurrency
+// at a time.
+type HTTPFS struct {
+	BaseURL *url.URL
+	Logger  *log.Logger // Receives retries and resumed downloads, log.Default() when nil
+
+	client     *http.Client
+	slots      chan struct{}
+	retryDelay time.Duration
+
+	mu       sync.Mutex
+	listings map[string]*httpListing
+}
+
+// httpListing caches a directory listing, err is set if the directory could not be listed.
+type httpListing struct {
+	ready   chan struct{}
+	entries map[string]httpEntry
+	err     error
+}
+
+// NewHTTPFS returns a filesystem reading from the web server at baseURL with at most
+// concurrency simultaneous requests.
+func NewHTTPFS(baseURL string, concurrency int) (*HTTPFS, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL %q: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported source URL scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if concurrency < 1 {
+		concurrency = defaultHTTPConcurrency
+	}
+
+	return &HTTPFS{
+		BaseURL:    u,
+		client:     &http.Client{},
+		slots:      make(chan struct{}, concurrency),
+		retryDelay: httpRetryDelay,
+		listings:   make(map[string]*httpListing),
+	}, nil
+}
+
+// acquire waits for a free request slot and returns the function releasing it.
+func (h *HTTPFS) acquire() func() {
+	h.slots <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-h.slots }) }
+}
+
+// resolve returns the URL of a path relative to the BirdSongs directory.
+func (h *HTTPFS) resolve(name string, isDir bool) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "Extracted"), "/")
+	if isDir && name != "" {
+		name += "/"
+	}
+
+	u := h.BaseURL.JoinPath(name)
+	u.User = nil
+	return u.String()
+}
+
+// newRequest creates a request carrying the credentials from the base URL.
+func (h *HTTPFS) newRequest(method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.BaseURL.User != nil {
+		password, _ := h.BaseURL.User.Password()
+		req.SetBasicAuth(h.BaseURL.User.Username(), password)
+	}
+
+	return req, nil
+}
+
+// logger returns the logger of the filesystem.
+func (h *HTTPFS) logger() *log.Logger {
+	if h.Logger == nil {
+		return log.Default()
+	}
+	return h.Logger
+}
+
+// withRetry runs op, retrying with backoff on network errors and temporary server errors.
+func (h *HTTPFS) withRetry(op func() error) error {
+	delay := h.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !isRetryableHTTPError(err) || attempt >= httpRetries {
+			return err
+		}
+		h.logger().Printf("HTTP request failed, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isRetryableHTTPError reports whether a request error is worth retrying.
+func isRetryableHTTPError(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.temporary()
+	}
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// do sends a request and returns the response if its status is one of the accepted ones.
+// A missing resource is reported as fs.ErrNotExist.
+func (h *HTTPFS) do(req *http.Request, accepted ...int) (*http.Response, error) {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range accepted {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(req.Method), Path: req.URL.String(), Err: fs.ErrNotExist}
+	}
+	return nil, &httpStatusError{Method: req.Method, URL: req.URL.String(), StatusCode: resp.StatusCode}
+}
+
+// listDir returns the entries of a directory, fetching its listing once.
+func (h *HTTPFS) listDir(dir string) (map[string]httpEntry, error) {
+	key := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(dir)), "/")
+
+	h.mu.Lock()
+	listing, ok := h.listings[key]
+	if !ok {
+		listing = &httpListing{ready: make(chan struct{})}
+		h.listings[key] = listing
+	}
+	h.mu.Unlock()
+
+	if ok {
+		<-listing.ready
+		return listing.entries, listing.err
+	}
+
+	release := h.acquire()
+	listing.err = h.withRetry(func() error {
+		var err error
+		listing.entries, err = h.fetchListing(h.resolve(dir, true))
+		return err
+	})
+	release()
+	close(listing.ready)
+
+	return listing.entries, listing.err
+}
+
+// fetchListing downloads and parses a directory listing. Caddy, which BirdNET-Pi uses, answers
+// with JSON including file sizes when asked; other servers are parsed from their HTML index.
+func (h *HTTPFS) fetchListing(dirURL string) (map[string]httpEntry, error) {
+	req, err := h.newRequest(http.MethodGet, dirURL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, text/html;q=0.9")
+
+	resp, err := h.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return parseJSONListing(body)
+	}
+	return parseHTMLListing(body), nil
+}
+
+// parseJSONListing parses a Caddy file_server browse listing.
+func parseJSONListing(body []byte) (map[string]httpEntry, error) {
+	var items []struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"mod_time"`
+		IsDir   bool      `json:"is_dir"`
+	}
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("invalid directory listing: %w", err)
+	}
+
+	entries := make(map[string]httpEntry, len(items))
+	for _, item := range items {
+		name := strings.TrimSuffix(item.Name, "/")
+		entries[name] = httpEntry{name: name, size: item.Size, modTime: item.ModTime, isDir: item.IsDir}
+	}
+
+	return entries, nil
+}
+
+// parseHTMLListing extracts the entries of the current directory from an HTML index page.
+// Sizes are not reliably present in HTML listings and are left unknown.
+func parseHTMLListing(body []byte) map[string]httpEntry {
+	entries := make(map[string]httpEntry)
+	for _, match := range listingLinkPattern.FindAllSubmatch(body, -1) {
+		href := html.UnescapeString(string(match[1]))
+		if strings.ContainsAny(href, "?#") || strings.HasPrefix(href, "/") || strings.Contains(href, "://") {
+			continue // Sorting links, parent directory or other pages
+		}
+		href = strings.TrimPrefix(href, "./")
+
+		isDir := strings.HasSuffix(href, "/")
+		name, err := url.PathUnescape(strings.TrimSuffix(href, "/"))
+		if err != nil || name == "" || name == ".." || strings.Contains(name, "/") {
+			continue
+		}
+		entries[name] = httpEntry{name: name, size: -1, isDir: isDir}
+	}
+
+	return entries
+}
+
+// head fetches the size and modification time of a file.
+func (h *HTTPFS) head(name string) (httpEntry, error) {
+	entry, err := h.headURL(h.resolve(name, false))
+	entry.name = path.Base(filepath.ToSlash(name))
+	return entry, err
+}
+
+// headURL fetches the size and modification time of the file at an absolute URL.
+func (h *HTTPFS) headURL(rawURL string) (httpEntry, error) {
+	release := h.acquire()
+	defer release()
+
+	entry := httpEntry{size: -1}
+	err := h.withRetry(func() error {
+		req, err := h.newRequest(http.MethodHead, rawURL)
+		if err != nil {
+			return err
+		}
+		resp, err := h.do(req, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		entry.size = resp.ContentLength
+		entry.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+		return nil
+	})
+
+	return entry, err
+}
+
+// DownloadFile downloads a URL relative to the base URL, or an absolute URL, to a local file.
+// Data is first written to localPath.part, so an interrupted download continues where it stopped,
+// also in a later run unless the file has changed since.
+func (h *HTTPFS) DownloadFile(rawURL, localPath string) error {
+	ref, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	target := h.BaseURL.ResolveReference(ref)
+	target.User = nil
+
+	// Without the size and modification time, as from a server refusing HEAD requests, a
+	// partial download of an earlier run is started over
+	remote, err := h.headURL(target.String())
+	if err != nil {
+		h.logger().Printf("Failed to check %s for changes: %v", target.Redacted(), err)
+	}
+
+	partPath := localPath + ".part"
+	out, err := openPartFile(localPath, remote.size, remote.modTime)
+	if err != nil {
+		return err
+	}
+
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if info.Size() > 0 {
+		h.logger().Printf("Resuming download of %s at %d bytes", target.Redacted(), info.Size())
+	}
+
+	release := h.acquire()
+	reader := &httpFileReader{fs: h, url: target.String(), offset: info.Size(), release: release}
+	_, err = io.Copy(out, reader)
+	reader.Close()
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("failed to download %s: %w", target.Redacted(), err)
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(partPath, localPath)
+}
+
+// TotalSize returns the size of all clips below Extracted/By_Date.
+func (h *HTTPFS) TotalSize() (int64, error) {
+	return h.dirSize(filepath.Join("Extracted", "By_Date"))
+}
+
+// dirSize sums the sizes of all files below dir, asking for sizes the listing did not include.
+func (h *HTTPFS) dirSize(dir string) (int64, error) {
+	entries, err := h.listDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.name)
+		if entry.isDir {
+			size, err := h.dirSize(name)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+			continue
+		}
+
+		if entry.size < 0 {
+			if entry, err = h.head(name); err != nil {
+				return 0, err
+			}
+		}
+		total += entry.size
+	}
+
+	return total, nil
+}
+
+func (h *HTTPFS) MkdirAll(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: errReadOnlyFS}
+}
+
+// Stat looks a file up in its directory's listing, falling back to a HEAD request
+// when the server does not list directories.
+func (h *HTTPFS) Stat(name string) (fs.FileInfo, error) {
+	base := path.Base(filepath.ToSlash(name))
+	if base == "." || base == "/" || (base == "Extracted" && filepath.Dir(name) == ".") {
+		if _, err := h.listDir(name); err != nil {
+			return nil, err
+		}
+		return httpFileInfo{entry: httpEntry{name: base, isDir: true}}, nil
+	}
+
+	entries, err := h.listDir(filepath.Dir(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		entry, headErr := h.head(name)
+		if headErr != nil {
+			return nil, headErr
+		}
+		return httpFileInfo{entry: entry}, nil
+	}
+
+	entry, ok := entries[base]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return httpFileInfo{entry: entry}, nil
+}
+
+func (h *HTTPFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnlyFS}
+}
+
+func (h *HTTPFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: errReadOnlyFS}
+}
+
+// Open streams a file from the web server. The returned reader holds a request slot until closed.
+func (h *HTTPFS) Open(name string) (io.ReadCloser, error) {
+	reader := &httpFileReader{fs: h, url: h.resolve(name, false), release: h.acquire()}
+	if err := h.withRetry(reader.open); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+func (h *HTTPFS) ReadFile(name string) ([]byte, error) {
+	reader, err := h.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (h *HTTPFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: errReadOnlyFS}
+}
+
+func (h *HTTPFS) FileExists(name string) bool {
+	_, err := h.Stat(name)
+	return err == nil
+}
+
+// httpFileReader reads a file from the web server, continuing with a range request
+// from the current offset if the connection drops.
+type httpFileReader struct {
+	fs        *HTTPFS
+	url       string
+	offset    int64
+	validator string // ETag or Last-Modified of the first response
+	body      io.ReadCloser
+	attempts  int
+	release   func()
+}
+
+// open requests the file from the current offset.
+func (r *httpFileReader) open() error {
+	req, err := r.fs.newRequest(http.MethodGet, r.url)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+		if r.validator != "" {
+			req.Header.Set("If-Range", r.validator)
+		}
+	}
+
+	resp, err := r.fs.do(req, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return err
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	// A full response to a range request means the server ignored the range or the file changed
+	if r.offset > 0 && resp.StatusCode == http.StatusOK {
+		if r.validator != "" && validator != r.validator {
+			resp.Body.Close()
+			return fmt.Errorf("%s changed during download", r.url)
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	}
+
+	r.validator = validator
+	r.body = resp.Body
+	return nil
+}
+
+func (r *httpFileReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.fs.withRetry(r.open); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		}
+
+		// The connection dropped, continue from the current offset
+		r.body.Close()
+		r.body = nil
+		if r.attempts >= httpRetries {
+			return n, err
+		}
+		r.attempts++
+		r.fs.logger().Printf("Download of %s interrupted at %d bytes, resuming: %v", r.url, r.offset, err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *httpFileReader) Close() error {
+	defer r.release()
+
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// prepareHTTPSource downloads the database from a BirdNET-Pi web server to the download cache
+// and returns the web filesystem of its clips, to be mounted at filesDir. The database defaults
+// to <spec>/birds.db, which BirdNET-Pi links into its web root, and the clips to the web root
+// itself; both can be overridden with URLs or paths relative to spec. It returns the local
+// database path, the source directory to use, the web filesystem and a cleanup function.
+func prepareHTTPSource(spec, remoteDBPath, remoteFilesDir string, concurrency int, logger *log.Logger) (dbPath, filesDir string, httpFS *HTTPFS, cleanup func(), err error) {
+	if remoteDBPath == "" {
+		remoteDBPath = "birds.db"
+	}
+	baseURL := spec
+	if remoteFilesDir != "" {
+		base, err := url.Parse(spec)
+		if err != nil {
+			return "", "", nil, nil, fmt.Errorf("invalid source URL %q: %w", spec, err)
+		}
+		ref, err := url.Parse(remoteFilesDir)
+		if err != nil {
+			return "", "", nil, nil, fmt.Errorf("invalid source directory %q: %w", remoteFilesDir, err)
+		}
+		baseURL = base.ResolveReference(ref).String()
+	}
+
+	httpFS, err = NewHTTPFS(baseURL, concurrency)
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	httpFS.Logger = logger
+
+	dbURL, err := url.Parse(spec)
+	if err == nil {
+		var ref *url.URL
+		if ref, err = url.Parse(remoteDBPath); err == nil {
+			dbURL = dbURL.ResolveReference(ref)
+		}
+	}
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("invalid database URL %q: %w", remoteDBPath, err)
+	}
+
+	// Partial downloads are kept in the cache directory of the source for the next run
+	cacheURL := *dbURL
+	cacheURL.User = nil
+	cacheDir, err := downloadCacheDir(cacheURL.String())
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+
+	logger.Printf("Downloading %s", dbURL.Redacted())
+	dbPath = filepath.Join(cacheDir, "birds.db")
+	if err := httpFS.DownloadFile(dbURL.String(), dbPath); err != nil {
+		removeDownload(cacheDir, "")
+		return "", "", nil, nil, err
+	}
+
+	cleanup = func() { removeDownload(cacheDir, dbPath) }
+
+	// BirdNET-Pi may have been writing to the database while it was served
+	if !isSourceDBUsable(dbPath) {
+		cleanup()
+		return "", "", nil, nil, fmt.Errorf("downloaded database %s is not usable, try again", dbURL.Redacted())
+	}
+
+	// Clips are addressed below a mount point named after the server so they cannot be
+	// confused with local paths
+	filesDir = httpFS.BaseURL.Scheme + ":" + httpFS.BaseURL.Host
+
+	return dbPath, filesDir, httpFS, cleanup, nil
+}
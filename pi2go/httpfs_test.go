@@ -0,0 +1,427 @@
+package pi2go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestWebServer serves root like the BirdNET-Pi web server. With caddyListings, directory
+// listings are answered with JSON when requested, otherwise with Go's HTML index pages.
+func startTestWebServer(t *testing.T, root string, caddyListings bool, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+
+	var handler http.Handler = http.FileServer(http.Dir(root))
+	if caddyListings {
+		fileServer := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/") || !strings.Contains(r.Header.Get("Accept"), "json") {
+				fileServer.ServeHTTP(w, r)
+				return
+			}
+
+			entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(r.URL.Path)))
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+
+			type item struct {
+				Name    string    `json:"name"`
+				Size    int64     `json:"size"`
+				ModTime time.Time `json:"mod_time"`
+				IsDir   bool      `json:"is_dir"`
+			}
+			var items []item
+			for _, entry := range entries {
+				info, _ := entry.Info()
+				items = append(items, item{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime(), IsDir: entry.IsDir()})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(items)
+		})
+	}
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// writeTestWebRoot lays out a BirdNET-Pi web root with two clips and returns its path.
+func writeTestWebRoot(t *testing.T, dbContent []byte) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range map[string][]byte{
+		"birds.db":                              dbContent,
+		"By_Date/2023-01-15/Test Bird/test.mp3": []byte("test audio"),
+		"By_Date/2023-01-16/Great_Tit/tit.mp3":  []byte("tit audio"),
+		"Charts/chart.png":                      []byte("chart"),
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	return root
+}
+
+func TestParseHTMLListing(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`<html><body>
+<a href="../">Parent</a>
+<a href="?sort=name">Name</a>
+<a href="/index.php">Home</a>
+<A HREF="Test%20Bird/">Test Bird/</A>
+<a class="file" href="./Great_Tit_92.mp3">Great_Tit_92.mp3</a>
+<a href="a&amp;b.mp3">a&amp;b.mp3</a>
+</body></html>`)
+
+	entries := parseHTMLListing(body)
+
+	want := map[string]bool{"Test Bird": true, "Great_Tit_92.mp3": false, "a&b.mp3": false}
+	if len(entries) != len(want) {
+		t.Errorf("parseHTMLListing() returned %d entries, want %d: %v", len(entries), len(want), entries)
+	}
+	for name, isDir := range want {
+		entry, ok := entries[name]
+		if !ok {
+			t.Errorf("parseHTMLListing() is missing %q", name)
+			continue
+		}
+		if entry.isDir != isDir || entry.size != -1 {
+			t.Errorf("entry %q = %+v, want isDir %v and unknown size", name, entry, isDir)
+		}
+	}
+}
+
+func TestParseJSONListing(t *testing.T) {
+	t.Parallel()
+
+	entries, err := parseJSONListing([]byte(`[{"name":"Test Bird/","size":4096,"is_dir":true},{"name":"test.mp3","size":10,"is_dir":false}]`))
+	if err != nil {
+		t.Fatalf("parseJSONListing() error = %v", err)
+	}
+	if entry := entries["Test Bird"]; !entry.isDir {
+		t.Errorf("entry Test Bird = %+v, want directory", entry)
+	}
+	if entry := entries["test.mp3"]; entry.isDir || entry.size != 10 {
+		t.Errorf("entry test.mp3 = %+v, want 10 byte file", entry)
+	}
+
+	if _, err := parseJSONListing([]byte("<html>")); err == nil {
+		t.Errorf("parseJSONListing() of HTML did not return an error")
+	}
+}
+
+func TestHTTPFS(t *testing.T) {
+	t.Parallel()
+
+	for _, caddy := range []bool{false, true} {
+		name := "HTML listings"
+		if caddy {
+			name = "JSON listings"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := startTestWebServer(t, writeTestWebRoot(t, []byte("db")), caddy, nil)
+			httpFS, err := NewHTTPFS(server.URL, 2)
+			if err != nil {
+				t.Fatalf("NewHTTPFS() error = %v", err)
+			}
+
+			clip := filepath.Join("Extracted", "By_Date", "2023-01-15", "Test Bird", "test.mp3")
+			if !httpFS.FileExists(clip) {
+				t.Errorf("FileExists(%s) = false, want true", clip)
+			}
+			if httpFS.FileExists(filepath.Join("Extracted", "By_Date", "2023-01-15", "Test Bird", "missing.mp3")) {
+				t.Errorf("FileExists() of missing file = true, want false")
+			}
+			if httpFS.FileExists(filepath.Join("Extracted", "By_Date", "2023-02-01", "Test Bird", "test.mp3")) {
+				t.Errorf("FileExists() in missing directory = true, want false")
+			}
+
+			info, err := httpFS.Stat(filepath.Join("Extracted", "By_Date"))
+			if err != nil || !info.IsDir() {
+				t.Errorf("Stat() of directory = %v, %v, want directory", info, err)
+			}
+
+			data, err := httpFS.ReadFile(clip)
+			if err != nil || string(data) != "test audio" {
+				t.Errorf("ReadFile() = %q, %v, want %q", data, err, "test audio")
+			}
+			if _, err := httpFS.ReadFile("missing.mp3"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("ReadFile() of missing file error = %v, want not exist", err)
+			}
+
+			if size, err := httpFS.TotalSize(); err != nil || size != int64(len("test audio")+len("tit audio")) {
+				t.Errorf("TotalSize() = %d, %v, want %d", size, err, len("test audio")+len("tit audio"))
+			}
+
+			if err := httpFS.WriteFile("new.mp3", []byte("x"), 0o644); !errors.Is(err, errReadOnlyFS) {
+				t.Errorf("WriteFile() error = %v, want %v", err, errReadOnlyFS)
+			}
+			if err := httpFS.Remove(clip); !errors.Is(err, errReadOnlyFS) {
+				t.Errorf("Remove() error = %v, want %v", err, errReadOnlyFS)
+			}
+		})
+	}
+}
+
+func TestHTTPFSRetriesAndResumes(t *testing.T) {
+	t.Parallel()
+
+	var requests, rangeRequests atomic.Int32
+	server := startTestWebServer(t, writeTestWebRoot(t, []byte("db")), false, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "test.mp3") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch requests.Add(1) {
+			case 1:
+				// A temporarily overloaded server
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case 2:
+				// The connection drops halfway through the body
+				w.Header().Set("Content-Length", "10")
+				w.Header().Set("ETag", `"clip"`)
+				w.Write([]byte("test "))
+				w.(http.Flusher).Flush()
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			default:
+				if r.Header.Get("Range") != "" {
+					rangeRequests.Add(1)
+				}
+				w.Header().Set("ETag", `"clip"`)
+				next.ServeHTTP(w, r)
+			}
+		})
+	})
+
+	httpFS, err := NewHTTPFS(server.URL, 1)
+	if err != nil {
+		t.Fatalf("NewHTTPFS() error = %v", err)
+	}
+	httpFS.retryDelay = time.Millisecond
+
+	data, err := httpFS.ReadFile(filepath.Join("Extracted", "By_Date", "2023-01-15", "Test Bird", "test.mp3"))
+	if err != nil || string(data) != "test audio" {
+		t.Errorf("ReadFile() = %q, %v, want %q", data, err, "test audio")
+	}
+	if rangeRequests.Load() != 1 {
+		t.Errorf("range requests = %d, want 1", rangeRequests.Load())
+	}
+}
+
+func TestHTTPFSConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	var active, maxActive atomic.Int32
+	server := startTestWebServer(t, writeTestWebRoot(t, []byte("db")), false, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for {
+				current := maxActive.Load()
+				if n <= current || maxActive.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	httpFS, err := NewHTTPFS(server.URL, 2)
+	if err != nil {
+		t.Fatalf("NewHTTPFS() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := httpFS.ReadFile(filepath.Join("Extracted", "By_Date", "2023-01-16", "Great_Tit", "tit.mp3")); err != nil {
+				t.Errorf("ReadFile() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxActive.Load() > 2 {
+		t.Errorf("simultaneous requests = %d, want at most 2", maxActive.Load())
+	}
+}
+
+func TestConvertAndTransferDataFromHTTP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "13:45:30", SciName: "Testus birdus", ComName: "Test Bird", Confidence: 0.85, FileName: "test.mp3"},
+		{Date: "2023-01-16", Time: "09:15:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.92, FileName: "tit.mp3"},
+	})
+	dbContent, err := os.ReadFile(sourceDBPath)
+	if err != nil {
+		t.Fatalf("Failed to read source database: %v", err)
+	}
+	server := startTestWebServer(t, writeTestWebRoot(t, dbContent), true, nil)
+
+	dbPath, _, httpFS, cleanup, err := prepareHTTPSource(server.URL, "", "", 2, log.Default())
+	if err != nil {
+		t.Fatalf("prepareHTTPSource() error = %v", err)
+	}
+	if httpFS == nil {
+		t.Fatalf("prepareHTTPSource() did not return the web filesystem")
+	}
+
+	cleanup()
+
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("downloaded database was not removed after cleanup")
+	}
+
+	// The migration prepares and cleans up the source itself
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	result := runMigration(t, Options{Operation: OperationCopy, Source: server.URL, HTTPConcurrency: 2, TargetDBPath: targetDBPath, TargetFilesDir: targetFilesDir})
+	if result.ClipsTransferred != 2 {
+		t.Errorf("clips transferred = %d, want 2", result.ClipsTransferred)
+	}
+	if _, ok := DefaultFS.(OsFS); !ok {
+		t.Errorf("DefaultFS was replaced by the migration")
+	}
+
+	verifyNoteCount(t, targetDBPath, 2)
+
+	for name, want := range map[string]string{
+		filepath.Join(targetFilesDir, "2023", "01", "testus_birdus_85p_20230115T134530Z.mp3"): "test audio",
+		filepath.Join(targetFilesDir, "2023", "01", "parus_major_92p_20230116T091500Z.mp3"):   "tit audio",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("clip %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	if _, _, _, _, err := prepareHTTPSource(server.URL, "missing.db", "", 2, log.Default()); err == nil {
+		t.Errorf("prepareHTTPSource() with missing database did not return an error")
+	}
+}
+
+func TestResumeDownloadFromHTTP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Keep partial downloads in a cache of the test and fail fast on dropped connections
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	retryDelay := httpRetryDelay
+	httpRetryDelay = time.Millisecond
+	t.Cleanup(func() { httpRetryDelay = retryDelay })
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, checkpointTestDetections(1000))
+	dbContent, err := os.ReadFile(sourceDBPath)
+	if err != nil {
+		t.Fatalf("Failed to read source database: %v", err)
+	}
+	half := len(dbContent) / 2
+
+	// Until the server recovers, the database download drops halfway and does not resume
+	var recovered atomic.Bool
+	var ranges sync.Map
+	server := startTestWebServer(t, writeTestWebRoot(t, dbContent), false, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "birds.db") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if recovered.Load() {
+				if rng := r.Header.Get("Range"); rng != "" {
+					ranges.Store(rng, true)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("Range") == "" {
+				w.Header().Set("Content-Length", fmt.Sprint(len(dbContent)))
+				w.Write(dbContent[:half])
+				w.(http.Flusher).Flush()
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		})
+	})
+
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	opts := Options{Operation: OperationCopy, Source: server.URL, TargetDBPath: targetDBPath, SkipAudioTransfer: true}
+	m, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.Run(context.Background()); err == nil {
+		t.Fatalf("Run() over a dropped connection succeeded")
+	}
+
+	parts, _ := filepath.Glob(filepath.Join(cacheHome, "birdnet-pi2go", "downloads", "*", "birds.db.part"))
+	if len(parts) != 1 {
+		t.Fatalf("partial downloads after the interrupted run = %v, want one", parts)
+	}
+	if part, err := os.Stat(parts[0]); err != nil || part.Size() != int64(half) {
+		t.Fatalf("partial download = %v, %v, want %d bytes", part, err, half)
+	}
+
+	// The next run continues the download where the first one stopped
+	recovered.Store(true)
+	var logBuf bytes.Buffer
+	opts.Logger = log.New(&logBuf, "", 0)
+	result := runMigration(t, opts)
+	if result.NotesInserted != 1000 {
+		t.Errorf("resumed Run() inserted %d notes, want 1000", result.NotesInserted)
+	}
+	want := fmt.Sprintf("bytes=%d-", half)
+	if _, ok := ranges.Load(want); !ok {
+		t.Errorf("resumed run did not request range %s, log:\n%s", want, logBuf.String())
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(cacheHome, "birdnet-pi2go", "downloads")); len(entries) != 0 {
+		t.Errorf("download cache after the completed run holds %d entries, want none", len(entries))
+	}
+}
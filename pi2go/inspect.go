@@ -0,0 +1,344 @@
+// file inspect.go
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// birdNETPiColumns are the columns of the detections table of BirdNET-Pi, as Detection maps them.
+var birdNETPiColumns = []string{"Date", "Time", "Sci_Name", "Com_Name", "Confidence", "Lat", "Lon", "Cutoff", "Week", "Sens", "Overlap", "File_Name"}
+
+// maxBadDates limits how many rows with unparseable dates are kept in an Inspection.
+const maxBadDates = 100
+
+// Inspection profiles a BirdNET-Pi source before it is migrated.
+type Inspection struct {
+	Columns        []Column `json:"columns"`                   // Columns of the detections table
+	ExtraColumns   []string `json:"extra_columns,omitempty"`   // Columns BirdNET-Pi does not have, which are not migrated
+	MissingColumns []string `json:"missing_columns,omitempty"` // BirdNET-Pi columns the table lacks
+
+	Rows      int64  `json:"rows"`       // Detections in the source
+	FirstDate string `json:"first_date"` // Earliest valid date, YYYY-MM-DD
+	LastDate  string `json:"last_date"`  // Latest valid date
+
+	BadDates        int                `json:"bad_dates"`               // Rows whose date or time cannot be parsed, which get no clip
+	BadDateRows     []BadDate          `json:"bad_date_rows,omitempty"` // Up to maxBadDates of them
+	Species         []SpeciesCount     `json:"species"`                 // Detections per species, most detected first
+	Confidence      []ConfidenceBucket `json:"confidence"`              // Detections per tenth of confidence
+	Stations        []StationLocation  `json:"stations"`                // Distinct locations, most detections first
+	ClipsChecked    bool               `json:"clips_checked"`           // The clips directory was given and checked
+	ClipsReferenced int                `json:"clips_referenced"`
+	ClipsPresent    int                `json:"clips_present"`
+	ClipsMissing    int                `json:"clips_missing"`
+	AudioByYear     []YearAudio        `json:"audio_by_year,omitempty"` // Present clips per year of detection
+
+	Resume *ResumeInfo `json:"resume,omitempty"` // Where a copy or move into the target would resume
+}
+
+// Column is a column of the source detections table.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// BadDate is a source row whose date or time cannot be parsed.
+type BadDate struct {
+	RowID int64  `json:"rowid"`
+	Date  string `json:"date"`
+	Time  string `json:"time"`
+}
+
+// ConfidenceBucket counts the detections with a confidence from Min up to Max.
+type ConfidenceBucket struct {
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	Detections int64   `json:"detections"`
+}
+
+// StationLocation is a latitude and longitude detections were recorded at.
+type StationLocation struct {
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Detections int64   `json:"detections"`
+	FirstDate  string  `json:"first_date"`
+	LastDate   string  `json:"last_date"`
+}
+
+// YearAudio is the number and size of the present clips of one year.
+type YearAudio struct {
+	Year  string `json:"year"`
+	Clips int    `json:"clips"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ResumeInfo is where a copy or move of the source into a target database would resume.
+type ResumeInfo struct {
+	TargetNotes int64  `json:"target_notes"` // Notes already in the target
+	From        string `json:"from"`         // "checkpoint", "snapshot", "last note" or "start"
+	RowID       int64  `json:"rowid,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Time        string `json:"time,omitempty"`
+	Pending     int    `json:"pending"` // Source records the run would migrate
+}
+
+// Inspect profiles the source of opts without writing to it: the schema, dates, species,
+// confidence and locations of its detections, whether their clips are present if
+// opts.SourceFilesDir is given, and where a copy or move would resume if opts.TargetDBPath is
+// given. Remote, archive and text log sources are prepared as for a Run.
+func Inspect(ctx context.Context, opts Options) (*Inspection, error) {
+	if opts.Source == "" && opts.SourceDBPath == "" && opts.SourceTextPath == "" {
+		return nil, errors.New("a source database, text log or remote source is required")
+	}
+
+	m := &migration{ctx: ctx, opts: opts}
+	cleanup, err := m.prepareSource()
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	sourceDB, err := openLiveSourceDB(m.opts.SourceDBPath, logger.Default.LogMode(logger.Silent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer closeDB(sourceDB)
+	if !sourceDB.Migrator().HasTable(&Detection{}) {
+		return nil, errors.New("source database has no detections table")
+	}
+
+	inspection := &Inspection{}
+	if err := inspectSchema(sourceDB, inspection); err != nil {
+		return nil, err
+	}
+	if err := inspectAggregates(sourceDB, inspection); err != nil {
+		return nil, err
+	}
+	if err := m.inspectRows(sourceDB, inspection); err != nil {
+		return nil, err
+	}
+
+	if m.opts.TargetDBPath != "" {
+		if inspection.Resume, err = m.inspectResume(sourceDB); err != nil {
+			return nil, err
+		}
+	}
+
+	return inspection, nil
+}
+
+// inspectSchema lists the columns of the detections table against those of BirdNET-Pi.
+func inspectSchema(sourceDB *gorm.DB, inspection *Inspection) error {
+	var columns []struct {
+		Name string
+		Type string
+	}
+	if err := sourceDB.Raw("SELECT name, type FROM pragma_table_info('detections')").Scan(&columns).Error; err != nil {
+		return fmt.Errorf("failed to read detections schema: %w", err)
+	}
+
+	present := make(map[string]bool)
+	for _, column := range columns {
+		inspection.Columns = append(inspection.Columns, Column{Name: column.Name, Type: column.Type})
+		present[strings.ToLower(column.Name)] = true
+	}
+
+	known := make(map[string]bool)
+	for _, name := range birdNETPiColumns {
+		known[strings.ToLower(name)] = true
+		if !present[strings.ToLower(name)] {
+			inspection.MissingColumns = append(inspection.MissingColumns, name)
+		}
+	}
+	for _, column := range columns {
+		if !known[strings.ToLower(column.Name)] {
+			inspection.ExtraColumns = append(inspection.ExtraColumns, column.Name)
+		}
+	}
+
+	return nil
+}
+
+// inspectAggregates counts the detections per species, confidence and location.
+func inspectAggregates(sourceDB *gorm.DB, inspection *Inspection) error {
+	if err := sourceDB.Model(&Detection{}).Count(&inspection.Rows).Error; err != nil {
+		return fmt.Errorf("failed to count detections: %w", err)
+	}
+
+	var species []struct {
+		SciName    string
+		ComName    string
+		Detections int
+	}
+	err := sourceDB.Raw(`SELECT Sci_Name AS sci_name, MAX(Com_Name) AS com_name, COUNT(*) AS detections
+		FROM detections GROUP BY Sci_Name ORDER BY detections DESC, Sci_Name`).Scan(&species).Error
+	if err != nil {
+		return fmt.Errorf("failed to count species: %w", err)
+	}
+	for _, s := range species {
+		inspection.Species = append(inspection.Species, SpeciesCount{ScientificName: s.SciName, CommonName: s.ComName, Notes: s.Detections})
+	}
+
+	// Confidences of 1 fall in the last tenth, and out of range ones in the nearest
+	var buckets []struct {
+		Bucket     int
+		Detections int64
+	}
+	err = sourceDB.Raw(`SELECT MAX(MIN(CAST(Confidence * 10 AS INTEGER), 9), 0) AS bucket, COUNT(*) AS detections
+		FROM detections GROUP BY bucket ORDER BY bucket`).Scan(&buckets).Error
+	if err != nil {
+		return fmt.Errorf("failed to count confidences: %w", err)
+	}
+	for _, b := range buckets {
+		inspection.Confidence = append(inspection.Confidence, ConfidenceBucket{
+			Min: float64(b.Bucket) / 10, Max: float64(b.Bucket+1) / 10, Detections: b.Detections,
+		})
+	}
+
+	var stations []struct {
+		Lat        float64
+		Lon        float64
+		Detections int64
+		FirstDate  string
+		LastDate   string
+	}
+	// Dates SQLite cannot parse are left out of the range of each location
+	err = sourceDB.Raw(`SELECT Lat AS lat, Lon AS lon, COUNT(*) AS detections,
+		MIN(CASE WHEN date(CAST(Date AS TEXT)) IS NOT NULL THEN CAST(Date AS TEXT) END) AS first_date,
+		MAX(CASE WHEN date(CAST(Date AS TEXT)) IS NOT NULL THEN CAST(Date AS TEXT) END) AS last_date
+		FROM detections GROUP BY Lat, Lon ORDER BY detections DESC`).Scan(&stations).Error
+	if err != nil {
+		return fmt.Errorf("failed to read station locations: %w", err)
+	}
+	for _, s := range stations {
+		inspection.Stations = append(inspection.Stations, StationLocation{
+			Latitude: s.Lat, Longitude: s.Lon, Detections: s.Detections,
+			FirstDate: normalizeDate(s.FirstDate), LastDate: normalizeDate(s.LastDate),
+		})
+	}
+
+	return nil
+}
+
+// inspectRows reads every detection for its date and, with a clips directory, its clip.
+func (m *migration) inspectRows(sourceDB *gorm.DB, inspection *Inspection) error {
+	const batchSize = 1000
+
+	inspection.ClipsChecked = m.opts.SourceFilesDir != ""
+	years := make(map[string]*YearAudio)
+
+	for offset := 0; ; offset += batchSize {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := fetchBatch(sourceDB, offset, batchSize, "", nil)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			detection := batch[i].Detection
+			if batch[i].RawDate != "" {
+				detection.Date = batch[i].RawDate
+			}
+			detection.Date = normalizeDate(detection.Date)
+
+			// The clip path needs both the date and time, like the transfer
+			if _, err := time.Parse("2006-01-02T15:04:05", detection.Date+"T"+detection.Time); err != nil {
+				inspection.BadDates++
+				if len(inspection.BadDateRows) < maxBadDates {
+					inspection.BadDateRows = append(inspection.BadDateRows, BadDate{RowID: batch[i].RowID, Date: detection.Date, Time: detection.Time})
+				}
+				continue
+			}
+			if inspection.FirstDate == "" || detection.Date < inspection.FirstDate {
+				inspection.FirstDate = detection.Date
+			}
+			if detection.Date > inspection.LastDate {
+				inspection.LastDate = detection.Date
+			}
+
+			if !inspection.ClipsChecked || detection.FileName == "" {
+				continue
+			}
+			inspection.ClipsReferenced++
+
+			var size int64 = -1
+			for _, candidate := range sourceClipPaths(&detection, m.opts.SourceFilesDir) {
+				if info, err := m.fileSystem().Stat(candidate); err == nil {
+					size = info.Size()
+					break
+				}
+			}
+			if size < 0 {
+				inspection.ClipsMissing++
+				continue
+			}
+			inspection.ClipsPresent++
+
+			year := detection.Date[:4]
+			if years[year] == nil {
+				years[year] = &YearAudio{Year: year}
+			}
+			years[year].Clips++
+			years[year].Bytes += size
+		}
+	}
+
+	for _, year := range years {
+		inspection.AudioByYear = append(inspection.AudioByYear, *year)
+	}
+	sort.Slice(inspection.AudioByYear, func(i, j int) bool {
+		return inspection.AudioByYear[i].Year < inspection.AudioByYear[j].Year
+	})
+
+	return nil
+}
+
+// inspectResume works out where a copy or move into the target database would resume.
+func (m *migration) inspectResume(sourceDB *gorm.DB) (*ResumeInfo, error) {
+	resume, err := m.readResumePoint()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ResumeInfo{TargetNotes: resume.notes, From: "start"}
+	switch {
+	case resume.checkpoint != nil:
+		info.From = "checkpoint"
+		info.RowID, info.Date, info.Time = resume.checkpoint.LastRowID, normalizeDate(resume.checkpoint.LastDate), resume.checkpoint.LastTime
+	case resume.state != nil:
+		info.From = "snapshot"
+		info.RowID = resume.state.LastRowID
+	case resume.lastNote != nil:
+		info.From = "last note"
+		info.Date, info.Time = resume.lastNote.Date, resume.lastNote.Time
+	}
+
+	whereClause, params := resume.selection()
+	if info.Pending, err = getTotalRecordCount(sourceDB, whereClause, params...); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// normalizeDate formats an RFC3339 date as YYYY-MM-DD, as convertDetectionToNote does, and
+// returns any other date unchanged.
+func normalizeDate(date string) string {
+	if parsed, err := time.Parse(time.RFC3339, date); err == nil {
+		return parsed.Format("2006-01-02")
+	}
+	return date
+}
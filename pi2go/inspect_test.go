@@ -0,0 +1,97 @@
+package pi2go
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := []Detection{
+		{Date: "2022-12-31", Time: "23:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.95, Lat: 60.1, Lon: 24.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 1, Lat: 60.1, Lon: 24.9, FileName: "b.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.72, Lat: 61.5, Lon: 23.8, FileName: "c.mp3"},
+		{Date: "15/01/2023", Time: "12:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.8, Lat: 60.1, Lon: 24.9, FileName: "d.mp3"},
+	}
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, detections)
+	if err := sourceDB.Exec("ALTER TABLE detections ADD COLUMN Station TEXT").Error; err != nil {
+		t.Fatalf("Failed to add column: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	for _, i := range []int{0, 2} {
+		path := sourceClipPaths(&detections[i], sourceFilesDir)[0]
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte("audio"), 0o644)
+	}
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+
+	inspection, err := Inspect(context.Background(), Options{SourceDBPath: sourceDBPath, SourceFilesDir: sourceFilesDir, TargetDBPath: targetDBPath})
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(inspection.ExtraColumns, []string{"Station"}) || len(inspection.MissingColumns) != 0 {
+		t.Errorf("Inspect() columns extra = %v, missing = %v, want extra Station", inspection.ExtraColumns, inspection.MissingColumns)
+	}
+	if inspection.Rows != 4 || inspection.FirstDate != "2022-12-31" || inspection.LastDate != "2023-01-15" {
+		t.Errorf("Inspect() rows = %d from %s to %s, want 4 from 2022-12-31 to 2023-01-15", inspection.Rows, inspection.FirstDate, inspection.LastDate)
+	}
+	if inspection.BadDates != 1 || len(inspection.BadDateRows) != 1 || inspection.BadDateRows[0].RowID != 4 {
+		t.Errorf("Inspect() bad dates = %d %+v, want rowid 4", inspection.BadDates, inspection.BadDateRows)
+	}
+	if len(inspection.Species) != 3 || inspection.Species[0].ScientificName != "Corvus corax" || inspection.Species[0].Notes != 2 {
+		t.Errorf("Inspect() species = %+v, want Corvus corax first with 2", inspection.Species)
+	}
+
+	wantConfidence := []ConfidenceBucket{{Min: 0.7, Max: 0.8, Detections: 1}, {Min: 0.8, Max: 0.9, Detections: 1}, {Min: 0.9, Max: 1, Detections: 2}}
+	if !reflect.DeepEqual(inspection.Confidence, wantConfidence) {
+		t.Errorf("Inspect() confidence = %+v, want %+v", inspection.Confidence, wantConfidence)
+	}
+	if len(inspection.Stations) != 2 || inspection.Stations[0].Detections != 3 || inspection.Stations[0].Latitude != 60.1 {
+		t.Errorf("Inspect() stations = %+v, want 60.1 first with 3 detections", inspection.Stations)
+	}
+
+	// The row with a bad date gets no clip, so only the other three are referenced
+	if !inspection.ClipsChecked || inspection.ClipsReferenced != 3 || inspection.ClipsPresent != 2 || inspection.ClipsMissing != 1 {
+		t.Errorf("Inspect() clips = %d referenced, %d present, %d missing, want 3, 2, 1",
+			inspection.ClipsReferenced, inspection.ClipsPresent, inspection.ClipsMissing)
+	}
+	wantAudio := []YearAudio{{Year: "2022", Clips: 1, Bytes: 5}, {Year: "2023", Clips: 1, Bytes: 5}}
+	if !reflect.DeepEqual(inspection.AudioByYear, wantAudio) {
+		t.Errorf("Inspect() audio by year = %+v, want %+v", inspection.AudioByYear, wantAudio)
+	}
+
+	if r := inspection.Resume; r == nil || r.From != "start" || r.Pending != 4 || r.TargetNotes != 0 {
+		t.Errorf("Inspect() resume = %+v, want all 4 from the start", r)
+	}
+	if _, err := os.Stat(targetDBPath); !os.IsNotExist(err) {
+		t.Error("Inspect() created the target database")
+	}
+
+	// After migrating the first three, a copy resumes after the latest note
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true})
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-02-01", Time: "08:00:00", SciName: "Parus major", ComName: "Great Tit", FileName: "e.mp3"})
+
+	inspection, err = Inspect(context.Background(), Options{SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath})
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if inspection.ClipsChecked || inspection.ClipsReferenced != 0 {
+		t.Errorf("Inspect() without a clips directory checked %d clips", inspection.ClipsReferenced)
+	}
+	if r := inspection.Resume; r == nil || r.From != "last note" || r.Date != "2023-01-15" || r.Pending != 1 {
+		t.Errorf("Inspect() resume = %+v, want 1 pending after the last note of 2023-01-15", r)
+	}
+
+	if _, err := Inspect(context.Background(), Options{}); err == nil {
+		t.Error("Inspect() without a source succeeded")
+	}
+}
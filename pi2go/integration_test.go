@@ -1,4 +1,4 @@
-package main
+package pi2go
 
 import (
 	"bytes"
@@ -213,7 +213,7 @@ func verifyFileOperation(t *testing.T, operation FileOperationType, sourceFilePa
 // Create a mock implementation of convertAndTransferData that uses the mock filesystem
 func convertAndTransferDataWithMockFS(sourceDBPath, targetDBPath, sourceFilesDir, targetFilesDir string, operation FileOperationType, skipAudioTransfer bool, mockFS FileSystem) {
 	// This is a modified version of convertAndTransferData that uses a mock filesystem
-	newLogger := createGormLogger()
+	newLogger := createGormLogger(log.Default())
 
 	// Check if source database file exists
 	if _, err := os.Stat(sourceDBPath); os.IsNotExist(err) {
@@ -234,7 +234,10 @@ func convertAndTransferDataWithMockFS(sourceDBPath, targetDBPath, sourceFilesDir
 		return
 	}
 
-	targetDB := initializeAndMigrateTargetDB(targetDBPath, newLogger)
+	targetDB, err := initializeAndMigrateTargetDB(targetDBPath, newLogger)
+	if err != nil {
+		log.Fatalf("target db open: %v", err)
+	}
 
 	lastNote, err := findLastEntryInTargetDB(targetDB)
 	if err != nil {
@@ -242,7 +245,10 @@ func convertAndTransferDataWithMockFS(sourceDBPath, targetDBPath, sourceFilesDir
 	}
 
 	whereClause, params := formulateQuery(lastNote)
-	totalCount := getTotalRecordCount(sourceDB, whereClause, params...)
+	totalCount, err := getTotalRecordCount(sourceDB, whereClause, params...)
+	if err != nil {
+		log.Fatalf("Error counting source records: %v", err)
+	}
 	fmt.Println("Total records to process:", totalCount)
 
 	// Process records with the mock filesystem for file operations
@@ -255,18 +261,21 @@ func processRecordsWithMockFS(sourceDB, targetDB *gorm.DB, totalCount int, sourc
 	const batchSize = 1000 // Define the size of each batch
 
 	for offset := 0; offset < totalCount; offset += batchSize {
-		batchDetections := fetchBatch(sourceDB, offset, batchSize, whereClause, params)
+		batchDetections, err := fetchBatch(sourceDB, offset, batchSize, whereClause, params)
+		if err != nil {
+			log.Fatalf("Error fetching batch: %v", err)
+		}
 		fmt.Printf("Processing batch %d-%d of %d\n", offset+1, offset+len(batchDetections), totalCount)
 
 		for i := range batchDetections {
 			// Process each detection with the mock filesystem
-			note := convertDetectionToNote(&batchDetections[i])
+			note := convertDetectionToNote(&batchDetections[i].Detection)
 			if err := targetDB.Create(&note).Error; err != nil {
 				log.Printf("Error inserting note: %v", err)
 			}
 
 			if !skipAudioTransfer {
-				handleFileTransferWithFS(&batchDetections[i], sourceFilesDir, targetFilesDir, operation, mockFS)
+				handleFileTransferWithFS(&batchDetections[i].Detection, sourceFilesDir, targetFilesDir, operation, mockFS)
 			}
 		}
 	}
@@ -0,0 +1,127 @@
+// file locale.go
+package pi2go
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// bundledLabels holds common names of frequently recorded species in some locales, named by
+// locale code, in the format of BirdNET label files.
+//
+//go:embed labels/*.txt
+var bundledLabels embed.FS
+
+// loadLocale returns the common names by scientific name of locale: a BirdNET label file at
+// that path, or the bundled labels of that locale code. It returns nil when locale is empty.
+func loadLocale(locale string) (map[string]string, error) {
+	if locale == "" {
+		return nil, nil
+	}
+
+	if info, err := os.Stat(locale); err == nil && !info.IsDir() {
+		f, err := os.Open(locale)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open labels: %w", err)
+		}
+		defer f.Close()
+		return parseLabels(f)
+	}
+
+	f, err := bundledLabels.Open(path.Join("labels", strings.ToLower(locale)+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown locale %q, give a BirdNET label file or one of %s", locale, strings.Join(bundledLocales(), ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseLabels(f)
+}
+
+// bundledLocales returns the codes of the bundled locales.
+func bundledLocales() []string {
+	names, _ := fs.Glob(bundledLabels, "labels/*.txt")
+	locales := make([]string, len(names))
+	for i, name := range names {
+		locales[i] = strings.TrimSuffix(path.Base(name), ".txt")
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// parseLabels reads the "Scientific name_Common name" lines of a BirdNET label file. Empty
+// lines and lines starting with # are skipped.
+func parseLabels(r io.Reader) (map[string]string, error) {
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		scientificName, commonName, ok := strings.Cut(text, "_")
+		if !ok || strings.TrimSpace(scientificName) == "" || strings.TrimSpace(commonName) == "" {
+			return nil, fmt.Errorf("labels line %d: expected Scientific name_Common name", line)
+		}
+		names[strings.TrimSpace(scientificName)] = strings.TrimSpace(commonName)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels: %w", err)
+	}
+	return names, nil
+}
+
+// translate replaces the common name of note with that of its scientific name in the locale of
+// the run. A species the labels have no entry for keeps the name recorded; its notes are counted
+// and it is logged the first time. Notes with their name changed are counted.
+func (m *migration) translate(note *Note) {
+	if m == nil || m.commonNames == nil {
+		return
+	}
+	commonName, ok := m.commonNames[note.ScientificName]
+	if ok && commonName == note.CommonName {
+		return
+	}
+	if ok {
+		note.CommonName = commonName
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ok {
+		m.result.NotesTranslated++
+		return
+	}
+	if m.untranslated == nil {
+		m.untranslated = make(map[string]bool)
+	}
+	if !m.untranslated[note.ScientificName] {
+		m.untranslated[note.ScientificName] = true
+		m.logger().Printf("No common name in the labels for %s, keeping %q", note.ScientificName, note.CommonName)
+	}
+	m.result.NotesUntranslated++
+}
+
+// sortedUntranslated returns the scientific names the labels had no common name for, sorted.
+// m.mu must be held.
+func (m *migration) sortedUntranslated() []string {
+	if len(m.untranslated) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(m.untranslated))
+	for name := range m.untranslated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
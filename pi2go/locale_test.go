@@ -0,0 +1,120 @@
+package pi2go
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLocale(t *testing.T) {
+	t.Parallel()
+
+	labelsPath := filepath.Join(t.TempDir(), "BirdNET_GLOBAL_6K_V2.4_Labels_nl.txt")
+	if err := os.WriteFile(labelsPath, []byte("\ufeffParus major_Koolmees\r\nDog_Dog\n\nTurdus merula_Merel\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write labels: %v", err)
+	}
+	invalidPath := filepath.Join(t.TempDir(), "invalid.txt")
+	if err := os.WriteFile(invalidPath, []byte("Parus major_Koolmees\nTurdus merula\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write labels: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		locale  string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "Off"},
+		{name: "Bundled", locale: "fi", want: map[string]string{"Parus major": "Talitiainen", "Turdus merula": "Mustarastas"}},
+		{name: "Bundled in upper case", locale: "DE", want: map[string]string{"Parus major": "Kohlmeise"}},
+		{name: "Label file", locale: labelsPath, want: map[string]string{"Parus major": "Koolmees", "Dog": "Dog", "Turdus merula": "Merel"}},
+		{name: "Unknown locale", locale: "xx", wantErr: "de, fi, sv"},
+		{name: "Invalid label file", locale: invalidPath, wantErr: "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			names, err := loadLocale(tt.locale)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadLocale(%q) error = %v, want %q", tt.locale, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadLocale(%q) error = %v", tt.locale, err)
+			}
+			if tt.want == nil && names != nil {
+				t.Errorf("loadLocale(%q) = %d names, want none", tt.locale, len(names))
+			}
+			for scientificName, want := range tt.want {
+				if names[scientificName] != want {
+					t.Errorf("loadLocale(%q)[%q] = %q, want %q", tt.locale, scientificName, names[scientificName], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateCommonNames(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Parus major", ComName: "Kohlmeise", Confidence: 0.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus caeruleus", ComName: "Blaumeise", Confidence: 0.8, FileName: "b.mp3"},
+		{Date: "2023-01-15", Time: "12:00:00", SciName: "Gavia arctica", ComName: "Prachttaucher", Confidence: 0.7, FileName: "c.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	var logs bytes.Buffer
+	result := runMigration(t, Options{
+		Operation:         OperationCopy,
+		SourceDBPath:      sourceDBPath,
+		TargetDBPath:      targetDBPath,
+		SkipAudioTransfer: true,
+		RemapTaxonomy:     true,
+		Locale:            "fi",
+		Logger:            log.New(&logs, "", 0),
+	})
+	if result.NotesInserted != 3 || result.NotesTranslated != 2 || result.NotesUntranslated != 1 {
+		t.Errorf("copy with locale = %+v, want 3 notes inserted, 2 translated and 1 untranslated", result.Counts)
+	}
+
+	// Species missing from the labels are listed and logged
+	if len(result.Untranslated) != 1 || result.Untranslated[0] != "Gavia arctica" {
+		t.Errorf("Untranslated = %v, want [Gavia arctica]", result.Untranslated)
+	}
+	if !strings.Contains(logs.String(), "Gavia arctica") {
+		t.Errorf("log = %q, want the untranslated Gavia arctica", logs.String())
+	}
+
+	// Translated after the remapping, untranslated names kept as recorded
+	want := map[string]string{"Parus major": "Talitiainen", "Cyanistes caeruleus": "Sinitiainen", "Gavia arctica": "Prachttaucher"}
+	for _, species := range result.Species {
+		if species.CommonName != want[species.ScientificName] {
+			t.Errorf("common name of %s = %q, want %q", species.ScientificName, species.CommonName, want[species.ScientificName])
+		}
+	}
+	if len(result.Remapped) != 1 || result.Remapped[0].CommonName != "Sinitiainen" {
+		t.Errorf("Remapped = %+v, want Parus caeruleus remapped to Sinitiainen", result.Remapped)
+	}
+
+	// A merge translates the notes of a BirdNET-Go database to another locale
+	mergedDBPath := filepath.Join(t.TempDir(), "merged.db")
+	result = runMigration(t, Options{Operation: OperationMerge, SourceDBPath: targetDBPath, TargetDBPath: mergedDBPath, Locale: "sv"})
+	if result.NotesInserted != 3 || result.NotesTranslated != 2 || result.NotesUntranslated != 1 {
+		t.Errorf("merge with locale = %+v, want 3 notes inserted, 2 translated and 1 untranslated", result.Counts)
+	}
+	want = map[string]string{"Parus major": "Talgoxe", "Cyanistes caeruleus": "Blåmes", "Gavia arctica": "Prachttaucher"}
+	for _, species := range result.Species {
+		if species.CommonName != want[species.ScientificName] {
+			t.Errorf("merged common name of %s = %q, want %q", species.ScientificName, species.CommonName, want[species.ScientificName])
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// file lock.go
+package pi2go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrTargetLocked is returned when another run holds the lock on the target.
+var ErrTargetLocked = errors.New("target is locked by another run")
+
+// ErrTargetInUse is returned when another process, such as BirdNET-Go, has the target database open.
+var ErrTargetInUse = errors.New("target database is in use by another process")
+
+const (
+	// lockSuffix is appended to the target database path to name its lock file.
+	lockSuffix = ".lock"
+
+	// clipsLockName is the lock file in the target clips directory.
+	clipsLockName = ".birdnet-pi2go.lock"
+
+	// unreadableLockAge is how old a lock file that cannot be parsed must be to be taken as
+	// stale. A younger one may still be being written by the run that created it.
+	unreadableLockAge = 10 * time.Second
+)
+
+// lockInfo is the content of a lock file, identifying the run holding it.
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	StartedAt time.Time `json:"started_at"`
+	Operation Operation `json:"operation"`
+}
+
+// targetLock is an advisory lock held on the target database and clips directory of a run.
+type targetLock struct {
+	paths  []string // Lock files created, released in reverse order
+	logger *log.Logger
+}
+
+// lockTarget takes the locks on the target database and, if the run transfers clips, on the
+// target clips directory, so two runs never write the same clips. BirdNET-Go writes to the clips
+// directory regardless of the lock.
+func (m *migration) lockTarget() (*targetLock, error) {
+	paths := []string{m.opts.TargetDBPath + lockSuffix}
+	if m.opts.Operation != OperationRollback && !m.opts.SkipAudioTransfer && m.opts.TargetFilesDir != "" {
+		if err := os.MkdirAll(m.opts.TargetFilesDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create target directory: %w", err)
+		}
+		paths = append(paths, filepath.Join(m.opts.TargetFilesDir, clipsLockName))
+	}
+
+	info := lockInfo{PID: os.Getpid(), StartedAt: m.result.StartedAt, Operation: m.opts.Operation}
+	info.Hostname, _ = os.Hostname()
+
+	lock := &targetLock{logger: m.logger()}
+	for _, path := range paths {
+		if err := acquireLock(path, info, lock.logger); err != nil {
+			lock.release()
+			return nil, err
+		}
+		lock.paths = append(lock.paths, path)
+	}
+
+	return lock, nil
+}
+
+// release removes the lock files.
+func (l *targetLock) release() {
+	for i := len(l.paths) - 1; i >= 0; i-- {
+		if err := os.Remove(l.paths[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			l.logger.Printf("Failed to remove lock file: %v", err)
+		}
+	}
+	l.paths = nil
+}
+
+// acquireLock creates the lock file at path holding info. A stale lock, left by a run that is
+// no longer running, is replaced.
+func acquireLock(path string, info lockInfo, logger *log.Logger) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, stale := readLock(path, info.Hostname)
+		if !stale {
+			return fmt.Errorf("%w: %s, held by %s; remove it if that run is no longer running", ErrTargetLocked, path, holder)
+		}
+		logger.Printf("Removing stale lock %s held by %s", path, holder)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale lock file: %w", err)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrTargetLocked, path)
+}
+
+// readLock describes the run holding the lock file at path and reports whether the lock is
+// stale. A lock held on another host is never stale, its process cannot be checked.
+func readLock(path, hostname string) (holder string, stale bool) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "a run that just finished", true
+	}
+
+	data, err := os.ReadFile(path)
+	var info lockInfo
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	if err != nil || info.PID <= 0 {
+		return "an unknown run", time.Since(stat.ModTime()) > unreadableLockAge
+	}
+
+	holder = fmt.Sprintf("%s run of process %d on %s since %s", info.Operation, info.PID, info.Hostname, info.StartedAt.Format(time.RFC3339))
+	switch {
+	case info.Hostname != hostname:
+		return holder, false
+	case !processAlive(info.PID):
+		return holder, true
+	}
+
+	// A process with the same PID started after a reboot is not the one holding the lock
+	if boot := bootTime(); !boot.IsZero() && info.StartedAt.Before(boot) {
+		return holder, true
+	}
+	return holder, false
+}
+
+// bootTime returns when the system was booted, or the zero time where that is not known.
+func bootTime() time.Time {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err == nil {
+				return time.Unix(seconds, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// checkTargetNotInUse returns ErrTargetInUse if another process has the target database open,
+// as far as the platform allows finding out, or holds a write lock on it. With allow set it
+// only logs a warning to logger.
+func checkTargetNotInUse(targetDBPath string, allow bool, logger *log.Logger) error {
+	if _, err := os.Stat(targetDBPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	var reasons []string
+	if users := openedBy(targetDBPath); len(users) > 0 {
+		reasons = append(reasons, "open in "+strings.Join(users, ", "))
+	}
+	locked, err := writeLocked(targetDBPath)
+	if err != nil {
+		return fmt.Errorf("failed to check target database: %w", err)
+	}
+	if locked {
+		reasons = append(reasons, "being written to")
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+	if allow {
+		logger.Printf("Warning: %s is %s, writing to it at the same time may corrupt it", targetDBPath, strings.Join(reasons, " and "))
+		return nil
+	}
+	return fmt.Errorf("%w: %s is %s, stop BirdNET-Go first", ErrTargetInUse, targetDBPath, strings.Join(reasons, " and "))
+}
+
+// writeLocked reports whether another connection holds a write lock on the database at path.
+func writeLocked(path string) (bool, error) {
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?_pragma=busy_timeout(0)"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return false, err
+	}
+	defer closeDB(db)
+
+	locked := false
+	err = db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("BEGIN IMMEDIATE").Error; err != nil {
+			if strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "SQLITE_BUSY") {
+				locked = true
+				return nil
+			}
+			return err
+		}
+		return conn.Exec("ROLLBACK").Error
+	})
+	return locked, err
+}
+
+// openedBy returns the other processes that have the file at path open, as "name (pid)". It
+// relies on /proc and finds nothing where that is not available or not readable.
+func openedBy(path string) []string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return nil
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	seen := make(map[string]bool)
+	var users []string
+	for _, fd := range fds {
+		pid := strings.Split(fd, "/")[2]
+		if pid == self || seen[pid] {
+			continue
+		}
+		if target, err := os.Readlink(fd); err != nil || target != absPath {
+			continue
+		}
+
+		seen[pid] = true
+		name, _ := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+		users = append(users, fmt.Sprintf("%s (%s)", strings.TrimSpace(string(name)), pid))
+	}
+	return users
+}
@@ -0,0 +1,190 @@
+package pi2go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	t.Parallel()
+
+	hostname, _ := os.Hostname()
+	self := lockInfo{PID: os.Getpid(), Hostname: hostname, StartedAt: time.Now(), Operation: OperationCopy}
+
+	// A process that has exited leaves a stale lock
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("Cannot start a process: %v", err)
+	}
+	deadPID := exited.Process.Pid
+
+	tests := []struct {
+		name     string
+		existing *lockInfo // Lock already there, nil for none
+		content  string    // Unparseable lock already there
+		age      time.Duration
+		wantErr  bool
+	}{
+		{name: "Unlocked"},
+		{name: "Held by a running process", existing: &lockInfo{PID: os.Getppid(), Hostname: hostname, StartedAt: time.Now(), Operation: OperationCopy}, wantErr: true},
+		{name: "Held by an exited process", existing: &lockInfo{PID: deadPID, Hostname: hostname, StartedAt: time.Now(), Operation: OperationCopy}},
+		{name: "Held on another host", existing: &lockInfo{PID: deadPID, Hostname: "other-" + hostname, StartedAt: time.Now(), Operation: OperationCopy}, wantErr: true},
+		{name: "Held before the last boot", existing: &lockInfo{PID: os.Getppid(), Hostname: hostname, StartedAt: time.Unix(0, 0), Operation: OperationCopy}, wantErr: bootTime().IsZero()},
+		{name: "Being written", content: "{", wantErr: true},
+		{name: "Damaged", content: "{", age: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "birdnet.db.lock")
+			if tt.existing != nil {
+				data, _ := json.Marshal(tt.existing)
+				os.WriteFile(path, data, 0o644)
+			}
+			if tt.content != "" {
+				os.WriteFile(path, []byte(tt.content), 0o644)
+				modified := time.Now().Add(-tt.age)
+				os.Chtimes(path, modified, modified)
+			}
+
+			err := acquireLock(path, self, log.Default())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("acquireLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrTargetLocked) {
+					t.Errorf("acquireLock() error = %v, want %v", err, ErrTargetLocked)
+				}
+				return
+			}
+
+			var info lockInfo
+			data, _ := os.ReadFile(path)
+			if err := json.Unmarshal(data, &info); err != nil || info.PID != self.PID {
+				t.Errorf("lock file = %s, want it held by process %d", data, self.PID)
+			}
+		})
+	}
+}
+
+func TestRunLocksTarget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, checkpointTestDetections(3))
+	tempDir := t.TempDir()
+	opts := Options{
+		Operation:      OperationCopy,
+		SourceDBPath:   sourceDBPath,
+		TargetDBPath:   filepath.Join(tempDir, "birdnet.db"),
+		SourceFilesDir: filepath.Join(tempDir, "BirdSongs"),
+		TargetFilesDir: filepath.Join(tempDir, "clips"),
+	}
+
+	// Another run holding the clips directory blocks the run before it writes anything
+	hostname, _ := os.Hostname()
+	holder, _ := json.Marshal(lockInfo{PID: os.Getppid(), Hostname: hostname, StartedAt: time.Now(), Operation: OperationCopy})
+	os.MkdirAll(opts.SourceFilesDir, 0o755)
+	os.MkdirAll(opts.TargetFilesDir, 0o755)
+	clipsLock := filepath.Join(opts.TargetFilesDir, clipsLockName)
+	os.WriteFile(clipsLock, holder, 0o644)
+
+	m, _ := New(opts)
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrTargetLocked) {
+		t.Fatalf("Run() error = %v, want %v", err, ErrTargetLocked)
+	}
+	if _, err := os.Stat(opts.TargetDBPath + lockSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("database lock left behind after failing to lock the clips directory")
+	}
+	if _, err := os.Stat(opts.TargetDBPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("target database created while locked")
+	}
+
+	// A merge or sync into the same clips directory is blocked too
+	for _, operation := range []Operation{OperationMerge, OperationSync} {
+		other := opts
+		other.Operation = operation
+		other.TargetDBPath = filepath.Join(tempDir, string(operation)+".db")
+		m, _ := New(other)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := m.Run(ctx)
+		cancel()
+		if !errors.Is(err, ErrTargetLocked) {
+			t.Errorf("%s Run() error = %v, want %v", operation, err, ErrTargetLocked)
+		}
+	}
+
+	// Once released, the run proceeds and removes its locks
+	os.Remove(clipsLock)
+	runMigration(t, opts)
+	for _, path := range []string{opts.TargetDBPath + lockSuffix, clipsLock} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("lock %s left behind", path)
+		}
+	}
+}
+
+func TestCheckTargetNotInUse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	if err := checkTargetNotInUse(targetDBPath, false, log.Default()); err != nil {
+		t.Fatalf("checkTargetNotInUse() without target error = %v", err)
+	}
+	insertTestNotes(t, targetDBPath, 1)
+	if err := checkTargetNotInUse(targetDBPath, false, log.Default()); err != nil {
+		t.Fatalf("checkTargetNotInUse() of an idle target error = %v", err)
+	}
+
+	// A connection in the middle of writing holds the write lock
+	db, err := initializeAndMigrateTargetDB(targetDBPath, createGormLogger(log.Default()))
+	if err != nil {
+		t.Fatalf("Failed to open target: %v", err)
+	}
+	defer closeDB(db)
+	tx := db.Begin()
+	if err := tx.Create(&Note{Date: "2023-01-15", Time: "10:00:00", ScientificName: "Corvus corax"}).Error; err != nil {
+		t.Fatalf("Failed to insert note: %v", err)
+	}
+
+	if err := checkTargetNotInUse(targetDBPath, false, log.Default()); !errors.Is(err, ErrTargetInUse) {
+		t.Errorf("checkTargetNotInUse() while written error = %v, want %v", err, ErrTargetInUse)
+	}
+	if err := checkTargetNotInUse(targetDBPath, true, log.Default()); err != nil {
+		t.Errorf("checkTargetNotInUse() allowing use error = %v", err)
+	}
+	tx.Rollback()
+
+	// Another process holding the database open is found where /proc is available
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("Skipping open file check without /proc")
+	}
+	holder := exec.Command("sh", "-c", "exec 3<\"$0\"; sleep 30", targetDBPath)
+	if err := holder.Start(); err != nil {
+		t.Skipf("Cannot start a process: %v", err)
+	}
+	defer func() {
+		holder.Process.Kill()
+		holder.Wait()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(openedBy(targetDBPath)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := checkTargetNotInUse(targetDBPath, false, log.Default()); !errors.Is(err, ErrTargetInUse) {
+		t.Errorf("checkTargetNotInUse() while open elsewhere error = %v, want %v", err, ErrTargetInUse)
+	}
+}
@@ -0,0 +1,298 @@
+// file manifest.go
+package pi2go
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of manifest entries.
+const (
+	manifestRun        = "run"      // Starts a run and lists the migration state it may update
+	manifestRecord     = "record"   // A source record, with the note it inserted and the clip it transferred
+	manifestRolledBack = "rollback" // Marks a run as rolled back
+)
+
+// manifestSuffix is appended to the target database path to name its manifest by default.
+const manifestSuffix = ".manifest.jsonl"
+
+// ManifestEntry is one line of a transfer manifest, which records every note inserted and clip
+// transferred into a target so that a run can be rolled back.
+type ManifestEntry struct {
+	Kind      string           `json:"kind"`
+	Run       string           `json:"run"`
+	Time      time.Time        `json:"time"`
+	Operation Operation        `json:"operation"`
+	StateKeys []string         `json:"state_keys,omitempty"` // Migration state the run may update
+	State     []MigrationState `json:"state,omitempty"`      // That migration state before the run
+	Note      *ManifestNote    `json:"note,omitempty"`
+	Clip      *ManifestClip    `json:"clip,omitempty"`
+}
+
+// ManifestNote identifies a note inserted into the target database.
+type ManifestNote struct {
+	ID             uint   `json:"id"`
+	Date           string `json:"date"`
+	Time           string `json:"time"`
+	ScientificName string `json:"scientific_name"`
+}
+
+// ManifestClip describes a transferred clip.
+type ManifestClip struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Remote string `json:"remote,omitempty"` // Remote BirdNET-Pi the source path is on
+	Moved  bool   `json:"moved,omitempty"`  // Moved rather than copied, as by a merge that moves clips
+	Kept   bool   `json:"kept,omitempty"`   // Left in its source by a move that could not remove it
+}
+
+// moved reports whether the clip was moved away from its source by a run of operation.
+func (c *ManifestClip) moved(operation Operation) bool {
+	return (operation == OperationMove || c.Moved) && !c.Kept
+}
+
+// manifestWriter appends entries of one run to a manifest file. Each entry is written with a
+// single write, so it reaches the file even if the process is killed right after.
+type manifestWriter struct {
+	mu        sync.Mutex
+	f         *os.File
+	run       string
+	operation Operation
+	remote    string
+	failed    bool // A write failed and was logged
+	logger    *log.Logger
+}
+
+// openManifest opens the manifest at path for appending entries of run, logging failures to logger.
+func openManifest(path, run string, operation Operation, remote string, logger *log.Logger) (*manifestWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+
+	return &manifestWriter{f: f, run: run, operation: operation, remote: remote, logger: logger}, nil
+}
+
+// append writes an entry for the run. A failure is logged once, the run itself continues.
+func (w *manifestWriter) append(entry ManifestEntry) {
+	if w == nil {
+		return
+	}
+
+	entry.Run, entry.Time = w.run, time.Now().UTC()
+	if entry.Operation == "" {
+		entry.Operation = w.operation
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		w.mu.Lock()
+		_, err = w.f.Write(append(data, '\n'))
+		w.mu.Unlock()
+	}
+
+	if err != nil && !w.failed {
+		w.failed = true
+		w.logger.Printf("Failed to write manifest %s, rollback will be incomplete: %v", w.f.Name(), err)
+	}
+}
+
+// record appends the note inserted for a source record and the clip transferred for it, either
+// of which may be missing. A transfer without a target path is one that did not happen.
+func (w *manifestWriter) record(note *Note, transfer clipTransfer) {
+	if note == nil && transfer.Target == "" {
+		return
+	}
+
+	entry := ManifestEntry{Kind: manifestRecord}
+	if note != nil {
+		entry.Note = &ManifestNote{ID: note.ID, Date: note.Date, Time: note.Time, ScientificName: note.ScientificName}
+	}
+	if transfer.Target != "" {
+		entry.Clip = &ManifestClip{
+			Source: transfer.Source, Target: transfer.Target, Size: transfer.Size, SHA256: transfer.SHA256,
+			Remote: w.remote, Moved: transfer.Moved, Kept: transfer.RemoveErr != nil,
+		}
+	}
+	w.append(entry)
+}
+
+// sync flushes the manifest to disk, before the notes it lists are committed.
+func (w *manifestWriter) sync() {
+	if w == nil {
+		return
+	}
+
+	if err := w.f.Sync(); err != nil {
+		w.logger.Printf("Failed to sync manifest: %v", err)
+	}
+}
+
+// close closes the manifest file.
+func (w *manifestWriter) close() {
+	if w == nil {
+		return
+	}
+
+	if err := w.f.Close(); err != nil {
+		w.logger.Printf("Failed to close manifest: %v", err)
+	}
+}
+
+// runID identifies a run started at startedAt in the manifest.
+func runID(startedAt time.Time) string {
+	return startedAt.UTC().Format("20060102T150405.000000Z")
+}
+
+// openManifest starts the run in the manifest, recording the migration state it may update as
+// it was before the run, so a rollback can put it back.
+func (m *migration) openManifest() error {
+	keys := m.stateKeys()
+
+	targetDB, err := m.openTargetDB()
+	if err != nil {
+		return err
+	}
+	defer closeDB(targetDB)
+
+	var before []MigrationState
+	for _, key := range keys {
+		state, err := loadMigrationState(targetDB, key)
+		if err != nil {
+			return fmt.Errorf("error loading migration state: %w", err)
+		}
+		if state != nil {
+			before = append(before, *state)
+		}
+	}
+
+	// Clips of a remote source can not be moved back by a rollback, note where they came from
+	remote := ""
+	if m.opts.Source != "" {
+		remote = redactURL(m.opts.Source)
+	}
+
+	m.manifest, err = openManifest(m.opts.ManifestPath, m.result.RunID, m.opts.Operation, remote, m.logger())
+	if err != nil {
+		return err
+	}
+	m.manifest.append(ManifestEntry{Kind: manifestRun, StateKeys: keys, State: before})
+	m.manifest.sync()
+	return nil
+}
+
+// stateKeys returns the keys of the migration state the operation may update in the target.
+func (m *migration) stateKeys() []string {
+	sourceKey := migrationStateKey(m.opts.SourceDBPath)
+	switch m.opts.Operation {
+	case OperationMerge:
+		keys := make([]string, 0, 1+len(m.opts.Sources))
+		for _, source := range m.mergeSourceList() {
+			keys = append(keys, mergeCheckpointPrefix+migrationStateKey(source.DBPath))
+		}
+		return keys
+	case OperationSync:
+		return []string{syncStatePrefix + sourceKey}
+	}
+
+	keys := []string{sourceKey}
+	if m.sourceKey != "" {
+		keys = append(keys, checkpointPrefix+m.sourceKey)
+	}
+	return keys
+}
+
+// recordManifest appends the note inserted for a source record and its clip transfer to the
+// manifest of the run.
+func (m *migration) recordManifest(note *Note, transfer clipTransfer) {
+	if m == nil {
+		return
+	}
+	m.manifest.record(note, transfer)
+}
+
+// syncManifest flushes the manifest of the run to disk, before committing the notes it lists.
+func (m *migration) syncManifest() {
+	if m == nil {
+		return
+	}
+	m.manifest.sync()
+}
+
+// readManifest reads all entries of a manifest. A truncated last line, left by a process
+// killed while writing it, is ignored.
+func readManifest(path string) ([]ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	var pending error
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if pending != nil {
+			return nil, pending
+		}
+
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			pending = fmt.Errorf("invalid manifest entry on line %d: %w", line, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return entries, nil
+}
+
+// errNothingToRollBack is returned when the manifest holds no run that has not been rolled back.
+var errNothingToRollBack = errors.New("no run left to roll back in the manifest")
+
+// runToRollBack returns the header and records of the run to roll back, which is the most recent
+// run that has not been rolled back yet. If run is given, it must be that run, as rolling back
+// an earlier run would leave later runs resuming from state that no longer exists.
+func runToRollBack(entries []ManifestEntry, run string) (header ManifestEntry, records []ManifestEntry, err error) {
+	rolledBack := make(map[string]bool)
+	for i := range entries {
+		if entries[i].Kind == manifestRolledBack {
+			rolledBack[entries[i].Run] = true
+		}
+	}
+
+	latest := ""
+	for i := range entries {
+		if entries[i].Kind == manifestRun && !rolledBack[entries[i].Run] {
+			latest, header = entries[i].Run, entries[i]
+		}
+	}
+
+	switch {
+	case run != "" && rolledBack[run]:
+		return header, nil, fmt.Errorf("run %s has already been rolled back", run)
+	case latest == "":
+		return header, nil, errNothingToRollBack
+	case run != "" && run != latest:
+		return header, nil, fmt.Errorf("run %s is not the most recent run, roll back %s first", run, latest)
+	}
+
+	for i := range entries {
+		if entries[i].Kind == manifestRecord && entries[i].Run == latest {
+			records = append(records, entries[i])
+		}
+	}
+
+	return header, records, nil
+}
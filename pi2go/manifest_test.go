@@ -0,0 +1,115 @@
+package pi2go
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestWriteAndRead(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "birdnet.db.manifest.jsonl")
+	w, err := openManifest(path, "run1", OperationMove, "", log.Default())
+	if err != nil {
+		t.Fatalf("openManifest() error = %v", err)
+	}
+	w.append(ManifestEntry{Kind: manifestRun, StateKeys: []string{"/data/birds.db"}})
+	w.record(&Note{ID: 7, Date: "2023-01-15", Time: "10:00:00", ScientificName: "Corvus corax"},
+		clipTransfer{Source: "raven.mp3", Target: "clips/raven.wav", Size: 11, SHA256: "abc"})
+	w.record(&Note{ID: 8, Date: "2023-01-15", Time: "11:00:00", ScientificName: "Parus major"}, clipTransfer{})
+	w.record(nil, clipTransfer{})
+	w.close()
+
+	// A line cut short by a crash is ignored
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open manifest: %v", err)
+	}
+	f.WriteString(`{"kind":"record","run":"run1","no`)
+	f.Close()
+
+	entries, err := readManifest(path)
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("read %d entries, want 3", len(entries))
+	}
+	if entries[0].Kind != manifestRun || entries[0].Run != "run1" || entries[0].Operation != OperationMove {
+		t.Errorf("header = %+v, want the run1 move header", entries[0])
+	}
+	if clip := entries[1].Clip; clip == nil || clip.Target != "clips/raven.wav" || clip.Size != 11 || entries[1].Note.ID != 7 {
+		t.Errorf("entries[1] = %+v, want note 7 with its clip", entries[1])
+	}
+	if entries[2].Clip != nil || entries[2].Note == nil {
+		t.Errorf("entries[2] = %+v, want a note without a clip", entries[2])
+	}
+
+	// A damaged line followed by more entries is an error
+	f, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString("\n{}\n")
+	f.Close()
+	if _, err := readManifest(path); err == nil {
+		t.Error("readManifest() succeeded with a damaged line in the middle")
+	}
+}
+
+func TestRunToRollBack(t *testing.T) {
+	t.Parallel()
+
+	entries := []ManifestEntry{
+		{Kind: manifestRun, Run: "run1", Operation: OperationCopy},
+		{Kind: manifestRecord, Run: "run1", Note: &ManifestNote{ID: 1}},
+		{Kind: manifestRun, Run: "run2", Operation: OperationMove},
+		{Kind: manifestRecord, Run: "run2", Note: &ManifestNote{ID: 2}},
+		{Kind: manifestRecord, Run: "run2", Note: &ManifestNote{ID: 3}},
+		{Kind: manifestRun, Run: "run3", Operation: OperationCopy},
+		{Kind: manifestRecord, Run: "run3", Note: &ManifestNote{ID: 4}},
+		{Kind: manifestRolledBack, Run: "run3", Operation: OperationRollback},
+	}
+
+	tests := []struct {
+		name        string
+		entries     []ManifestEntry
+		run         string
+		wantRun     string
+		wantRecords int
+		wantErr     bool
+	}{
+		{name: "Most recent run not rolled back", entries: entries, wantRun: "run2", wantRecords: 2},
+		{name: "Given most recent run", entries: entries, run: "run2", wantRun: "run2", wantRecords: 2},
+		{name: "Earlier run", entries: entries, run: "run1", wantErr: true},
+		{name: "Rolled back run", entries: entries, run: "run3", wantErr: true},
+		{name: "All rolled back", entries: append(entries[:2:2], ManifestEntry{Kind: manifestRolledBack, Run: "run1"}), wantErr: true},
+		{name: "Empty manifest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			header, records, err := runToRollBack(tt.entries, tt.run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runToRollBack() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if header.Run != tt.wantRun || len(records) != tt.wantRecords {
+				t.Errorf("runToRollBack() = %s with %d records, want %s with %d", header.Run, len(records), tt.wantRun, tt.wantRecords)
+			}
+		})
+	}
+}
+
+func TestRunID(t *testing.T) {
+	t.Parallel()
+
+	started := time.Date(2023, 1, 15, 12, 30, 45, 123456789, time.FixedZone("EET", 2*3600))
+	if got, want := runID(started), "20230115T103045.123456Z"; got != want {
+		t.Errorf("runID() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+// file merge_clips.go
+package pi2go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// mergesClips reports whether a merge transfers the clips of the notes it merges.
+func (m *migration) mergesClips() bool {
+	return m.mergesClipsOf(m.opts.SourceFilesDir)
+}
+
+// mergesClipsOf reports whether a merge transfers clips from sourceFilesDir.
+func (m *migration) mergesClipsOf(sourceFilesDir string) bool {
+	return m.opts.Operation == OperationMerge && !m.opts.SkipAudioTransfer &&
+		sourceFilesDir != "" && m.opts.TargetFilesDir != ""
+}
+
+// mergeNote inserts note into tx with its metadata and the overrides that apply to it, and, if
+// the merge transfers clips, transfers its clip from the first of candidates that exists. The
+// clip keeps the name in note.ClipName unless another clip has it in the target, then it is
+// numbered and note.ClipName rewritten. A note whose clip is missing is merged without it, or
+// left out with Options.SkipMissingClips.
+func (m *migration) mergeNote(tx *gorm.DB, note *Note, metadata NoteMetadata, candidates []string) {
+	clip := &Detection{Date: note.Date, Time: note.Time, SciName: note.ScientificName, ComName: note.CommonName, FileName: note.ClipName}
+
+	var source string
+	if m.mergesClips() && note.ClipName != "" && len(candidates) > 0 {
+		var err error
+		source, err = m.claimClipName(note, candidates)
+		if errors.Is(err, errSourceFileNotFound) {
+			if m.opts.SkipMissingClips {
+				m.recordSkipped()
+				return
+			}
+			m.recordClip(clip, clipTransfer{}, err)
+			note.ClipName = ""
+		}
+	}
+
+	m.applyOverrides(note, metadata.Station)
+	err := tx.Create(note).Error
+	m.recordNote(note.ScientificName, note.CommonName, err)
+	if err != nil {
+		m.logger().Printf("Error inserting note: %v", err)
+		return
+	}
+	if err := saveMetadata(tx, note, metadata); err != nil {
+		m.logger().Printf("Error saving metadata of note %d: %v", note.ID, err)
+	}
+
+	var transfer clipTransfer
+	if source != "" {
+		transfer, err = transferFileWithFS(source, filepath.Join(m.opts.TargetFilesDir, note.ClipName), m.fileOperation(), m.fileSystem())
+		m.recordClip(clip, transfer, err)
+	}
+	m.recordManifest(note, transfer)
+}
+
+// claimClipName finds the source clip of note among candidates and picks its name in the target
+// clips directory, returning the source to transfer. A target clip with the same content as the
+// source is taken to be the clip merged before, and nothing is transferred.
+func (m *migration) claimClipName(note *Note, candidates []string) (string, error) {
+	fs := m.fileSystem()
+	source, err := findSourceClip(candidates, fs)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.clipNames == nil {
+		m.clipNames = make(map[string]bool)
+	}
+
+	name := note.ClipName
+	for i := 1; ; i++ {
+		target := filepath.Join(m.opts.TargetFilesDir, name)
+		if !m.clipNames[name] {
+			if !fs.FileExists(target) {
+				m.clipNames[name] = true
+				note.ClipName = name
+				return source, nil
+			}
+			if sameContent(fs, source, target) {
+				note.ClipName = name
+				return "", nil
+			}
+		}
+		name = numberedClipName(note.ClipName, i)
+	}
+}
+
+// numberedClipName returns name with _<i> added before its extension.
+func numberedClipName(name string, i int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), i, ext)
+}
+
+// sameContent reports whether the files at a and b on fs have the same content.
+func sameContent(fs FileSystem, a, b string) bool {
+	if a == b {
+		return true
+	}
+	infoA, errA := fs.Stat(a)
+	infoB, errB := fs.Stat(b)
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+
+	dataA, errA := fs.ReadFile(a)
+	dataB, errB := fs.ReadFile(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// recordSkipped counts a source record left out of a merge because its clip is missing.
+func (m *migration) recordSkipped() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.Processed++
+	m.result.NotesSkipped++
+}
+
+// planMergeMove adds the source clips a merge that moves clips takes away to plan.
+func (m *migration) planMergeMove(plan *Plan) error {
+	if m.opts.Operation != OperationMerge || !m.opts.MoveClips {
+		return nil
+	}
+
+	for _, source := range m.mergeSourceList() {
+		if !m.mergesClipsOf(source.FilesDir) {
+			continue
+		}
+		if err := planSourceMove(plan, source.DBPath, source.FilesDir, m.fileSystem()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planSourceMove adds the clips of the database at sourceDBPath found in sourceFilesDir to plan.
+func planSourceMove(plan *Plan, sourceDBPath, sourceFilesDir string, fs FileSystem) error {
+	sourceDB, err := openLiveSourceDB(sourceDBPath, logger.Default.LogMode(logger.Silent))
+	if err != nil {
+		return fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer closeDB(sourceDB)
+
+	count := func(candidates []string) {
+		for _, candidate := range candidates {
+			if info, err := fs.Stat(candidate); err == nil && !info.IsDir() {
+				plan.ClipsMoved++
+				plan.BytesMoved += info.Size()
+				return
+			}
+		}
+	}
+
+	// Like mergeDatabases, prefer notes over detections unless there are none
+	const batchSize = 1000
+	var notesCount int64
+	if sourceDB.Migrator().HasTable(&Note{}) && sourceDB.Model(&Note{}).Count(&notesCount).Error == nil && notesCount > 0 {
+		var cursor uint
+		for {
+			var notes []Note
+			err := sourceDB.Select("id, clip_name").Where("id > ? AND clip_name <> ''", cursor).Order("id").Limit(batchSize).Find(&notes).Error
+			if err != nil {
+				return fmt.Errorf("failed to read notes: %w", err)
+			}
+			if len(notes) == 0 {
+				return nil
+			}
+			cursor = notes[len(notes)-1].ID
+			for i := range notes {
+				count([]string{filepath.Join(sourceFilesDir, notes[i].ClipName)})
+			}
+		}
+	}
+
+	if !sourceDB.Migrator().HasTable(&Detection{}) {
+		return nil
+	}
+	var cursor int64
+	for {
+		var detections []rowDetection
+		err := sourceDB.Raw("SELECT rowid, * FROM detections WHERE rowid > ? ORDER BY rowid LIMIT ?", cursor, batchSize).Scan(&detections).Error
+		if err != nil {
+			return fmt.Errorf("failed to read detections: %w", err)
+		}
+		if len(detections) == 0 {
+			return nil
+		}
+		cursor = detections[len(detections)-1].RowID
+		for i := range detections {
+			if detections[i].FileName == "" {
+				continue
+			}
+			detections[i].Date = normalizeDate(detections[i].Date)
+			count(sourceClipPaths(&detections[i].Detection, sourceFilesDir))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package pi2go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNumberedClipName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		i    int
+		want string
+	}{
+		{name: "2023/01/corvus_corax_90p_20230115T100000Z.mp3", i: 1, want: "2023/01/corvus_corax_90p_20230115T100000Z_1.mp3"},
+		{name: "2023/01/clip.wav", i: 12, want: "2023/01/clip_12.wav"},
+		{name: "clip", i: 2, want: "clip_2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := numberedClipName(tt.name, tt.i); got != tt.want {
+				t.Errorf("numberedClipName(%q, %d) = %q, want %q", tt.name, tt.i, got, tt.want)
+			}
+		})
+	}
+}
+
+// setupNotesDB creates a BirdNET-Go database holding notes, and writes clips below clipsDir,
+// keyed by clip name.
+func setupNotesDB(t *testing.T, dbPath string, notes []Note, clipsDir string, clips map[string]string) {
+	t.Helper()
+
+	db, err := initializeAndMigrateTargetDB(dbPath, logger.Default.LogMode(logger.Silent))
+	if err != nil {
+		t.Fatalf("Failed to create notes database: %v", err)
+	}
+	defer closeDB(db)
+	for i := range notes {
+		if err := db.Create(&notes[i]).Error; err != nil {
+			t.Fatalf("Failed to insert note: %v", err)
+		}
+	}
+
+	for name, content := range clips {
+		path := filepath.Join(clipsDir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write clip: %v", err)
+		}
+	}
+}
+
+// clipNamesByTime returns the clip names of the notes in the database at dbPath by their time.
+func clipNamesByTime(t *testing.T, dbPath string) map[string]string {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer closeDB(db)
+
+	var notes []Note
+	if err := db.Find(&notes).Error; err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	names := make(map[string]string, len(notes))
+	for i := range notes {
+		names[notes[i].Time] = notes[i].ClipName
+	}
+	return names
+}
+
+func TestMergeClips(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tempDir := t.TempDir()
+	sourceDBPath := filepath.Join(tempDir, "station2.db")
+	sourceFilesDir := filepath.Join(tempDir, "station2")
+	sourceNotes := []Note{
+		{Date: "2023-01-15", Time: "10:00:00", ScientificName: "Corvus corax", CommonName: "Common Raven", ClipName: "2023/01/a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", ScientificName: "Parus major", CommonName: "Great Tit", ClipName: "2023/01/b.mp3"},
+		{Date: "2023-01-15", Time: "12:00:00", ScientificName: "Pica pica", CommonName: "Eurasian Magpie", ClipName: "2023/01/c.mp3"},
+		{Date: "2023-02-01", Time: "13:00:00", ScientificName: "Sitta europaea", CommonName: "Eurasian Nuthatch", ClipName: "2023/02/d.mp3"},
+	}
+	sourceClips := map[string]string{"2023/01/a.mp3": "raven", "2023/01/b.mp3": "tit", "2023/02/d.mp3": "nuthatch"}
+	setupNotesDB(t, sourceDBPath, sourceNotes, sourceFilesDir, sourceClips)
+
+	// The target already has a clip named like the raven's, and the great tit's own clip
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	setupNotesDB(t, targetDBPath, []Note{{Date: "2023-01-15", Time: "09:00:00", ScientificName: "Pica pica", ClipName: "2023/01/a.mp3"}},
+		targetFilesDir, map[string]string{"2023/01/a.mp3": "magpie", "2023/01/b.mp3": "tit"})
+
+	mergeOpts := Options{Operation: OperationMerge, SourceDBPath: sourceDBPath, SourceFilesDir: sourceFilesDir,
+		TargetDBPath: targetDBPath, TargetFilesDir: targetFilesDir}
+	result := runMigration(t, mergeOpts)
+	if result.NotesInserted != 4 || result.ClipsTransferred != 2 || result.ClipsMissing != 1 || result.NotesSkipped != 0 {
+		t.Errorf("merge = %+v, want 4 notes, 2 clips copied and 1 missing", result.Counts)
+	}
+
+	want := map[string]string{"09:00:00": "2023/01/a.mp3", "10:00:00": "2023/01/a_1.mp3", "11:00:00": "2023/01/b.mp3", "12:00:00": "", "13:00:00": "2023/02/d.mp3"}
+	got := clipNamesByTime(t, targetDBPath)
+	for timeOfDay, name := range want {
+		if got[timeOfDay] != name {
+			t.Errorf("clip of note at %s = %q, want %q", timeOfDay, got[timeOfDay], name)
+		}
+	}
+	for name, content := range map[string]string{"2023/01/a.mp3": "magpie", "2023/01/a_1.mp3": "raven", "2023/02/d.mp3": "nuthatch"} {
+		if data, err := os.ReadFile(filepath.Join(targetFilesDir, name)); err != nil || string(data) != content {
+			t.Errorf("target clip %s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+	if n := countFiles(t, sourceFilesDir); n != 3 {
+		t.Errorf("%d source clips left after copying, want 3", n)
+	}
+
+	// Moving into another target leaves out the magpie, and a rollback moves the clips back
+	movedDBPath := filepath.Join(tempDir, "moved.db")
+	movedFilesDir := filepath.Join(tempDir, "moved")
+	moveOpts := mergeOpts
+	moveOpts.TargetDBPath, moveOpts.TargetFilesDir, moveOpts.MoveClips, moveOpts.SkipMissingClips = movedDBPath, movedFilesDir, true, true
+	var plan *Plan
+	moveOpts.Confirm = func(p *Plan) bool {
+		plan = p
+		return true
+	}
+	result = runMigration(t, moveOpts)
+	if result.NotesInserted != 3 || result.ClipsTransferred != 3 || result.ClipsMissing != 0 || result.NotesSkipped != 1 {
+		t.Errorf("merge moving clips = %+v, want 3 notes and clips, 1 note skipped", result.Counts)
+	}
+	if plan == nil || plan.ClipsMoved != 3 || plan.BytesMoved != 16 {
+		t.Errorf("merge moving clips plan = %+v, want 3 clips of 16 bytes moved", plan)
+	}
+	if n := countFiles(t, sourceFilesDir); n != 0 {
+		t.Errorf("%d source clips left after moving, want 0", n)
+	}
+
+	rollback := runMigration(t, Options{Operation: OperationRollback, TargetDBPath: movedDBPath})
+	if rollback.NotesRemoved != 3 || rollback.ClipsRestored != 3 {
+		t.Errorf("rollback = %+v, want 3 notes removed and clips restored", rollback.Counts)
+	}
+	for name := range sourceClips {
+		if _, err := os.Stat(filepath.Join(sourceFilesDir, name)); err != nil {
+			t.Errorf("clip not moved back to %s: %v", name, err)
+		}
+	}
+}
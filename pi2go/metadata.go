@@ -0,0 +1,67 @@
+// file metadata.go
+package pi2go
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NoteMetadata keeps what a BirdNET-Go note has no field for, keyed by the ID of the note:
+// the station a merge tagged it with, and the week and analysis overlap of the BirdNET-Pi
+// detection it was converted from. BirdNET-Go ignores the table.
+type NoteMetadata struct {
+	NoteID  uint     `gorm:"primaryKey"`
+	Station string   `gorm:"index"` // Station the note was merged from
+	Week    *int     // Week of the year BirdNET-Pi filtered species by range with, 1 to 48
+	Overlap *float64 // Overlap of the analysis windows, in seconds
+}
+
+// TableName overrides the default table name.
+func (NoteMetadata) TableName() string {
+	return "pi2go_note_metadata"
+}
+
+// empty reports whether the metadata holds nothing to keep.
+func (md *NoteMetadata) empty() bool {
+	return md.Station == "" && md.Week == nil && md.Overlap == nil
+}
+
+// detectionMetadata returns the metadata of the note converted from detection, tagged with station.
+func detectionMetadata(detection *Detection, station string) NoteMetadata {
+	week, overlap := detection.Week, detection.Overlap
+	return NoteMetadata{Station: station, Week: &week, Overlap: &overlap}
+}
+
+// readMetadata returns the metadata of notes in db by note ID, none if db has no metadata table.
+func readMetadata(db *gorm.DB, notes []Note) (map[uint]NoteMetadata, error) {
+	if len(notes) == 0 || !db.Migrator().HasTable(&NoteMetadata{}) {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(notes))
+	for i := range notes {
+		ids[i] = notes[i].ID
+	}
+	var rows []NoteMetadata
+	if err := db.Where("note_id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read note metadata: %w", err)
+	}
+
+	metadata := make(map[uint]NoteMetadata, len(rows))
+	for i := range rows {
+		metadata[rows[i].NoteID] = rows[i]
+	}
+	return metadata, nil
+}
+
+// saveMetadata records metadata of note, inserted into tx, unless it holds nothing. Metadata
+// left behind by a note deleted from the target outside of a rollback is replaced.
+func saveMetadata(tx *gorm.DB, note *Note, metadata NoteMetadata) error {
+	if metadata.empty() {
+		return nil
+	}
+	metadata.NoteID = note.ID
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&metadata).Error
+}
@@ -0,0 +1,89 @@
+package pi2go
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// readTestMetadata returns the metadata of the notes in the database at dbPath by note time.
+func readTestMetadata(t *testing.T, dbPath string) map[string]NoteMetadata {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer closeDB(db)
+
+	var notes []Note
+	if err := db.Find(&notes).Error; err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	metadata, err := readMetadata(db, notes)
+	if err != nil {
+		t.Fatalf("readMetadata() error = %v", err)
+	}
+
+	byTime := make(map[string]NoteMetadata, len(notes))
+	for i := range notes {
+		byTime[notes[i].Time] = metadata[notes[i].ID]
+	}
+	return byTime
+}
+
+// weekAndOverlap returns the week and overlap in metadata, -1 for those it does not have.
+func weekAndOverlap(metadata NoteMetadata) [2]float64 {
+	got := [2]float64{-1, -1}
+	if metadata.Week != nil {
+		got[0] = float64(*metadata.Week)
+	}
+	if metadata.Overlap != nil {
+		got[1] = *metadata.Overlap
+	}
+	return got
+}
+
+func TestPreserveWeekAndOverlap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, Week: 2, Overlap: 0, FileName: "a.mp3"},
+		{Date: "2023-06-01", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, Week: 21, Overlap: 1.5, FileName: "b.mp3"},
+	})
+	want := map[string][2]float64{"10:00:00": {2, 0}, "11:00:00": {21, 1.5}}
+
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true})
+	for timeOfDay, metadata := range readTestMetadata(t, targetDBPath) {
+		if got := weekAndOverlap(metadata); got != want[timeOfDay] || metadata.Station != "" {
+			t.Errorf("metadata of note at %s after copy = %v, %q, want %v", timeOfDay, got, metadata.Station, want[timeOfDay])
+		}
+	}
+
+	// Merging the detections, or the notes converted from them, keeps them too
+	for _, source := range []string{sourceDBPath, targetDBPath} {
+		mergedDBPath := filepath.Join(t.TempDir(), "merged.db")
+		runMigration(t, Options{Operation: OperationMerge, SourceDBPath: source, TargetDBPath: mergedDBPath, Station: "garden"})
+		for timeOfDay, metadata := range readTestMetadata(t, mergedDBPath) {
+			if got := weekAndOverlap(metadata); got != want[timeOfDay] || metadata.Station != "garden" {
+				t.Errorf("metadata of note at %s merged from %s = %v, %q, want %v of garden", timeOfDay, source, got, metadata.Station, want[timeOfDay])
+			}
+		}
+	}
+
+	// Notes merged without metadata have none
+	plainDBPath := filepath.Join(tempDir, "plain.db")
+	setupNotesDB(t, plainDBPath, []Note{{Date: "2023-01-16", Time: "12:00:00", ScientificName: "Pica pica"}}, "", nil)
+	mergedDBPath := filepath.Join(tempDir, "merged.db")
+	runMigration(t, Options{Operation: OperationMerge, SourceDBPath: plainDBPath, TargetDBPath: mergedDBPath})
+	if got := weekAndOverlap(readTestMetadata(t, mergedDBPath)["12:00:00"]); got != [2]float64{-1, -1} {
+		t.Errorf("metadata of note merged without any = %v, want none", got)
+	}
+}
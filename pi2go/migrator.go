@@ -0,0 +1,746 @@
+// file migrator.go
+package pi2go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Operation selects what a Migrator does.
+type Operation string
+
+const (
+	OperationCopy  Operation = "copy"  // Convert detections and copy their clips
+	OperationMove  Operation = "move"  // Convert detections and move their clips
+	OperationMerge Operation = "merge" // Merge notes or detections into an existing BirdNET-Go database
+	OperationSync  Operation = "sync"  // Keep copying new detections until the context is cancelled
+
+	// OperationRollback undoes the most recent run recorded in the manifest of the target database
+	OperationRollback Operation = "rollback"
+)
+
+// defaultSyncInterval is the polling interval of OperationSync when none is given.
+const defaultSyncInterval = time.Minute
+
+// ErrInsufficientSpace is returned when a target volume cannot hold what the run writes to it.
+var ErrInsufficientSpace = errors.New("insufficient space on target volume")
+
+// ErrNotConfirmed is returned when Options.Confirm declines the run.
+var ErrNotConfirmed = errors.New("run not confirmed")
+
+// Options configures a Migrator.
+type Options struct {
+	Operation Operation
+
+	SourceDBPath   string // BirdNET-Pi database, or a .tar, .tar.gz or .zip backup containing birds.db
+	TargetDBPath   string // BirdNET-Go database, created if it does not exist
+	SourceFilesDir string // BirdNET-Pi BirdSongs directory, or a backup archive containing it
+	TargetFilesDir string // BirdNET-Go clips directory
+
+	// SkipAudioTransfer only migrates the database.
+	SkipAudioTransfer bool
+
+	// MoveClips makes OperationMerge move clips from SourceFilesDir instead of copying them. A
+	// merge transfers clips when both SourceFilesDir and TargetFilesDir are given: from the
+	// clips directory of a BirdNET-Go source, or the BirdSongs directory of a BirdNET-Pi one.
+	MoveClips bool
+
+	// SkipMissingClips makes OperationMerge leave out notes whose clip is missing from
+	// SourceFilesDir. They are otherwise merged without a clip and counted as missing clips.
+	SkipMissingClips bool
+
+	// Station tags the notes OperationMerge merges from SourceDBPath with the station they were
+	// recorded at, in the pi2go_note_metadata table of the target. Location replaces their
+	// latitude and longitude. Sources are further databases merged after it, each with its own.
+	Station  string
+	Location *Location
+	Sources  []MergeSource
+
+	// Overrides replace the location and detection parameters of the notes written, globally or
+	// for a date range, in order: later overrides take precedence over earlier ones, and all of
+	// them over the Location of a merged source.
+	Overrides []Override
+
+	// RemapTaxonomy remaps scientific names older BirdNET label files used to those BirdNET-Go
+	// uses, with the bundled table and the rows of TaxonomyPath, a CSV of
+	// old_scientific_name,scientific_name[,common_name] rows taking precedence over it. Giving
+	// TaxonomyPath remaps too. Clips are named after the remapped scientific name. The common
+	// name of a row, English in the bundled table, replaces the recorded one; Locale translates
+	// it afterwards.
+	RemapTaxonomy bool
+	TaxonomyPath  string
+
+	// Locale translates the common names of the notes written by their scientific name, after
+	// any remapping, with a BirdNET label file of "Scientific name_Common name" lines at that
+	// path, or the bundled labels of a locale code such as "fi". Species the labels have no
+	// entry for keep the name recorded and are listed in Result.Untranslated.
+	Locale string
+
+	// Snapshot migrates from a consistent copy of the source database so BirdNET-Pi can keep
+	// running, and records its high-water mark in the target for the next incremental run.
+	// It is ignored for text log, archive and remote sources, which are private copies already,
+	// and by OperationSync, which polls the live database.
+	Snapshot bool
+
+	SourceTextPath string // BirdNET-Pi BirdDB.txt detection log, read instead of the source database
+	FillGaps       bool   // Only import text log detections missing from the source database
+
+	// Source is a remote BirdNET-Pi, ssh://user@host/home/pi/BirdNET-Pi or http://host/.
+	// SourceDBPath and SourceFilesDir are then remote paths, and default to the standard
+	// BirdNET-Pi layout when empty.
+	Source          string
+	SSH             SSHOptions
+	HTTPConcurrency int // Simultaneous requests to a web server Source, 0 for the default
+
+	// SyncInterval is the polling interval of OperationSync, 0 for one minute.
+	SyncInterval time.Duration
+
+	// ManifestPath is the file every inserted note and transferred clip is appended to, for
+	// OperationRollback to undo a run. It defaults to TargetDBPath with ".manifest.jsonl" appended.
+	ManifestPath string
+
+	// SkipBackup turns off the backup of an existing target database, which is otherwise copied
+	// next to it before the run writes to it, to <TargetDBPath>.<Result.RunID>.bak.
+	SkipBackup bool
+
+	// RestoreOnFailure restores the target database from the backup if the run fails. Runs
+	// stopped by cancelling the context are not restored, they can be resumed instead.
+	RestoreOnFailure bool
+
+	// AllowTargetInUse only warns instead of failing when another process, such as BirdNET-Go,
+	// has the target database open. OperationSync never checks, it runs next to BirdNET-Go.
+	// Runs always fail when another run holds the target's lock.
+	AllowTargetInUse bool
+
+	// RollbackRun is the run OperationRollback undoes, by its Result.RunID. When empty, the most
+	// recent run that has not been rolled back yet is undone; only that run may be given.
+	RollbackRun string
+
+	// Confirm is called with what the run is about to do once the source is prepared, before
+	// anything is written. Returning false stops the run with ErrNotConfirmed. Leaving it nil
+	// runs without confirmation, as needed for unattended runs.
+	Confirm func(*Plan) bool
+
+	// Progress is called after every batch and clip transfer. Calls are never concurrent,
+	// but may come from other goroutines than the one running Run.
+	Progress ProgressFunc
+
+	// FS is the filesystem clips are read from and written to, the local one when nil. Archive
+	// and remote sources are mounted on top of it for the duration of a run.
+	FS FileSystem
+
+	// Logger receives the messages of the run, log.Default() when nil.
+	Logger *log.Logger
+}
+
+// Stage identifies the step a running migration is in.
+type Stage string
+
+const (
+	StagePreparing    Stage = "preparing"    // Opening, downloading or snapshotting the source
+	StageMigrating    Stage = "migrating"    // Converting detections into notes
+	StageTransferring Stage = "transferring" // Transferring clips deferred until after the database
+	StageMerging      Stage = "merging"      // Merging into an existing database
+	StageSyncing      Stage = "syncing"      // Waiting for and copying new detections
+	StageRollingBack  Stage = "rolling back" // Restoring clips and removing notes of a run
+)
+
+// Counts are the running totals of a migration.
+type Counts struct {
+	Processed         int   // Source records read
+	NotesInserted     int   // Notes written to the target database
+	NoteErrors        int   // Records that could not be written
+	ClipsTransferred  int   // Clips copied or moved
+	ClipsMissing      int   // Clips referenced by a detection but not found in the source
+	ClipErrors        int   // Clips that failed to transfer
+	ClipsNotRemoved   int   // Clips moved by copying them, their source could not be removed
+	BytesTransferred  int64 // Size of the clips copied or moved
+	NotesRemoved      int   // Notes removed from the target by a rollback
+	ClipsRestored     int   // Clips moved back or removed by a rollback
+	NotesSkipped      int   // Notes a merge left out because their clip is missing
+	NotesOverridden   int   // Notes with a location or detection parameter replaced by an override
+	NotesRemapped     int   // Source records whose species names were remapped by the taxonomy
+	NotesTranslated   int   // Notes with their common name translated by the labels
+	NotesUntranslated int   // Notes keeping their recorded common name, missing from the labels
+}
+
+// Progress reports the state of a running migration.
+type Progress struct {
+	Stage Stage
+	Total int // Source records selected for processing, 0 while unknown or for sync
+	Counts
+}
+
+// ProgressFunc receives progress updates.
+type ProgressFunc func(Progress)
+
+// Result summarizes a finished, failed or cancelled migration.
+type Result struct {
+	Operation  Operation
+	RunID      string // Identifies the run in the manifest, or the run rolled back
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Total      int    // Source records selected for processing
+	LastRowID  int64  // Source rowid recorded in the target for the next run, if any
+	LastDate   string // Date of the source record at LastRowID
+	LastTime   string // Time of the source record at LastRowID
+	Counts
+
+	Space      *SpaceEstimate // Space a copy or move was estimated to need
+	BackupPath string         // Backup of the target database taken before the run, if any
+	Restored   bool           // Whether the target database was restored from BackupPath after a failure
+
+	SourceRows       int64 // Records in the source, including those migrated by earlier runs
+	TargetRowsBefore int64 // Notes in the target before the run
+	TargetRowsAfter  int64 // Notes in the target after the run
+	TargetDuplicates int64 // Target notes with the same date, time and species as another one
+
+	Species      []SpeciesCount // Notes and clips per species, most detected first
+	ClipFailures []ClipFailure  // Clips that failed to transfer, up to maxClipFailures
+	NotRemoved   []string       // Source clips a move copied but left in place, sorted, up to maxClipFailures
+	Remapped     []TaxonRemap   // Scientific names remapped by the taxonomy, with their records
+	Untranslated []string       // Scientific names the labels have no common name for, sorted
+}
+
+// SpeciesCount is the number of notes inserted and clips transferred for one species.
+type SpeciesCount struct {
+	ScientificName string `json:"scientific_name"`
+	CommonName     string `json:"common_name"`
+	Notes          int    `json:"notes"`
+	Clips          int    `json:"clips"`
+}
+
+// ClipFailure describes a clip that could not be transferred.
+type ClipFailure struct {
+	Date           string `json:"date"`
+	Time           string `json:"time"`
+	ScientificName string `json:"scientific_name"`
+	FileName       string `json:"file_name"`
+	Reason         string `json:"reason"`
+}
+
+// maxClipFailures limits how many clip failures are kept in a Result; ClipErrors counts them all.
+const maxClipFailures = 1000
+
+// Migrator converts BirdNET-Pi data to BirdNET-Go.
+type Migrator struct {
+	opts        Options
+	taxonomy    map[string]taxon
+	commonNames map[string]string
+}
+
+// New validates opts and returns a Migrator.
+func New(opts Options) (*Migrator, error) {
+	switch opts.Operation {
+	case OperationCopy, OperationMove, OperationMerge, OperationSync, OperationRollback:
+	default:
+		return nil, fmt.Errorf("invalid operation %q, use copy, move, merge, sync or rollback", opts.Operation)
+	}
+
+	if opts.TargetDBPath == "" {
+		return nil, errors.New("a target database is required")
+	}
+	if opts.ManifestPath == "" {
+		opts.ManifestPath = opts.TargetDBPath + manifestSuffix
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.Operation == OperationRollback {
+		// A rollback only needs the target database and its manifest
+		return &Migrator{opts: opts}, nil
+	}
+
+	if opts.Source == "" && opts.SourceDBPath == "" && opts.SourceTextPath == "" {
+		return nil, errors.New("a source database, text log or remote source is required")
+	}
+	if opts.Source != "" && !isRemoteSource(opts.Source) && !isHTTPSource(opts.Source) {
+		return nil, fmt.Errorf("invalid source %q, expected ssh://user@host/path/to/BirdNET-Pi or http://host/", opts.Source)
+	}
+
+	// Sync polls the live source database, the others are copied once and would never change
+	if opts.Operation == OperationSync {
+		switch {
+		case opts.Source != "":
+			return nil, errors.New("sync needs the live source database, a remote source is only downloaded once; run sync on the BirdNET-Pi or mount its files")
+		case opts.SourceTextPath != "":
+			return nil, errors.New("sync needs the live source database, not a text log")
+		case isArchivePath(opts.SourceDBPath) || isArchivePath(opts.SourceFilesDir):
+			return nil, errors.New("sync needs the live source database and clips, not a backup archive")
+		}
+	}
+
+	if opts.Operation != OperationMerge && !opts.SkipAudioTransfer {
+		if opts.SourceFilesDir == "" && opts.Source == "" {
+			return nil, fmt.Errorf("source directory is required for %s operation", opts.Operation)
+		}
+		if opts.TargetFilesDir == "" {
+			return nil, fmt.Errorf("target directory is required for %s operation", opts.Operation)
+		}
+	}
+
+	if err := validateMergeSources(opts); err != nil {
+		return nil, err
+	}
+	if err := validateOverrides(opts); err != nil {
+		return nil, err
+	}
+
+	if (opts.Operation == OperationMove || opts.Operation == OperationMerge && opts.MoveClips) && !opts.SkipAudioTransfer {
+		if isArchivePath(opts.SourceFilesDir) {
+			return nil, errors.New("files cannot be moved out of a backup archive, use the copy operation")
+		}
+		if isHTTPSource(opts.Source) {
+			return nil, errors.New("files cannot be moved from a web server, use the copy operation")
+		}
+	}
+
+	taxonomy, err := loadTaxonomy(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	commonNames, err := loadLocale(opts.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{opts: opts, taxonomy: taxonomy, commonNames: commonNames}, nil
+}
+
+// Run performs the configured operation. Cancelling ctx stops it after the batch in progress
+// and its clip transfers, which are checkpointed in the target database together, so running
+// the same options again resumes after them; Run then returns the context's error.
+// OperationSync runs until ctx is cancelled. The result is returned even if Run fails.
+// Run holds a lock file next to the target database, and in the target clips directory if it
+// transfers clips, for its duration; it returns ErrTargetLocked while another run holds them and
+// ErrTargetInUse when another process has the target database open, unless
+// Options.AllowTargetInUse is set or the operation is OperationSync.
+func (m *Migrator) Run(ctx context.Context) (*Result, error) {
+	run := &migration{ctx: ctx, opts: m.opts, taxonomy: m.taxonomy, commonNames: m.commonNames}
+	run.result.Operation = m.opts.Operation
+	run.result.StartedAt = time.Now()
+	run.result.RunID = runID(run.result.StartedAt)
+
+	err := run.run()
+
+	run.mu.Lock()
+	result := run.result
+	result.Species = run.sortedSpecies()
+	result.Remapped = run.sortedRemaps()
+	result.Untranslated = run.sortedUntranslated()
+	sort.Strings(result.NotRemoved)
+	run.mu.Unlock()
+	result.FinishedAt = time.Now()
+
+	return &result, err
+}
+
+// migration carries the options and running totals of one Run through the conversion steps.
+// Its source paths are replaced by the local database and mounted clip paths once prepared.
+type migration struct {
+	ctx        context.Context
+	opts       Options
+	sourceKey  string          // Identifies the source across runs for checkpoints, empty if it cannot be
+	localClips bool            // Source clips are on a local filesystem, not in an archive or remote
+	manifest   *manifestWriter // Records the notes and clips of the run for a rollback
+	fs         FileSystem      // Options.FS with the source clips mounted, once prepared
+
+	mu           sync.Mutex
+	result       Result
+	species      map[string]*SpeciesCount // Per species totals by scientific name
+	clipNames    map[string]bool          // Target clip names taken by clips a merge transfers
+	taxonomy     map[string]taxon         // Remappings by recorded scientific name, nil when off
+	remapped     map[string]*TaxonRemap   // Remapped records by recorded scientific name
+	commonNames  map[string]string        // Common names of the Locale by scientific name, nil when off
+	untranslated map[string]bool          // Scientific names missing from the labels
+
+	source        MergeSource // Source a merge is merging
+	targetCounted bool        // Target notes before the run have been counted
+
+	reportMu sync.Mutex // Serializes progress callbacks
+}
+
+// fileSystem returns the filesystem clips of the run are read from and written to.
+func (m *migration) fileSystem() FileSystem {
+	switch {
+	case m.fs != nil:
+		return m.fs
+	case m.opts.FS != nil:
+		return m.opts.FS
+	}
+	return OsFS{}
+}
+
+// id returns the ID of the run in the manifest, empty without a run.
+func (m *migration) id() string {
+	if m == nil {
+		return ""
+	}
+	return m.result.RunID
+}
+
+// logger returns the logger of the run.
+func (m *migration) logger() *log.Logger {
+	if m == nil || m.opts.Logger == nil {
+		return log.Default()
+	}
+	return m.opts.Logger
+}
+
+// run locks the target and performs the operation, restoring the target database if the
+// operation fails and that is asked for.
+func (m *migration) run() error {
+	lock, err := m.lockTarget()
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	// Sync writes next to a running BirdNET-Go by design, and waits for its locks
+	if m.opts.Operation != OperationSync {
+		if err := checkTargetNotInUse(m.opts.TargetDBPath, m.opts.AllowTargetInUse, m.logger()); err != nil {
+			return err
+		}
+	}
+
+	err = m.runOperation()
+	if err != nil && m.ctx.Err() == nil && m.opts.RestoreOnFailure {
+		m.restoreTarget()
+	}
+	return err
+}
+
+// runOperation prepares the source and performs the operation.
+func (m *migration) runOperation() error {
+	if m.opts.Operation == OperationRollback {
+		header, records, err := loadRollback(m.opts.ManifestPath, m.opts.RollbackRun)
+		if err != nil {
+			return err
+		}
+		if err := m.confirm(m.rollbackPlan(header, records)); err != nil {
+			return err
+		}
+		if err := m.backupTarget(); err != nil {
+			return err
+		}
+		return m.rollback(header, records)
+	}
+
+	m.report(StagePreparing)
+	plan := m.newPlan()
+
+	cleanup, err := m.prepareSource()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := m.checkDiskSpace(plan); err != nil {
+		return err
+	}
+	if err := m.planMergeMove(plan); err != nil {
+		return err
+	}
+	if err := m.confirm(plan); err != nil {
+		return err
+	}
+
+	if err := m.backupTarget(); err != nil {
+		return err
+	}
+
+	if err := m.openManifest(); err != nil {
+		return err
+	}
+	defer m.manifest.close()
+
+	switch m.opts.Operation {
+	case OperationMerge:
+		return m.mergeSources()
+	case OperationSync:
+		return m.runSync()
+	}
+
+	return m.convertAndTransferData()
+}
+
+// prepareSource downloads remote sources, opens archives and imports text logs, leaving a local
+// database in opts.SourceDBPath and the clips reachable below opts.SourceFilesDir on the
+// filesystem of the run.
+func (m *migration) prepareSource() (cleanup func(), err error) {
+	var cleanups []func()
+	cleanup = func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	opts := &m.opts
+	m.sourceKey = sourceKey(opts)
+
+	// A database built from the text log, extracted from an archive or downloaded from a remote
+	// BirdNET-Pi is already a private copy, no need to snapshot it. Sync polls the live database.
+	if opts.SourceTextPath != "" || isArchivePath(opts.SourceDBPath) || opts.Source != "" || opts.Operation == OperationSync {
+		opts.Snapshot = false
+	}
+
+	switch {
+	case isHTTPSource(opts.Source):
+		dbPath, filesDir, httpFS, cleanupWebSource, err := prepareHTTPSource(opts.Source, opts.SourceDBPath, opts.SourceFilesDir, opts.HTTPConcurrency, m.logger())
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to open web source: %w", err)
+		}
+		cleanups = append(cleanups, cleanupWebSource)
+		opts.SourceDBPath, opts.SourceFilesDir = dbPath, filesDir
+		m.fs = &MountFS{Base: m.fileSystem(), MountPoint: filesDir, Mounted: httpFS}
+	case opts.Source != "":
+		dbPath, filesDir, sftpFS, cleanupRemoteSource, err := prepareRemoteSource(opts.Source, opts.SourceDBPath, opts.SourceFilesDir, opts.SSH, m.logger())
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to open remote source: %w", err)
+		}
+		cleanups = append(cleanups, cleanupRemoteSource)
+		opts.SourceDBPath, opts.SourceFilesDir = dbPath, filesDir
+		m.fs = &MountFS{Base: m.fileSystem(), MountPoint: filesDir, Mounted: sftpFS}
+	}
+
+	dbPath, archiveFS, cleanupArchiveSource, err := prepareArchiveSource(opts.SourceDBPath, opts.SourceFilesDir, m.logger())
+	if err != nil {
+		return cleanup, fmt.Errorf("failed to open source archive: %w", err)
+	}
+	cleanups = append(cleanups, cleanupArchiveSource)
+	opts.SourceDBPath = dbPath
+	if archiveFS != nil {
+		m.fs = &MountFS{Base: m.fileSystem(), MountPoint: opts.SourceFilesDir, Mounted: archiveFS}
+	}
+	m.localClips = opts.Source == "" && archiveFS == nil
+
+	dbPath, cleanupTextSource, err := prepareTextLogSource(opts.SourceDBPath, opts.SourceTextPath, opts.FillGaps, m.logger())
+	if err != nil {
+		return cleanup, fmt.Errorf("failed to import text log: %w", err)
+	}
+	cleanups = append(cleanups, cleanupTextSource)
+	opts.SourceDBPath = dbPath
+
+	return cleanup, nil
+}
+
+// checkDiskSpace estimates the space a copy or move needs and adds it to plan, together with
+// the clips a move takes away from the source. It fails with ErrInsufficientSpace if a target
+// volume cannot hold what is written to it.
+func (m *migration) checkDiskSpace(plan *Plan) error {
+	if m.opts.Operation != OperationCopy && m.opts.Operation != OperationMove {
+		return nil
+	}
+
+	estimate, err := m.estimateSpace()
+	if err != nil {
+		return fmt.Errorf("failed to check disk space: %w", err)
+	}
+	m.mu.Lock()
+	m.result.Space = estimate
+	m.mu.Unlock()
+
+	plan.Space = estimate
+	if m.opts.Operation == OperationMove {
+		plan.ClipsMoved = estimate.Clips + estimate.ClipsInTarget
+		plan.BytesMoved = estimate.ClipBytes + estimate.InTargetBytes
+	}
+
+	for _, volume := range estimate.Volumes {
+		if !volume.Sufficient() {
+			return fmt.Errorf("%w: %s needs %d bytes for %s, %d bytes free",
+				ErrInsufficientSpace, volume.Path, volume.Needed, strings.Join(volume.For, ", "), volume.Free)
+		}
+	}
+	return nil
+}
+
+// fileOperation returns how clips are transferred.
+func (m *migration) fileOperation() FileOperationType {
+	if m.opts.Operation == OperationMove || m.opts.Operation == OperationMerge && m.opts.MoveClips {
+		return MoveFile
+	}
+	return CopyFile
+}
+
+// setTotal records the number of source records selected for processing.
+func (m *migration) setTotal(total int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.Total = total
+}
+
+// setLast records the last source record completed.
+func (m *migration) setLast(rowID int64, date, timeOfDay string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.LastRowID, m.result.LastDate, m.result.LastTime = rowID, date, timeOfDay
+}
+
+// cancelled reports whether the run has been cancelled.
+func (m *migration) cancelled() bool {
+	return m != nil && m.ctx.Err() != nil
+}
+
+// recordNote counts a source record of the given species and whether its note was written.
+func (m *migration) recordNote(scientificName, commonName string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.Processed++
+	if err != nil {
+		m.result.NoteErrors++
+		return
+	}
+	m.result.NotesInserted++
+	m.speciesCount(scientificName, commonName).Notes++
+}
+
+// recordClip counts the outcome of transferring the clip of detection, and logs a failed
+// transfer and a moved clip whose source could not be removed.
+func (m *migration) recordClip(detection *Detection, transfer clipTransfer, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.logger().Printf("Clip of %s %s %s not transferred: %v", detection.Date, detection.Time, detection.ComName, err)
+	}
+	if transfer.RemoveErr != nil {
+		m.logger().Printf("Clip %s copied to %s but not removed from the source: %v", transfer.Source, transfer.Target, transfer.RemoveErr)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if transfer.RemoveErr != nil {
+		m.result.ClipsNotRemoved++
+		if len(m.result.NotRemoved) < maxClipFailures {
+			m.result.NotRemoved = append(m.result.NotRemoved, transfer.Source)
+		}
+	}
+
+	switch {
+	case err == nil:
+		m.result.ClipsTransferred++
+		m.result.BytesTransferred += transfer.Size
+		m.speciesCount(detection.SciName, detection.ComName).Clips++
+	case errors.Is(err, errSourceFileNotFound):
+		m.result.ClipsMissing++
+	default:
+		m.result.ClipErrors++
+		if len(m.result.ClipFailures) < maxClipFailures {
+			m.result.ClipFailures = append(m.result.ClipFailures, ClipFailure{
+				Date:           detection.Date,
+				Time:           detection.Time,
+				ScientificName: detection.SciName,
+				FileName:       detection.FileName,
+				Reason:         err.Error(),
+			})
+		}
+	}
+}
+
+// speciesCount returns the totals of a species, creating them on first use. m.mu must be held.
+func (m *migration) speciesCount(scientificName, commonName string) *SpeciesCount {
+	if m.species == nil {
+		m.species = make(map[string]*SpeciesCount)
+	}
+
+	count, ok := m.species[scientificName]
+	if !ok {
+		count = &SpeciesCount{ScientificName: scientificName, CommonName: commonName}
+		m.species[scientificName] = count
+	}
+	return count
+}
+
+// sortedSpecies returns the per species totals, most notes first. m.mu must be held.
+func (m *migration) sortedSpecies() []SpeciesCount {
+	species := make([]SpeciesCount, 0, len(m.species))
+	for _, count := range m.species {
+		species = append(species, *count)
+	}
+
+	sort.Slice(species, func(i, j int) bool {
+		if species[i].Notes != species[j].Notes {
+			return species[i].Notes > species[j].Notes
+		}
+		return species[i].ScientificName < species[j].ScientificName
+	})
+	return species
+}
+
+// recordTargetRows counts the notes in the target, before or after the run, and after the run
+// also the notes that duplicate another one.
+func (m *migration) recordTargetRows(targetDB *gorm.DB, after bool) {
+	var rows, duplicates int64
+	if err := targetDB.Model(&Note{}).Count(&rows).Error; err != nil {
+		m.logger().Printf("Error counting target notes: %v", err)
+		return
+	}
+	if after {
+		err := targetDB.Raw(`SELECT COALESCE(SUM(n - 1), 0) FROM
+			(SELECT COUNT(*) AS n FROM notes GROUP BY date, time, scientific_name HAVING COUNT(*) > 1)`).Scan(&duplicates).Error
+		if err != nil {
+			m.logger().Printf("Error counting duplicate target notes: %v", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if after {
+		m.result.TargetRowsAfter, m.result.TargetDuplicates = rows, duplicates
+	} else {
+		m.result.TargetRowsBefore = rows
+	}
+}
+
+// addMergeRows adds the records of a source a merge merges, and those left to merge, to the
+// totals of the run.
+func (m *migration) addMergeRows(rows int64, remaining int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.SourceRows += rows
+	m.result.Total += remaining
+}
+
+// setSourceRows records the number of records in the source.
+func (m *migration) setSourceRows(rows int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.SourceRows = rows
+}
+
+// report passes the current totals to the progress callback.
+func (m *migration) report(stage Stage) {
+	if m == nil || m.opts.Progress == nil {
+		return
+	}
+
+	m.reportMu.Lock()
+	defer m.reportMu.Unlock()
+
+	m.mu.Lock()
+	progress := Progress{Stage: stage, Total: m.result.Total, Counts: m.result.Counts}
+	m.mu.Unlock()
+
+	m.opts.Progress(progress)
+}
@@ -0,0 +1,258 @@
+package pi2go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// runMigration runs a Migrator with opts and fails the test on error.
+func runMigration(t *testing.T, opts Options) *Result {
+	t.Helper()
+
+	m, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	return result
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	t.Parallel()
+
+	valid := Options{Operation: OperationCopy, SourceDBPath: "birds.db", TargetDBPath: "birdnet.db", SourceFilesDir: "BirdSongs", TargetFilesDir: "clips"}
+
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		wantErr string
+	}{
+		{name: "Valid copy", modify: func(o *Options) {}},
+		{name: "Invalid operation", modify: func(o *Options) { o.Operation = "delete" }, wantErr: "invalid operation"},
+		{name: "No source", modify: func(o *Options) { o.SourceDBPath = "" }, wantErr: "source database"},
+		{name: "Text log only", modify: func(o *Options) { o.SourceDBPath, o.SourceTextPath = "", "BirdDB.txt" }},
+		{name: "No target", modify: func(o *Options) { o.TargetDBPath = "" }, wantErr: "target database"},
+		{name: "No source directory", modify: func(o *Options) { o.SourceFilesDir = "" }, wantErr: "source directory"},
+		{name: "No source directory without audio", modify: func(o *Options) { o.SourceFilesDir, o.SkipAudioTransfer = "", true }},
+		{name: "No target directory", modify: func(o *Options) { o.TargetFilesDir = "" }, wantErr: "target directory"},
+		{name: "Merge without directories", modify: func(o *Options) { o.Operation, o.SourceFilesDir, o.TargetFilesDir = OperationMerge, "", "" }},
+		{name: "Remote source without directory", modify: func(o *Options) { o.Source, o.SourceDBPath, o.SourceFilesDir = "ssh://pi@birdnetpi.local", "", "" }},
+		{name: "Invalid remote source", modify: func(o *Options) { o.Source = "ftp://birdnetpi.local" }, wantErr: "invalid source"},
+		{name: "Move from archive", modify: func(o *Options) { o.Operation, o.SourceFilesDir = OperationMove, "backup.tar.gz" }, wantErr: "archive"},
+		{name: "Move from web server", modify: func(o *Options) { o.Operation, o.Source = OperationMove, "http://birdnetpi.local/" }, wantErr: "web server"},
+		{name: "Merge moving clips from archive", modify: func(o *Options) {
+			o.Operation, o.MoveClips, o.SourceFilesDir = OperationMerge, true, "backup.tar.gz"
+		}, wantErr: "archive"},
+		{name: "Sync", modify: func(o *Options) { o.Operation = OperationSync }},
+		{name: "Sync from remote source", modify: func(o *Options) {
+			o.Operation, o.Source, o.SourceDBPath, o.SourceFilesDir = OperationSync, "ssh://pi@birdnetpi.local", "", ""
+		}, wantErr: "live source database"},
+		{name: "Sync from web server", modify: func(o *Options) { o.Operation, o.Source = OperationSync, "http://birdnetpi.local/" }, wantErr: "live source database"},
+		{name: "Sync from text log", modify: func(o *Options) { o.Operation, o.SourceTextPath = OperationSync, "BirdDB.txt" }, wantErr: "text log"},
+		{name: "Sync from archive", modify: func(o *Options) { o.Operation, o.SourceDBPath = OperationSync, "backup.tar.gz" }, wantErr: "archive"},
+		{name: "Sync clips from archive", modify: func(o *Options) { o.Operation, o.SourceFilesDir = OperationSync, "backup.zip" }, wantErr: "archive"},
+		{name: "Rollback without source", modify: func(o *Options) { o.Operation, o.SourceDBPath, o.SourceFilesDir = OperationRollback, "", "" }},
+		{name: "Rollback without target", modify: func(o *Options) { o.Operation, o.TargetDBPath = OperationRollback, "" }, wantErr: "target database"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := valid
+			tt.modify(&opts)
+
+			m, err := New(opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+				if m.opts.SyncInterval != defaultSyncInterval {
+					t.Errorf("SyncInterval = %v, want default %v", m.opts.SyncInterval, defaultSyncInterval)
+				}
+				if m.opts.ManifestPath != "birdnet.db.manifest.jsonl" {
+					t.Errorf("ManifestPath = %q, want it next to the target database", m.opts.ManifestPath)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigratorRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "tit.mp3"},
+	})
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	ravenPath := filepath.Join(sourceFilesDir, "Extracted", "By_Date", "2023-01-15", "Common Raven", "raven.mp3")
+	os.MkdirAll(filepath.Dir(ravenPath), 0o755)
+	os.WriteFile(ravenPath, []byte("raven audio"), 0o644)
+
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	var updates []Progress
+	result := runMigration(t, Options{
+		Operation:      OperationCopy,
+		SourceDBPath:   sourceDBPath,
+		TargetDBPath:   targetDBPath,
+		SourceFilesDir: sourceFilesDir,
+		TargetFilesDir: filepath.Join(tempDir, "clips"),
+		Snapshot:       true,
+		Progress:       func(p Progress) { updates = append(updates, p) },
+	})
+
+	want := Counts{Processed: 2, NotesInserted: 2, ClipsTransferred: 1, ClipsMissing: 1, BytesTransferred: int64(len("raven audio"))}
+	if result.Counts != want {
+		t.Errorf("Run() counts = %+v, want %+v", result.Counts, want)
+	}
+	if result.Operation != OperationCopy || result.Total != 2 || result.LastRowID != 2 {
+		t.Errorf("Run() result = %+v, want copy of 2 records up to rowid 2", result)
+	}
+	if result.FinishedAt.Before(result.StartedAt) {
+		t.Errorf("Run() finished at %v before it started at %v", result.FinishedAt, result.StartedAt)
+	}
+
+	if len(updates) < 2 || updates[0].Stage != StagePreparing {
+		t.Fatalf("progress updates = %+v, want preparing first", updates)
+	}
+	if last := updates[len(updates)-1]; last.Stage != StageMigrating || last.Processed != 2 || last.Total != 2 {
+		t.Errorf("last progress update = %+v, want 2 of 2 migrated", last)
+	}
+
+	verifyNoteCount(t, targetDBPath, 2)
+
+	// Merging the migrated database into a new one reports the merged notes
+	mergedDBPath := filepath.Join(tempDir, "merged.db")
+	result = runMigration(t, Options{Operation: OperationMerge, SourceDBPath: targetDBPath, TargetDBPath: mergedDBPath})
+	if result.NotesInserted != 2 || result.Total != 2 {
+		t.Errorf("merge result = %+v, want 2 notes", result)
+	}
+	verifyNoteCount(t, mergedDBPath, 2)
+}
+
+func TestMigratorRunWithFileSystemAndLogger(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "tit.mp3"},
+	})
+
+	// Runs with their own filesystem and logger do not share any state, so they run side by side
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			tempDir := t.TempDir()
+			sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+			targetFilesDir := filepath.Join(tempDir, "clips")
+			mockFS := NewMockFS()
+			ravenPath := filepath.Join(sourceFilesDir, "Extracted", "By_Date", "2023-01-15", "Common_Raven", "raven.mp3")
+			mockFS.MkdirAll(filepath.Dir(ravenPath), 0o755)
+			mockFS.WriteFile(ravenPath, []byte("raven audio"), 0o644)
+
+			var logs bytes.Buffer
+			m, err := New(Options{
+				Operation:      OperationCopy,
+				SourceDBPath:   sourceDBPath,
+				TargetDBPath:   filepath.Join(tempDir, "birdnet.db"),
+				SourceFilesDir: sourceFilesDir,
+				TargetFilesDir: targetFilesDir,
+				FS:             mockFS,
+				Logger:         log.New(&logs, "", 0),
+			})
+			if err != nil {
+				t.Errorf("New() error = %v", err)
+				return
+			}
+			result, err := m.Run(context.Background())
+			if err != nil {
+				t.Errorf("Run() error = %v", err)
+				return
+			}
+
+			if result.ClipsTransferred != 1 || result.ClipsMissing != 1 {
+				t.Errorf("Run() counts = %+v, want 1 clip transferred and 1 missing", result.Counts)
+			}
+			clipPath := filepath.Join(targetFilesDir, "2023", "01", "corvus_corax_90p_20230115T100000Z.mp3")
+			if !mockFS.FileExists(clipPath) {
+				t.Errorf("clip %s was not written to the filesystem of the run", clipPath)
+			}
+			if _, err := os.Stat(clipPath); !os.IsNotExist(err) {
+				t.Errorf("clip %s was written to the local disk", clipPath)
+			}
+			if !strings.Contains(logs.String(), "tit.mp3") {
+				t.Errorf("logs = %q, want the missing clip logged", logs.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := DefaultFS.(OsFS); !ok {
+		t.Errorf("DefaultFS was replaced by a run")
+	}
+}
+
+func TestMigratorRunErrors(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	// A missing source database is reported instead of exiting
+	m, err := New(Options{Operation: OperationCopy, SourceDBPath: filepath.Join(tempDir, "missing.db"), TargetDBPath: filepath.Join(tempDir, "birdnet.db"), SkipAudioTransfer: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := m.Run(context.Background())
+	if err == nil {
+		t.Errorf("Run() with missing source did not return an error")
+	}
+	if result == nil || result.Operation != OperationCopy {
+		t.Errorf("Run() result = %+v, want result even on failure", result)
+	}
+
+	// A cancelled run stops before migrating anything
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+	})
+	targetDBPath := filepath.Join(tempDir, "cancelled.db")
+	m, err = New(Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = m.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	if result.Processed != 0 {
+		t.Errorf("Run() with cancelled context processed %d records, want 0", result.Processed)
+	}
+}
@@ -0,0 +1,101 @@
+// file overrides.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Override replaces the location and detection parameters BirdNET-Pi recorded with the
+// detections of a date range, such as a latitude and longitude left at 0 or those of where the
+// station was before it moved, or sensitivity and threshold left at the defaults of an install.
+// Fields left nil are kept as recorded.
+type Override struct {
+	From    string `json:"from,omitempty"`    // First date, YYYY-MM-DD, empty for since the first detection
+	To      string `json:"to,omitempty"`      // Last date, YYYY-MM-DD, empty for until the last detection
+	Station string `json:"station,omitempty"` // Only notes a merge tags with this station, empty for all
+
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Threshold   *float64 `json:"threshold,omitempty"`
+	Sensitivity *float64 `json:"sensitivity,omitempty"`
+}
+
+// applies reports whether the override applies to a note on date, tagged with station.
+func (o *Override) applies(date, station string) bool {
+	return (o.From == "" || date >= o.From) && (o.To == "" || date <= o.To) &&
+		(o.Station == "" || o.Station == station)
+}
+
+// apply replaces the values of note the override sets.
+func (o *Override) apply(note *Note) {
+	for _, field := range []struct {
+		value    *float64
+		override *float64
+	}{
+		{&note.Latitude, o.Latitude},
+		{&note.Longitude, o.Longitude},
+		{&note.Threshold, o.Threshold},
+		{&note.Sensitivity, o.Sensitivity},
+	} {
+		if field.override != nil {
+			*field.value = *field.override
+		}
+	}
+}
+
+// validateOverrides checks the overrides in opts.
+func validateOverrides(opts Options) error {
+	for i := range opts.Overrides {
+		o := &opts.Overrides[i]
+		if o.Latitude == nil && o.Longitude == nil && o.Threshold == nil && o.Sensitivity == nil {
+			return fmt.Errorf("override %d sets no value", i+1)
+		}
+		for _, date := range []string{o.From, o.To} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				return fmt.Errorf("override %d: invalid date %q, use YYYY-MM-DD", i+1, date)
+			}
+		}
+		if o.From != "" && o.To != "" && o.From > o.To {
+			return fmt.Errorf("override %d: %s is after %s", i+1, o.From, o.To)
+		}
+		if o.Latitude != nil && (*o.Latitude < -90 || *o.Latitude > 90) {
+			return fmt.Errorf("override %d: latitude %g out of range", i+1, *o.Latitude)
+		}
+		if o.Longitude != nil && (*o.Longitude < -180 || *o.Longitude > 180) {
+			return fmt.Errorf("override %d: longitude %g out of range", i+1, *o.Longitude)
+		}
+		if o.Station != "" && opts.Operation != OperationMerge {
+			return errors.New("overrides of a station only apply to merge, which tags notes with stations")
+		}
+	}
+	return nil
+}
+
+// applyOverrides replaces the location of note with that of the source a merge is merging, if
+// it has one, and its values with those of the overrides that apply to it, tagged with station,
+// later overrides taking precedence. Notes with a value changed are counted.
+func (m *migration) applyOverrides(note *Note, station string) {
+	if m == nil {
+		return
+	}
+
+	before := [4]float64{note.Latitude, note.Longitude, note.Threshold, note.Sensitivity}
+	if location := m.source.Location; location != nil {
+		note.Latitude, note.Longitude = location.Latitude, location.Longitude
+	}
+	for i := range m.opts.Overrides {
+		if o := &m.opts.Overrides[i]; o.applies(note.Date, station) {
+			o.apply(note)
+		}
+	}
+	if before == [4]float64{note.Latitude, note.Longitude, note.Threshold, note.Sensitivity} {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.NotesOverridden++
+}
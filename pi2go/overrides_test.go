@@ -0,0 +1,156 @@
+package pi2go
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// float returns a pointer to value, for the fields of an Override.
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestValidateOverrides(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		operation Operation
+		override  Override
+		wantErr   string
+	}{
+		{name: "Location", override: Override{Latitude: float(60.1), Longitude: float(24.9)}},
+		{name: "Date range", override: Override{From: "2023-01-01", To: "2023-06-30", Sensitivity: float(1)}},
+		{name: "Station of a merge", operation: OperationMerge, override: Override{Station: "lake", Threshold: float(0.7)}},
+		{name: "No value", override: Override{From: "2023-01-01"}, wantErr: "sets no value"},
+		{name: "Invalid date", override: Override{From: "2023-13-01", Threshold: float(0.7)}, wantErr: "invalid date"},
+		{name: "Reversed range", override: Override{From: "2023-06-30", To: "2023-01-01", Threshold: float(0.7)}, wantErr: "after"},
+		{name: "Latitude out of range", override: Override{Latitude: float(91)}, wantErr: "latitude"},
+		{name: "Longitude out of range", override: Override{Longitude: float(-181)}, wantErr: "longitude"},
+		{name: "Station of a copy", override: Override{Station: "lake", Threshold: float(0.7)}, wantErr: "only apply to merge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := Options{Operation: OperationCopy, Overrides: []Override{tt.override}}
+			if tt.operation != "" {
+				opts.Operation = tt.operation
+			}
+			err := validateOverrides(opts)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateOverrides() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	t.Parallel()
+
+	overrides := []Override{
+		{Latitude: float(60.1), Longitude: float(24.9)},
+		{To: "2022-12-31", Latitude: float(61.5), Longitude: float(23.8)},
+		{From: "2023-03-01", Threshold: float(0.8), Sensitivity: float(1.25)},
+		{Station: "lake", Threshold: float(0.6)},
+	}
+	recorded := Note{Latitude: 0, Longitude: 0, Threshold: 0.7, Sensitivity: 1}
+
+	tests := []struct {
+		name     string
+		date     string
+		station  string
+		location *Location
+		want     [4]float64 // Latitude, longitude, threshold and sensitivity
+	}{
+		{name: "Global only", date: "2023-01-15", want: [4]float64{60.1, 24.9, 0.7, 1}},
+		{name: "Before the station moved", date: "2022-12-31", want: [4]float64{61.5, 23.8, 0.7, 1}},
+		{name: "After the settings changed", date: "2023-03-01", want: [4]float64{60.1, 24.9, 0.8, 1.25}},
+		{name: "Station override last", date: "2023-03-01", station: "lake", want: [4]float64{60.1, 24.9, 0.6, 1.25}},
+		{name: "Overrides over the location of the source", date: "2023-01-15", location: &Location{Latitude: 1, Longitude: 2}, want: [4]float64{60.1, 24.9, 0.7, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &migration{opts: Options{Overrides: overrides}, source: MergeSource{Location: tt.location}}
+			note := recorded
+			note.Date = tt.date
+			m.applyOverrides(&note, tt.station)
+			if got := [4]float64{note.Latitude, note.Longitude, note.Threshold, note.Sensitivity}; got != tt.want {
+				t.Errorf("applyOverrides() = %v, want %v", got, tt.want)
+			}
+			if m.result.NotesOverridden != 1 {
+				t.Errorf("applyOverrides() counted %d notes, want 1", m.result.NotesOverridden)
+			}
+
+			// Applying them again changes nothing
+			m.applyOverrides(&note, tt.station)
+			if m.result.NotesOverridden != 1 {
+				t.Errorf("applyOverrides() again counted %d notes, want 1", m.result.NotesOverridden)
+			}
+		})
+	}
+}
+
+func TestOverridesOfRuns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2022-12-31", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, Lat: 0, Lon: 0, Cutoff: 0.7, Sens: 1, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, Lat: 0, Lon: 0, Cutoff: 0.7, Sens: 1, FileName: "b.mp3"},
+		{Date: "2023-03-01", Time: "12:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.7, Lat: 61.5, Lon: 23.8, Cutoff: 0.7, Sens: 1, FileName: "c.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	result := runMigration(t, Options{
+		Operation:         OperationCopy,
+		SourceDBPath:      sourceDBPath,
+		TargetDBPath:      targetDBPath,
+		SkipAudioTransfer: true,
+		Overrides: []Override{
+			{To: "2023-02-28", Latitude: float(60.1), Longitude: float(24.9)},
+			{From: "2023-01-01", Sensitivity: float(1.25)},
+		},
+	})
+	if result.NotesInserted != 3 || result.NotesOverridden != 3 {
+		t.Errorf("copy with overrides = %+v, want 3 notes inserted and overridden", result.Counts)
+	}
+
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+	var notes []Note
+	if err := db.Order("date").Find(&notes).Error; err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	want := [][4]float64{{60.1, 24.9, 0.7, 1}, {60.1, 24.9, 0.7, 1.25}, {61.5, 23.8, 0.7, 1.25}}
+	for i := range notes {
+		if got := [4]float64{notes[i].Latitude, notes[i].Longitude, notes[i].Threshold, notes[i].Sensitivity}; i >= len(want) || got != want[i] {
+			t.Errorf("note on %s = %v, want %v", notes[i].Date, got, want)
+		}
+	}
+
+	// A merge applies overrides of the station it tags notes with
+	mergedDBPath := filepath.Join(t.TempDir(), "merged.db")
+	result = runMigration(t, Options{
+		Operation:    OperationMerge,
+		SourceDBPath: targetDBPath,
+		TargetDBPath: mergedDBPath,
+		Station:      "garden",
+		Overrides:    []Override{{Station: "lake", Threshold: float(0.5)}, {Station: "garden", From: "2023-03-01", Threshold: float(0.6)}},
+	})
+	if result.NotesInserted != 3 || result.NotesOverridden != 1 {
+		t.Errorf("merge with overrides = %+v, want 3 notes inserted and 1 overridden", result.Counts)
+	}
+}
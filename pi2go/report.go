@@ -0,0 +1,220 @@
+// file report.go
+package pi2go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Run statuses recorded in a Report.
+const (
+	StatusCompleted   = "completed"
+	StatusInterrupted = "interrupted"
+	StatusFailed      = "failed"
+)
+
+// timeFormatReport is the timestamp format of reports, RFC 3339 with sub-second precision.
+const timeFormatReport = "2006-01-02T15:04:05.000Z07:00"
+
+// Report is a machine-readable summary of a run, for archiving what happened to a station's data.
+type Report struct {
+	Operation       Operation      `json:"operation"`
+	RunID           string         `json:"run_id,omitempty"` // Run in the manifest, or the run rolled back
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	StartedAt       string         `json:"started_at"`
+	FinishedAt      string         `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Options         ReportOptions  `json:"options"`
+	Source          ReportSource   `json:"source"`
+	Target          ReportTarget   `json:"target"`
+	Records         ReportRecords  `json:"records"`
+	Clips           ReportClips    `json:"clips"`
+	Species         []SpeciesCount `json:"species"`
+	Remapped        []TaxonRemap   `json:"remapped,omitempty"`     // Scientific names remapped by the taxonomy
+	Untranslated    []string       `json:"untranslated,omitempty"` // Scientific names missing from the labels
+}
+
+// ReportOptions are the options a run was started with. Credentials in a Source URL are redacted.
+type ReportOptions struct {
+	SourceDB          string        `json:"source_db,omitempty"`
+	TargetDB          string        `json:"target_db"`
+	SourceDir         string        `json:"source_dir,omitempty"`
+	TargetDir         string        `json:"target_dir,omitempty"`
+	Source            string        `json:"source,omitempty"`
+	SourceText        string        `json:"source_txt,omitempty"`
+	FillGaps          bool          `json:"fill_gaps"`
+	Snapshot          bool          `json:"snapshot"`
+	SkipAudioTransfer bool          `json:"skip_audio_transfer"`
+	MoveClips         bool          `json:"move_clips,omitempty"`
+	SkipMissingClips  bool          `json:"skip_missing_clips,omitempty"`
+	Station           string        `json:"station,omitempty"`
+	Location          *Location     `json:"location,omitempty"`
+	Sources           []MergeSource `json:"sources,omitempty"`
+	Overrides         []Override    `json:"overrides,omitempty"`
+	RemapTaxonomy     bool          `json:"remap_taxonomy,omitempty"`
+	Taxonomy          string        `json:"taxonomy,omitempty"`
+	Locale            string        `json:"locale,omitempty"`
+}
+
+// ReportSource describes the source records and how far they have been migrated.
+type ReportSource struct {
+	Rows      int64  `json:"rows"`     // Records in the source
+	Selected  int    `json:"selected"` // Records selected for this run
+	LastRowID int64  `json:"last_row_id,omitempty"`
+	LastDate  string `json:"last_date,omitempty"`
+	LastTime  string `json:"last_time,omitempty"`
+}
+
+// ReportTarget describes the target database before and after the run.
+type ReportTarget struct {
+	RowsBefore int64  `json:"rows_before"`
+	RowsAfter  int64  `json:"rows_after"`
+	Duplicates int64  `json:"duplicates"`         // Notes with the same date, time and species as another one
+	Backup     string `json:"backup,omitempty"`   // Backup taken before the run
+	Restored   bool   `json:"restored,omitempty"` // Whether the target was restored from Backup after the run failed
+}
+
+// ReportRecords counts what happened to the source records.
+type ReportRecords struct {
+	Processed int   `json:"processed"`
+	Inserted  int   `json:"inserted"`
+	Failed    int   `json:"failed"`
+	Skipped   int64 `json:"skipped"`           // Migrated by an earlier run, or not selected because the run stopped
+	Removed   int   `json:"removed,omitempty"` // Notes removed by a rollback
+
+	MissingClip  int `json:"missing_clip,omitempty"` // Notes a merge left out because their clip is missing
+	Overridden   int `json:"overridden,omitempty"`   // Notes with values replaced by an override
+	Remapped     int `json:"remapped,omitempty"`     // Records with species names remapped by the taxonomy
+	Translated   int `json:"translated,omitempty"`   // Notes with their common name translated by the labels
+	Untranslated int `json:"untranslated,omitempty"` // Notes keeping their recorded common name, missing from the labels
+}
+
+// ReportClips counts the outcome of the clip transfers.
+type ReportClips struct {
+	Copied   int           `json:"copied"`
+	Moved    int           `json:"moved"`
+	Missing  int           `json:"missing"`
+	Failed   int           `json:"failed"`
+	Bytes    int64         `json:"bytes"`
+	Restored int           `json:"restored,omitempty"` // Clips moved back or removed by a rollback
+	Failures []ClipFailure `json:"failures,omitempty"` // Up to the first 1000 failures with their reasons
+
+	NotRemoved      int      `json:"not_removed,omitempty"`       // Moved clips copied but left in the source
+	NotRemovedPaths []string `json:"not_removed_paths,omitempty"` // Up to the first 1000 of those sources
+}
+
+// NewReport builds the report of a run of opts that produced result and runErr.
+func NewReport(opts Options, result *Result, runErr error) *Report {
+	report := &Report{
+		Operation:       result.Operation,
+		RunID:           result.RunID,
+		Status:          StatusCompleted,
+		StartedAt:       result.StartedAt.Format(timeFormatReport),
+		FinishedAt:      result.FinishedAt.Format(timeFormatReport),
+		DurationSeconds: result.FinishedAt.Sub(result.StartedAt).Seconds(),
+		Options: ReportOptions{
+			SourceDB:          opts.SourceDBPath,
+			TargetDB:          opts.TargetDBPath,
+			SourceDir:         opts.SourceFilesDir,
+			TargetDir:         opts.TargetFilesDir,
+			Source:            redactURL(opts.Source),
+			SourceText:        opts.SourceTextPath,
+			FillGaps:          opts.FillGaps,
+			Snapshot:          opts.Snapshot,
+			SkipAudioTransfer: opts.SkipAudioTransfer,
+			MoveClips:         opts.MoveClips,
+			SkipMissingClips:  opts.SkipMissingClips,
+			Station:           opts.Station,
+			Location:          opts.Location,
+			Sources:           opts.Sources,
+			Overrides:         opts.Overrides,
+			RemapTaxonomy:     opts.RemapTaxonomy || opts.TaxonomyPath != "",
+			Taxonomy:          opts.TaxonomyPath,
+			Locale:            opts.Locale,
+		},
+		Source: ReportSource{
+			Rows:      result.SourceRows,
+			Selected:  result.Total,
+			LastRowID: result.LastRowID,
+			LastDate:  result.LastDate,
+			LastTime:  result.LastTime,
+		},
+		Target: ReportTarget{
+			RowsBefore: result.TargetRowsBefore,
+			RowsAfter:  result.TargetRowsAfter,
+			Duplicates: result.TargetDuplicates,
+			Backup:     result.BackupPath,
+			Restored:   result.Restored,
+		},
+		Records: ReportRecords{
+			Processed: result.Processed,
+			Inserted:  result.NotesInserted,
+			Failed:    result.NoteErrors,
+			Skipped:   max(result.SourceRows-int64(result.Processed), 0),
+			Removed:   result.NotesRemoved,
+
+			MissingClip:  result.NotesSkipped,
+			Overridden:   result.NotesOverridden,
+			Remapped:     result.NotesRemapped,
+			Translated:   result.NotesTranslated,
+			Untranslated: result.NotesUntranslated,
+		},
+		Clips: ReportClips{
+			Missing:  result.ClipsMissing,
+			Failed:   result.ClipErrors,
+			Bytes:    result.BytesTransferred,
+			Restored: result.ClipsRestored,
+			Failures: result.ClipFailures,
+
+			NotRemoved:      result.ClipsNotRemoved,
+			NotRemovedPaths: result.NotRemoved,
+		},
+		Species:      result.Species,
+		Remapped:     result.Remapped,
+		Untranslated: result.Untranslated,
+	}
+
+	if result.Operation == OperationMove || result.Operation == OperationMerge && opts.MoveClips {
+		report.Clips.Moved = result.ClipsTransferred
+	} else {
+		report.Clips.Copied = result.ClipsTransferred
+	}
+
+	switch {
+	case errors.Is(runErr, context.Canceled), errors.Is(runErr, context.DeadlineExceeded):
+		report.Status = StatusInterrupted
+		report.Error = runErr.Error()
+	case runErr != nil:
+		report.Status = StatusFailed
+		report.Error = runErr.Error()
+	}
+
+	return report
+}
+
+// WriteFile writes the report to path as indented JSON.
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// redactURL replaces the password in a URL with "xxxxx", leaving other values unchanged.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
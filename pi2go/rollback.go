@@ -0,0 +1,211 @@
+// file rollback.go
+package pi2go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// loadRollback reads the manifest at manifestPath and returns the header and records of the run
+// to roll back, the most recent one that has not been rolled back, which run must name if set.
+func loadRollback(manifestPath, run string) (header ManifestEntry, records []ManifestEntry, err error) {
+	entries, err := readManifest(manifestPath)
+	if err != nil {
+		return header, nil, err
+	}
+	return runToRollBack(entries, run)
+}
+
+// rollback undoes the run with header and records loaded from the manifest. Clips it moved are moved back to where
+// they were found in the BirdNET-Pi By_Date layout, clips it copied are removed, the notes it
+// inserted are deleted and the migration state it updated is put back, so running the same
+// operation again starts over from where that run started. Clips that cannot be restored are
+// left in place and reported; the run is only marked as rolled back once all are restored, so
+// the rollback can be repeated after fixing them.
+func (m *migration) rollback(header ManifestEntry, records []ManifestEntry) error {
+	m.report(StageRollingBack)
+
+	m.mu.Lock()
+	m.result.RunID = header.Run
+	m.mu.Unlock()
+	m.setTotal(len(records))
+	m.logger().Printf("Rolling back %s run %s with %d records", header.Operation, header.Run, len(records))
+
+	targetDB, err := initializeAndMigrateTargetDB(m.opts.TargetDBPath, createGormLogger(m.logger()))
+	if err != nil {
+		return err
+	}
+	defer closeDB(targetDB)
+	m.recordTargetRows(targetDB, false)
+	defer m.recordTargetRows(targetDB, true)
+
+	// Restore the newest clips first, so a clip transferred twice ends up where it was first found
+	for i := len(records) - 1; i >= 0; i-- {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		if records[i].Clip != nil {
+			err = restoreClip(header.Operation, records[i].Clip, m.fileSystem())
+		}
+		m.recordRestore(&records[i], err)
+		m.report(StageRollingBack)
+	}
+
+	err = targetDB.Transaction(func(tx *gorm.DB) error {
+		if err := m.removeNotes(tx, records); err != nil {
+			return err
+		}
+		if err := removePendingClips(tx, header.Run); err != nil {
+			return err
+		}
+		return restoreMigrationState(tx, header)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	failed := m.result.ClipErrors
+	m.mu.Unlock()
+	if failed > 0 {
+		return fmt.Errorf("%d clips could not be restored, run the rollback again once they are fixed", failed)
+	}
+
+	manifest, err := openManifest(m.opts.ManifestPath, header.Run, OperationRollback, "", m.logger())
+	if err != nil {
+		return err
+	}
+	defer manifest.close()
+	manifest.append(ManifestEntry{Kind: manifestRolledBack})
+	manifest.sync()
+
+	return nil
+}
+
+// restoreClip undoes the transfer of clip by operation on fs: a moved clip is moved back to its
+// source path and a copied clip is removed. A clip that is not in the target any more is taken
+// to be restored already. A clip that changed since it was transferred is left in place.
+func restoreClip(operation Operation, clip *ManifestClip, fs FileSystem) error {
+	if !fs.FileExists(clip.Target) {
+		if !clip.moved(operation) || fs.FileExists(clip.Source) {
+			return nil
+		}
+		return fmt.Errorf("clip is neither in %s nor in %s", clip.Target, clip.Source)
+	}
+
+	data, err := fs.ReadFile(clip.Target)
+	if err != nil {
+		return fmt.Errorf("failed to read clip: %w", err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != clip.SHA256 {
+		return fmt.Errorf("%s has changed since it was transferred, leaving it in place", clip.Target)
+	}
+
+	if clip.moved(operation) {
+		if clip.Remote != "" {
+			return fmt.Errorf("%s was moved from %s, it has to be moved back manually", clip.Target, clip.Remote)
+		}
+		if err := fs.MkdirAll(filepath.Dir(clip.Source), 0o755); err != nil {
+			return fmt.Errorf("failed to create source directory: %w", err)
+		}
+		if err := fs.WriteFile(clip.Source, data, 0o644); err != nil {
+			return fmt.Errorf("failed to restore clip: %w", err)
+		}
+	}
+
+	if err := fs.Remove(clip.Target); err != nil {
+		return fmt.Errorf("failed to remove clip: %w", err)
+	}
+	return nil
+}
+
+// recordRestore counts a rolled back record and the outcome of restoring its clip.
+func (m *migration) recordRestore(record *ManifestEntry, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.Processed++
+	switch {
+	case record.Clip == nil:
+	case err == nil:
+		m.result.ClipsRestored++
+	default:
+		m.logger().Printf("Error restoring clip: %v", err)
+		m.result.ClipErrors++
+		if len(m.result.ClipFailures) < maxClipFailures {
+			failure := ClipFailure{FileName: record.Clip.Target, Reason: err.Error()}
+			if record.Note != nil {
+				failure.Date, failure.Time, failure.ScientificName = record.Note.Date, record.Note.Time, record.Note.ScientificName
+			}
+			m.result.ClipFailures = append(m.result.ClipFailures, failure)
+		}
+	}
+}
+
+// removeNotes deletes the notes inserted by the records. Notes are matched on their date, time
+// and species as well as their id, so a note that replaced a removed one is never touched.
+func (m *migration) removeNotes(tx *gorm.DB, records []ManifestEntry) error {
+	removed := 0
+	for i := range records {
+		note := records[i].Note
+		if note == nil {
+			continue
+		}
+
+		result := tx.Where("id = ? AND date = ? AND time = ? AND scientific_name = ?", note.ID, note.Date, note.Time, note.ScientificName).Delete(&Note{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove note %d: %w", note.ID, result.Error)
+		}
+		removed += int(result.RowsAffected)
+
+		if result.RowsAffected > 0 && tx.Migrator().HasTable(&NoteMetadata{}) {
+			if err := tx.Where("note_id = ?", note.ID).Delete(&NoteMetadata{}).Error; err != nil {
+				return fmt.Errorf("failed to remove metadata of note %d: %w", note.ID, err)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.result.NotesRemoved += removed
+	return nil
+}
+
+// removePendingClips removes the clips a sync run queued for its notes and has not transferred,
+// so a later sync does not transfer clips of notes rolled back.
+func removePendingClips(tx *gorm.DB, run string) error {
+	if !tx.Migrator().HasTable(&pendingClip{}) {
+		return nil
+	}
+	if err := tx.Where("run = ?", run).Delete(&pendingClip{}).Error; err != nil {
+		return fmt.Errorf("failed to remove pending clips: %w", err)
+	}
+	return nil
+}
+
+// restoreMigrationState puts back the migration state recorded in the header of a run.
+func restoreMigrationState(tx *gorm.DB, header ManifestEntry) error {
+	if err := tx.AutoMigrate(&MigrationState{}); err != nil {
+		return fmt.Errorf("failed to migrate state table: %w", err)
+	}
+
+	for _, key := range header.StateKeys {
+		if err := tx.Where("source = ?", key).Delete(&MigrationState{}).Error; err != nil {
+			return fmt.Errorf("failed to remove migration state: %w", err)
+		}
+	}
+	for i := range header.State {
+		if err := saveMigrationState(tx, &header.State[i]); err != nil {
+			return fmt.Errorf("failed to restore migration state: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,343 @@
+package pi2go
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRestoreClip(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("raven audio")
+	sum := sha256.Sum256(content)
+	clip := ManifestClip{
+		Source: "/BirdSongs/Extracted/By_Date/2023-01-15/Common Raven/raven.mp3",
+		Target: "/clips/2023/01/corvus_corax_90p_20230115T100000Z.mp3",
+		Size:   int64(len(content)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	tests := []struct {
+		name       string
+		operation  Operation
+		remote     string
+		inTarget   []byte // Content of the target, nil if it is not there
+		inSource   bool
+		wantErr    bool
+		wantTarget bool
+		wantSource bool
+	}{
+		{name: "Copied clip is removed", operation: OperationCopy, inTarget: content, inSource: true, wantSource: true},
+		{name: "Moved clip is moved back", operation: OperationMove, inTarget: content, wantSource: true},
+		{name: "Moved clip restored already", operation: OperationMove, inSource: true, wantSource: true},
+		{name: "Copied clip removed already", operation: OperationCopy},
+		{name: "Moved clip lost", operation: OperationMove, wantErr: true},
+		{name: "Changed clip is left in place", operation: OperationMove, inTarget: []byte("edited"), wantErr: true, wantTarget: true},
+		{name: "Clip moved from a remote source", operation: OperationMove, remote: "ssh://pi@birdnetpi.local/home/pi/BirdNET-Pi", inTarget: content, wantErr: true, wantTarget: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := NewMockFS()
+			fs.MkdirAll(filepath.Dir(clip.Target), 0o755)
+			fs.MkdirAll(filepath.Dir(clip.Source), 0o755)
+			if tt.inTarget != nil {
+				fs.WriteFile(clip.Target, tt.inTarget, 0o644)
+			}
+			if tt.inSource {
+				fs.WriteFile(clip.Source, content, 0o644)
+			}
+
+			clip := clip
+			clip.Remote = tt.remote
+			err := restoreClip(tt.operation, &clip, fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreClip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fs.FileExists(clip.Target) != tt.wantTarget || fs.FileExists(clip.Source) != tt.wantSource {
+				t.Errorf("target exists = %v, source exists = %v, want %v and %v",
+					fs.FileExists(clip.Target), fs.FileExists(clip.Source), tt.wantTarget, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestRollbackMove(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(4)
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, detections[:2])
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	var clipPaths []string
+	for i := range detections[2:] {
+		path := sourceClipPaths(&detections[2+i], sourceFilesDir)[0]
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte("audio of "+detections[2+i].SciName), 0o644)
+		clipPaths = append(clipPaths, path)
+	}
+
+	// A first run is kept, the move of the detections added after it is rolled back
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: true})
+	stateBefore := loadTestState(t, targetDBPath, migrationStateKey(sourceDBPath))
+	for i := range detections[2:] {
+		insertMockDetection(t, sourceDB, &detections[2+i])
+	}
+
+	moveOpts := Options{Operation: OperationMove, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SourceFilesDir: sourceFilesDir, TargetFilesDir: targetFilesDir, Snapshot: true}
+	moved := runMigration(t, moveOpts)
+	if moved.ClipsTransferred != 2 || countFiles(t, targetFilesDir) != 2 {
+		t.Fatalf("move transferred %d clips, want 2", moved.ClipsTransferred)
+	}
+
+	rollbackOpts := Options{Operation: OperationRollback, TargetDBPath: targetDBPath}
+	result := runMigration(t, rollbackOpts)
+	if result.RunID != moved.RunID || result.NotesRemoved != 2 || result.ClipsRestored != 2 {
+		t.Errorf("rollback of %s = run %s, %d notes removed, %d clips restored, want 2 and 2",
+			moved.RunID, result.RunID, result.NotesRemoved, result.ClipsRestored)
+	}
+	for _, path := range clipPaths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("clip not moved back to %s: %v", path, err)
+		}
+	}
+	if n := countFiles(t, targetFilesDir); n != 0 {
+		t.Errorf("%d clips left in the target", n)
+	}
+	verifyDistinctNotes(t, targetDBPath, 2)
+	if state := loadTestState(t, targetDBPath, migrationStateKey(sourceDBPath)); state == nil || state.LastRowID != stateBefore.LastRowID {
+		t.Errorf("migration state = %+v, want it restored to %+v", state, stateBefore)
+	}
+
+	// Moving again starts over from where the rolled back run started
+	moved = runMigration(t, moveOpts)
+	if moved.NotesInserted != 2 || moved.ClipsTransferred != 2 {
+		t.Errorf("move after rollback inserted %d notes and %d clips, want 2 and 2", moved.NotesInserted, moved.ClipsTransferred)
+	}
+	verifyDistinctNotes(t, targetDBPath, 4)
+
+	// Runs are rolled back most recent first, a run given by ID must be the most recent one
+	rollbackOpts.RollbackRun = result.RunID
+	m, _ := New(rollbackOpts)
+	if _, err := m.Run(context.Background()); err == nil {
+		t.Error("rolling back a run twice succeeded")
+	}
+	rollbackOpts.RollbackRun = ""
+	runMigration(t, rollbackOpts)
+	runMigration(t, rollbackOpts)
+	verifyDistinctNotes(t, targetDBPath, 0)
+
+	m, _ = New(rollbackOpts)
+	if _, err := m.Run(context.Background()); !errors.Is(err, errNothingToRollBack) {
+		t.Errorf("Run() with every run rolled back error = %v, want %v", err, errNothingToRollBack)
+	}
+}
+
+func TestRollbackChangedClip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(1)
+	_, sourceDBPath := setupSnapshotSourceDB(t, detections)
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	sourcePath := sourceClipPaths(&detections[0], sourceFilesDir)[0]
+	os.MkdirAll(filepath.Dir(sourcePath), 0o755)
+	os.WriteFile(sourcePath, []byte("raven audio"), 0o644)
+
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	runMigration(t, Options{Operation: OperationMove, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SourceFilesDir: sourceFilesDir, TargetFilesDir: filepath.Join(tempDir, "clips")})
+
+	entries, err := readManifest(targetDBPath + manifestSuffix)
+	if err != nil || len(entries) != 2 || entries[1].Clip == nil {
+		t.Fatalf("manifest = %+v, %v, want a header and one clip", entries, err)
+	}
+	targetPath := entries[1].Clip.Target
+	os.WriteFile(targetPath, []byte("edited audio"), 0o644)
+
+	// The changed clip stays, the run is not marked as rolled back so it can be retried
+	m, _ := New(Options{Operation: OperationRollback, TargetDBPath: targetDBPath})
+	result, err := m.Run(context.Background())
+	if err == nil || result.ClipErrors != 1 || len(result.ClipFailures) != 1 {
+		t.Fatalf("Run() = %+v, %v, want one clip failure", result.Counts, err)
+	}
+	if data, _ := os.ReadFile(targetPath); string(data) != "edited audio" {
+		t.Errorf("changed clip was overwritten or removed")
+	}
+	verifyDistinctNotes(t, targetDBPath, 0)
+
+	os.WriteFile(targetPath, []byte("raven audio"), 0o644)
+	result = runMigration(t, Options{Operation: OperationRollback, TargetDBPath: targetDBPath})
+	if result.ClipsRestored != 1 {
+		t.Errorf("retried rollback restored %d clips, want 1", result.ClipsRestored)
+	}
+	if data, _ := os.ReadFile(sourcePath); string(data) != "raven audio" {
+		t.Errorf("clip not moved back to %s", sourcePath)
+	}
+}
+
+func TestRollbackMoveThatKeptClips(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(2)
+	sourceDBPath := setupBirdNETPiSourceDB(t, detections)
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	mockFS := NewMockFS()
+	var clipPaths []string
+	for i := range detections {
+		path := sourceClipPaths(&detections[i], sourceFilesDir)[0]
+		mockFS.MkdirAll(filepath.Dir(path), 0o755)
+		mockFS.WriteFile(path, []byte("audio of "+detections[i].SciName), 0o644)
+		clipPaths = append(clipPaths, path)
+	}
+
+	// The originals cannot be removed, the move copies them and reports them left in place
+	mockFS.SetFailMode("Remove", true)
+	var logs bytes.Buffer
+	moved := runMigration(t, Options{
+		Operation:      OperationMove,
+		SourceDBPath:   sourceDBPath,
+		TargetDBPath:   targetDBPath,
+		SourceFilesDir: sourceFilesDir,
+		TargetFilesDir: targetFilesDir,
+		FS:             mockFS,
+		Logger:         log.New(&logs, "", 0),
+	})
+	if moved.ClipsTransferred != 2 || moved.ClipsNotRemoved != 2 || !reflect.DeepEqual(moved.NotRemoved, clipPaths) {
+		t.Errorf("move = %+v, not removed %v, want 2 clips transferred and %v left in place", moved.Counts, moved.NotRemoved, clipPaths)
+	}
+	if !strings.Contains(logs.String(), clipPaths[0]) {
+		t.Errorf("log = %q, want the clip left in place", logs.String())
+	}
+	if report := NewReport(Options{Operation: OperationMove}, moved, nil); report.Clips.NotRemoved != 2 || len(report.Clips.NotRemovedPaths) != 2 {
+		t.Errorf("report clips = %+v, want 2 not removed", report.Clips)
+	}
+
+	// The rollback removes the copies, the originals stay where they are
+	mockFS.SetFailMode("Remove", false)
+	entries, err := readManifest(targetDBPath + manifestSuffix)
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Clip != nil && (!entry.Clip.Kept || entry.Clip.moved(OperationMove)) {
+			t.Errorf("manifest clip %+v, want it kept in its source", entry.Clip)
+		}
+	}
+	result := runMigration(t, Options{Operation: OperationRollback, TargetDBPath: targetDBPath, FS: mockFS})
+	if result.ClipsRestored != 2 || result.ClipErrors != 0 {
+		t.Errorf("rollback restored %d clips with %d errors, want 2 and none", result.ClipsRestored, result.ClipErrors)
+	}
+	for _, path := range clipPaths {
+		if !mockFS.FileExists(path) {
+			t.Errorf("original %s removed", path)
+		}
+	}
+	if files, _ := mockFS.ListDir(filepath.Join(targetFilesDir, "2023", "01")); len(files) != 0 {
+		t.Errorf("copies left in the target: %v", files)
+	}
+}
+
+func TestRollbackSyncRemovesPendingClips(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, checkpointTestDetections(2))
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+
+	// BirdNET-Pi has not written the clips yet, so sync queues them, stopped after its first poll
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := New(Options{
+		Operation:      OperationSync,
+		SourceDBPath:   sourceDBPath,
+		TargetDBPath:   targetDBPath,
+		SourceFilesDir: filepath.Join(tempDir, "BirdSongs"),
+		TargetFilesDir: filepath.Join(tempDir, "clips"),
+		SyncInterval:   time.Hour,
+		Progress: func(p Progress) {
+			if p.Stage == StageSyncing {
+				cancel()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.Run(ctx); err != nil {
+		t.Fatalf("sync error = %v", err)
+	}
+	if n := countPendingClips(t, targetDBPath); n != 2 {
+		t.Fatalf("%d clips pending after sync, want 2", n)
+	}
+
+	// The rollback removes them with the notes, a later sync does not transfer them
+	result := runMigration(t, Options{Operation: OperationRollback, TargetDBPath: targetDBPath})
+	if result.NotesRemoved != 2 {
+		t.Errorf("rollback removed %d notes, want 2", result.NotesRemoved)
+	}
+	if n := countPendingClips(t, targetDBPath); n != 0 {
+		t.Errorf("%d clips pending after rollback, want none", n)
+	}
+}
+
+// countPendingClips returns the number of clips queued by sync in the target database.
+func countPendingClips(t *testing.T, targetDBPath string) int64 {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+
+	var count int64
+	if err := db.Model(&pendingClip{}).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count pending clips: %v", err)
+	}
+	return count
+}
+
+// countFiles returns the number of files below dir.
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	count := 0
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,164 @@
+// file snapshot.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// sourceSnapshot is a consistent point-in-time copy of a source database.
+type sourceSnapshot struct {
+	Path           string // Path of the snapshot database file
+	HighWaterRowID int64  // Highest detections rowid contained in the snapshot
+	tempDir        string
+}
+
+// MigrationState records how far a source database has been migrated into the target database.
+type MigrationState struct {
+	Source    string    `gorm:"primaryKey" json:"source"` // Absolute path of the source database
+	LastRowID int64     `json:"last_row_id"`              // Highest source detections rowid that has been migrated
+	LastDate  string    `json:"last_date"`                // Date of the most recent migrated detection
+	LastTime  string    `json:"last_time"`                // Time of the most recent migrated detection
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName overrides the default table name.
+func (MigrationState) TableName() string {
+	return "pi2go_migration_state"
+}
+
+// createSourceSnapshot copies the source database into a temporary file using VACUUM INTO.
+// VACUUM INTO runs in a single read transaction, so the copy is consistent even while
+// BirdNET-Pi keeps inserting detections, and it preserves the rowids of the detections table.
+func createSourceSnapshot(sourceDBPath string) (*sourceSnapshot, error) {
+	if _, err := os.Stat(sourceDBPath); err != nil {
+		return nil, fmt.Errorf("source database not accessible: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "birdnet-pi2go-snapshot-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	snapshot := &sourceSnapshot{
+		Path:    filepath.Join(tempDir, filepath.Base(sourceDBPath)),
+		tempDir: tempDir,
+	}
+
+	liveDB, err := openLiveSourceDB(sourceDBPath, logger.Default.LogMode(logger.Silent))
+	if err != nil {
+		snapshot.Cleanup()
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer closeDB(liveDB)
+
+	if err := liveDB.Exec("VACUUM INTO ?", snapshot.Path).Error; err != nil {
+		snapshot.Cleanup()
+		return nil, fmt.Errorf("failed to snapshot source database: %w", err)
+	}
+
+	highWater, err := detectionsHighWaterRowID(snapshot.Path)
+	if err != nil {
+		snapshot.Cleanup()
+		return nil, err
+	}
+	snapshot.HighWaterRowID = highWater
+
+	return snapshot, nil
+}
+
+// openLiveSourceDB opens a database that another process may be writing to. The connection is
+// strictly read-only and, unlike initializeAndMigrateSourceDB, does not change any pragmas.
+func openLiveSourceDB(sourceDBPath string, newLogger logger.Interface) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open("file:"+sourceDBPath+"?mode=ro&_pragma=busy_timeout(5000)"), &gorm.Config{Logger: newLogger})
+}
+
+// Cleanup removes the snapshot file and its temporary directory.
+func (s *sourceSnapshot) Cleanup() {
+	os.RemoveAll(s.tempDir)
+}
+
+// detectionsHighWaterRowID returns the highest rowid in the detections table of the given database,
+// or zero if the table is empty or does not exist.
+func detectionsHighWaterRowID(dbPath string) (int64, error) {
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer closeDB(db)
+
+	var tableCount int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='detections'").Scan(&tableCount).Error; err != nil {
+		return 0, fmt.Errorf("failed to inspect snapshot: %w", err)
+	}
+	if tableCount == 0 {
+		return 0, nil
+	}
+
+	var highWater int64
+	if err := db.Raw("SELECT COALESCE(MAX(rowid), 0) FROM detections").Scan(&highWater).Error; err != nil {
+		return 0, fmt.Errorf("failed to read snapshot high-water mark: %w", err)
+	}
+
+	return highWater, nil
+}
+
+// migrationStateKey returns the key under which the migration state of a source database is stored.
+func migrationStateKey(sourceDBPath string) string {
+	if absPath, err := filepath.Abs(sourceDBPath); err == nil {
+		return absPath
+	}
+	return sourceDBPath
+}
+
+// loadMigrationState returns the recorded migration state for a source, or nil if there is none.
+func loadMigrationState(targetDB *gorm.DB, source string) (*MigrationState, error) {
+	if err := targetDB.AutoMigrate(&MigrationState{}); err != nil {
+		return nil, err
+	}
+
+	return findMigrationState(targetDB, source)
+}
+
+// findMigrationState returns the recorded migration state for a source, or nil if there is none,
+// from a target database known to have the migration state table.
+func findMigrationState(targetDB *gorm.DB, source string) (*MigrationState, error) {
+	var state MigrationState
+	err := targetDB.Where("source = ?", source).First(&state).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// saveMigrationState stores the migration state for a source, replacing any previous state.
+func saveMigrationState(targetDB *gorm.DB, state *MigrationState) error {
+	state.UpdatedAt = time.Now()
+	return targetDB.Clauses(clause.OnConflict{UpdateAll: true}).Create(state).Error
+}
+
+// formulateRowIDQuery constructs a SQL WHERE clause selecting detections added after lastRowID.
+func formulateRowIDQuery(lastRowID int64) (whereClause string, params []any) {
+	return "rowid > ?", []any{lastRowID}
+}
+
+// lastDetectionUpTo returns the date and time of the most recent detection with a rowid up to highWater,
+// as stored in the source.
+func lastDetectionUpTo(sourceDB *gorm.DB, highWater int64) (date, timeOfDay string, err error) {
+	var detection rowDetection
+	err = sourceDB.Model(&Detection{}).Select("CAST(date AS TEXT) AS raw_date, time").
+		Where("rowid <= ?", highWater).Order("date DESC, time DESC").Limit(1).Find(&detection).Error
+	return detection.RawDate, detection.Time, err
+}
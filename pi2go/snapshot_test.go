@@ -0,0 +1,119 @@
+package pi2go
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupSnapshotSourceDB creates a BirdNET-Pi style source database with the given detections
+func setupSnapshotSourceDB(t *testing.T, detections []Detection) (db *gorm.DB, dbPath string) {
+	t.Helper()
+
+	dbPath = filepath.Join(t.TempDir(), "birds.db")
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to create source database: %v", err)
+	}
+	t.Cleanup(func() { closeDB(db) })
+
+	createMockDetectionTable(t, db)
+	for i := range detections {
+		insertMockDetection(t, db, &detections[i])
+	}
+
+	return db, dbPath
+}
+
+func TestCreateSourceSnapshot(t *testing.T) {
+	t.Parallel()
+
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", FileName: "b.mp3"},
+		{Date: "2023-01-15", Time: "12:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", FileName: "c.mp3"},
+	})
+
+	snap, err := createSourceSnapshot(sourceDBPath)
+	if err != nil {
+		t.Fatalf("createSourceSnapshot() error = %v", err)
+	}
+	defer snap.Cleanup()
+
+	if snap.HighWaterRowID != 3 {
+		t.Errorf("createSourceSnapshot() HighWaterRowID = %d, want 3", snap.HighWaterRowID)
+	}
+
+	// Rows inserted after the snapshot must not appear in it
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-01-15", Time: "13:00:00", SciName: "Sitta europaea", FileName: "d.mp3"})
+
+	highWater, err := detectionsHighWaterRowID(snap.Path)
+	if err != nil {
+		t.Fatalf("detectionsHighWaterRowID() error = %v", err)
+	}
+	if highWater != 3 {
+		t.Errorf("snapshot high-water mark changed to %d after source insert, want 3", highWater)
+	}
+
+	if _, err := createSourceSnapshot(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Errorf("createSourceSnapshot() with missing source did not return an error")
+	}
+}
+
+func TestMigrationState(t *testing.T) {
+	t.Parallel()
+
+	db, _ := setupTestDB(t)
+
+	state, err := loadMigrationState(db, "/data/birds.db")
+	if err != nil {
+		t.Fatalf("loadMigrationState() error = %v", err)
+	}
+	if state != nil {
+		t.Fatalf("loadMigrationState() on empty target = %+v, want nil", state)
+	}
+
+	for _, rowID := range []int64{10, 25} {
+		if err := saveMigrationState(db, &MigrationState{Source: "/data/birds.db", LastRowID: rowID}); err != nil {
+			t.Fatalf("saveMigrationState() error = %v", err)
+		}
+	}
+
+	state, err = loadMigrationState(db, "/data/birds.db")
+	if err != nil {
+		t.Fatalf("loadMigrationState() error = %v", err)
+	}
+	if state == nil || state.LastRowID != 25 {
+		t.Errorf("loadMigrationState() = %+v, want LastRowID 25", state)
+	}
+}
+
+func TestConvertAndTransferDataIncrementalSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "12:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "b.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: true})
+	verifyNoteCount(t, targetDBPath, 2)
+
+	// A detection with the same timestamp as the last migrated one arrives later; resuming
+	// by date and time alone would skip it, the recorded high-water mark must not.
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-01-15", Time: "12:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.7, FileName: "c.mp3"})
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-01-16", Time: "08:00:00", SciName: "Sitta europaea", ComName: "Eurasian Nuthatch", Confidence: 0.75, FileName: "d.mp3"})
+
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: true})
+	verifyNoteCount(t, targetDBPath, 4)
+
+	// Running again without new detections must not duplicate anything
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: true})
+	verifyNoteCount(t, targetDBPath, 4)
+}
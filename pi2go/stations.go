@@ -0,0 +1,84 @@
+// file stations.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// MergeSource is a further database OperationMerge merges after Options.SourceDBPath, so the
+// records of several stations are consolidated into one target in a single run.
+type MergeSource struct {
+	DBPath   string    `json:"db"`                 // BirdNET-Go or BirdNET-Pi database on the local filesystem
+	FilesDir string    `json:"dir,omitempty"`      // Clips of the source, empty to merge only the database
+	Station  string    `json:"station,omitempty"`  // Tags the notes merged from the source, empty to keep their tags
+	Location *Location `json:"location,omitempty"` // Replaces the latitude and longitude of the merged notes
+}
+
+// Location is where a station recorded its detections.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// validateMergeSources checks the further sources of a merge in opts.
+func validateMergeSources(opts Options) error {
+	if len(opts.Sources) == 0 {
+		return nil
+	}
+	if opts.Operation != OperationMerge {
+		return fmt.Errorf("further sources cannot be given for %s operation, only for merge", opts.Operation)
+	}
+
+	seen := map[string]bool{filepath.Clean(opts.TargetDBPath): true}
+	if opts.Source == "" && opts.SourceDBPath != "" {
+		seen[filepath.Clean(opts.SourceDBPath)] = true
+	}
+	for _, source := range opts.Sources {
+		switch {
+		case source.DBPath == "":
+			return errors.New("a database is required for every source")
+		case isArchivePath(source.DBPath) || isArchivePath(source.FilesDir):
+			return fmt.Errorf("source %s: further sources cannot be backup archives", source.DBPath)
+		case seen[filepath.Clean(source.DBPath)]:
+			return fmt.Errorf("source %s is given more than once, or is the target", source.DBPath)
+		}
+		seen[filepath.Clean(source.DBPath)] = true
+	}
+	return nil
+}
+
+// mergeSourceList returns the sources a merge merges, the one in Options first.
+func (m *migration) mergeSourceList() []MergeSource {
+	sources := []MergeSource{{
+		DBPath:   m.opts.SourceDBPath,
+		FilesDir: m.opts.SourceFilesDir,
+		Station:  m.opts.Station,
+		Location: m.opts.Location,
+	}}
+	return append(sources, m.opts.Sources...)
+}
+
+// mergeSources merges every source of the run into the target in turn.
+func (m *migration) mergeSources() error {
+	sourceDBPath, sourceFilesDir := m.opts.SourceDBPath, m.opts.SourceFilesDir
+	defer func() {
+		m.opts.SourceDBPath, m.opts.SourceFilesDir, m.source = sourceDBPath, sourceFilesDir, MergeSource{}
+	}()
+
+	for i, source := range m.mergeSourceList() {
+		if i > 0 && m.cancelled() {
+			return m.ctx.Err()
+		}
+
+		m.opts.SourceDBPath, m.opts.SourceFilesDir, m.source = source.DBPath, source.FilesDir, source
+		if err := m.mergeDatabases(source.DBPath, m.opts.TargetDBPath); err != nil {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("failed to merge %s: %w", source.DBPath, err)
+		}
+	}
+	return nil
+}
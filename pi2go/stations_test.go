@@ -0,0 +1,146 @@
+package pi2go
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestValidateMergeSources(t *testing.T) {
+	t.Parallel()
+
+	valid := Options{Operation: OperationMerge, SourceDBPath: "station1.db", TargetDBPath: "birdnet.db"}
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		wantErr string
+	}{
+		{name: "No further sources", modify: func(o *Options) {}},
+		{name: "Two further sources", modify: func(o *Options) {
+			o.Sources = []MergeSource{{DBPath: "station2.db", Station: "lake"}, {DBPath: "station3.db", FilesDir: "station3/clips"}}
+		}},
+		{name: "Not a merge", modify: func(o *Options) {
+			o.Operation, o.Sources = OperationCopy, []MergeSource{{DBPath: "station2.db"}}
+		}, wantErr: "only for merge"},
+		{name: "No database", modify: func(o *Options) { o.Sources = []MergeSource{{FilesDir: "clips2"}} }, wantErr: "database is required"},
+		{name: "Archive", modify: func(o *Options) { o.Sources = []MergeSource{{DBPath: "backup.tar.gz"}} }, wantErr: "archives"},
+		{name: "Same as the first source", modify: func(o *Options) { o.Sources = []MergeSource{{DBPath: "./station1.db"}} }, wantErr: "more than once"},
+		{name: "Same as the target", modify: func(o *Options) { o.Sources = []MergeSource{{DBPath: "birdnet.db"}} }, wantErr: "more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := valid
+			tt.modify(&opts)
+			err := validateMergeSources(opts)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateMergeSources() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// stationsByTime returns the station tag and location of the notes in the database at dbPath
+// by their time.
+func stationsByTime(t *testing.T, dbPath string) map[string]string {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer closeDB(db)
+
+	var rows []struct {
+		Time      string
+		Station   string
+		Latitude  float64
+		Longitude float64
+	}
+	err = db.Raw(`SELECT notes.time, COALESCE(pi2go_note_metadata.station, '') AS station, notes.latitude, notes.longitude
+		FROM notes LEFT JOIN pi2go_note_metadata ON pi2go_note_metadata.note_id = notes.id`).Scan(&rows).Error
+	if err != nil {
+		t.Fatalf("Failed to read stations: %v", err)
+	}
+
+	stations := make(map[string]string, len(rows))
+	for _, row := range rows {
+		stations[row.Time] = strings.TrimSpace(fmt.Sprintf("%s %g,%g", row.Station, row.Latitude, row.Longitude))
+	}
+	return stations
+}
+
+func TestMergeStations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	gardenDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "b.mp3"},
+	})
+	lakeDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "12:00:00", SciName: "Gavia arctica", ComName: "Arctic Loon", Confidence: 0.7, Lat: 61.5, Lon: 23.8, FileName: "c.mp3"},
+	})
+	untaggedDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "13:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.6, Lat: 62, Lon: 25, FileName: "d.mp3"},
+	})
+
+	tempDir := t.TempDir()
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	result := runMigration(t, Options{
+		Operation:    OperationMerge,
+		SourceDBPath: gardenDBPath,
+		TargetDBPath: targetDBPath,
+		Station:      "garden",
+		Location:     &Location{Latitude: 60.1, Longitude: 24.9},
+		Sources:      []MergeSource{{DBPath: lakeDBPath, Station: "lake"}, {DBPath: untaggedDBPath}},
+	})
+	if result.NotesInserted != 4 || result.SourceRows != 4 || result.Total != 4 {
+		t.Errorf("merge of three stations = %+v, want 4 notes of 4 source rows", result.Counts)
+	}
+
+	want := map[string]string{
+		"10:00:00": "garden 60.1,24.9",
+		"11:00:00": "garden 60.1,24.9",
+		"12:00:00": "lake 61.5,23.8",
+		"13:00:00": "62,25",
+	}
+	got := stationsByTime(t, targetDBPath)
+	for timeOfDay, station := range want {
+		if got[timeOfDay] != station {
+			t.Errorf("note at %s = %q, want %q", timeOfDay, got[timeOfDay], station)
+		}
+	}
+
+	// Merging the consolidated database keeps the tags, unless they are replaced
+	consolidatedDBPath := filepath.Join(tempDir, "consolidated.db")
+	runMigration(t, Options{Operation: OperationMerge, SourceDBPath: targetDBPath, TargetDBPath: consolidatedDBPath})
+	if got := stationsByTime(t, consolidatedDBPath); got["12:00:00"] != "lake 61.5,23.8" || got["13:00:00"] != "62,25" {
+		t.Errorf("merge of a tagged database = %v, want the tags kept", got)
+	}
+	retaggedDBPath := filepath.Join(tempDir, "retagged.db")
+	runMigration(t, Options{Operation: OperationMerge, SourceDBPath: targetDBPath, TargetDBPath: retaggedDBPath, Station: "north"})
+	if got := stationsByTime(t, retaggedDBPath); got["12:00:00"] != "north 61.5,23.8" {
+		t.Errorf("merge of a tagged database as a station = %v, want the tags replaced", got)
+	}
+
+	// A rollback removes the tags with the notes
+	runMigration(t, Options{Operation: OperationRollback, TargetDBPath: targetDBPath})
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+	var tags int64
+	if err := db.Model(&NoteMetadata{}).Count(&tags).Error; err != nil || tags != 0 {
+		t.Errorf("%d station tags left after rollback, %v", tags, err)
+	}
+}
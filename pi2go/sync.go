@@ -0,0 +1,329 @@
+// file sync.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// syncStatePrefix distinguishes the sync cursor from the state recorded by one-off migrations.
+	syncStatePrefix = "sync:"
+
+	// syncBatchSize is the number of detections inserted per transaction during sync.
+	syncBatchSize = 1000
+
+	// maxClipRetries is how many polls a missing clip is retried before it is given up on.
+	// BirdNET-Pi may still be writing a clip when its detection becomes visible.
+	maxClipRetries = 5
+)
+
+// pendingClip is the clip of a synced detection, or of one migrated from a sequential source,
+// that has not been transferred yet. Pending clips are stored in the target with the notes of
+// their batch, so a restarted run still transfers those its cursor has moved past.
+type pendingClip struct {
+	ID         uint   `gorm:"primaryKey"`
+	Source     string `gorm:"index"` // Sync state or checkpoint key of the source
+	Run        string `gorm:"index"` // Run that inserted the note of the clip, for a rollback
+	Date       string
+	Time       string
+	SciName    string
+	ComName    string
+	Confidence float64
+	FileName   string
+	Attempts   int // Polls the clip was missing in the source at
+}
+
+// TableName overrides the default table name.
+func (pendingClip) TableName() string {
+	return "pi2go_sync_pending_clips"
+}
+
+// newPendingClip returns the pending clip of detection, converted as its note was by run.
+func newPendingClip(source, run string, detection *Detection) pendingClip {
+	return pendingClip{
+		Source:     source,
+		Run:        run,
+		Date:       detection.Date,
+		Time:       detection.Time,
+		SciName:    detection.SciName,
+		ComName:    detection.ComName,
+		Confidence: detection.Confidence,
+		FileName:   detection.FileName,
+	}
+}
+
+// detection returns the detection of the clip, as needed to transfer it.
+func (p *pendingClip) detection() Detection {
+	return Detection{Date: p.Date, Time: p.Time, SciName: p.SciName, ComName: p.ComName, Confidence: p.Confidence, FileName: p.FileName}
+}
+
+// syncer incrementally copies new detections and clips from a running BirdNET-Pi into a running BirdNET-Go.
+type syncer struct {
+	sourceDB          *gorm.DB
+	targetDB          *gorm.DB
+	sourceFilesDir    string
+	targetFilesDir    string
+	skipAudioTransfer bool
+	fs                FileSystem
+	logger            *log.Logger
+	stateKey          string
+	cursor            int64
+	pending           []pendingClip
+	run               *migration // Counts synced notes and clips, may be nil
+}
+
+// runSync polls the source database every interval and pushes new detections and clips into
+// the target until the run is cancelled. Clips are always copied, as BirdNET-Pi keeps using them.
+func (m *migration) runSync() error {
+	opts := m.opts
+	s, err := newSyncer(opts.SourceDBPath, opts.TargetDBPath, opts.SourceFilesDir, opts.TargetFilesDir, opts.SkipAudioTransfer, m.fileSystem(), m.logger())
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	s.run = m
+
+	s.logger.Printf("Syncing %s into %s every %v, starting after detections rowid %d",
+		opts.SourceDBPath, opts.TargetDBPath, opts.SyncInterval, s.cursor)
+
+	ticker := time.NewTicker(opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.poll(); err != nil {
+			s.logger.Printf("Sync poll failed: %v", err)
+		}
+		m.mu.Lock()
+		m.result.LastRowID = s.cursor
+		m.mu.Unlock()
+		m.report(StageSyncing)
+
+		select {
+		case <-m.ctx.Done():
+			s.logger.Printf("Sync stopped after detections rowid %d", s.cursor)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// openLiveTargetDB opens the target database of a sync and creates the tables it writes.
+// BirdNET-Go is writing to the target concurrently, so its journal mode and durability settings
+// are kept and its locks waited for instead of failing.
+func openLiveTargetDB(targetDBPath string, newLogger logger.Interface) (*gorm.DB, error) {
+	targetDB, err := gorm.Open(sqlite.Open("file:"+targetDBPath+"?_pragma=busy_timeout(5000)"), &gorm.Config{Logger: newLogger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target database: %w", err)
+	}
+
+	if err := targetDB.AutoMigrate(&Note{}, &NoteMetadata{}, &pendingClip{}); err != nil {
+		closeDB(targetDB)
+		return nil, fmt.Errorf("failed to migrate target database: %w", err)
+	}
+	return targetDB, nil
+}
+
+// newSyncer opens the source and target databases and loads the persisted sync cursor.
+func newSyncer(sourceDBPath, targetDBPath, sourceFilesDir, targetFilesDir string, skipAudioTransfer bool, fs FileSystem, logger *log.Logger) (*syncer, error) {
+	newLogger := createGormLogger(logger)
+
+	sourceDB, err := openLiveSourceDB(sourceDBPath, newLogger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+
+	targetDB, err := openLiveTargetDB(targetDBPath, newLogger)
+	if err != nil {
+		closeDB(sourceDB)
+		return nil, err
+	}
+
+	s := &syncer{
+		sourceDB:          sourceDB,
+		targetDB:          targetDB,
+		sourceFilesDir:    sourceFilesDir,
+		targetFilesDir:    targetFilesDir,
+		skipAudioTransfer: skipAudioTransfer,
+		fs:                fs,
+		logger:            logger,
+		stateKey:          syncStatePrefix + migrationStateKey(sourceDBPath),
+	}
+
+	if err := s.loadCursor(sourceDBPath); err != nil {
+		s.close()
+		return nil, err
+	}
+	if err := targetDB.Where("source = ?", s.stateKey).Order("id").Find(&s.pending).Error; err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to load pending clips: %w", err)
+	}
+
+	return s, nil
+}
+
+// loadCursor restores the sync cursor. A first sync continues from the high-water mark of
+// a previous snapshot migration of the same source, or starts from the beginning.
+func (s *syncer) loadCursor(sourceDBPath string) error {
+	state, err := loadMigrationState(s.targetDB, s.stateKey)
+	if err != nil {
+		return fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	if state == nil {
+		state, err = loadMigrationState(s.targetDB, migrationStateKey(sourceDBPath))
+		if err != nil {
+			return fmt.Errorf("failed to load migration state: %w", err)
+		}
+	}
+
+	if state != nil {
+		s.cursor = state.LastRowID
+	}
+
+	return nil
+}
+
+// close releases both database connections.
+func (s *syncer) close() {
+	closeDB(s.sourceDB)
+	closeDB(s.targetDB)
+}
+
+// poll transfers all detections added since the last poll and retries clips that were missing.
+func (s *syncer) poll() error {
+	var highWater int64
+	if err := s.sourceDB.Raw("SELECT COALESCE(MAX(rowid), 0) FROM detections").Scan(&highWater).Error; err != nil {
+		return fmt.Errorf("failed to read source high-water mark: %w", err)
+	}
+
+	s.transferPendingClips()
+
+	// Stop between batches when the run is cancelled, the cursor is already persisted
+	synced := 0
+	for s.cursor < highWater && !s.run.cancelled() {
+		var batch []rowDetection
+		err := s.sourceDB.Raw("SELECT rowid, * FROM detections WHERE rowid > ? AND rowid <= ? ORDER BY rowid LIMIT ?",
+			s.cursor, highWater, syncBatchSize).Scan(&batch).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch new detections: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		if err := s.insertBatch(batch); err != nil {
+			return err
+		}
+		synced += len(batch)
+		s.transferPendingClips()
+	}
+
+	if synced > 0 || len(s.pending) > 0 {
+		s.logger.Printf("Synced %d new detections, %d clips pending", synced, len(s.pending))
+	}
+
+	return nil
+}
+
+// insertBatch converts and inserts a batch of detections, queues their clips and advances the
+// persisted cursor in the same transaction, so a crash never loses or duplicates a detection
+// or its clip.
+func (s *syncer) insertBatch(batch []rowDetection) error {
+	last := batch[len(batch)-1]
+
+	notes := make([]Note, len(batch))
+	var clips []pendingClip
+	err := s.targetDB.Transaction(func(tx *gorm.DB) error {
+		clips = nil
+		for i := range batch {
+			// Convert a copy, convertDetectionToNote normalizes the date in place
+			detection := batch[i].Detection
+			note := s.run.convertDetection(&detection)
+			s.run.applyOverrides(&note, "")
+			if !s.skipAudioTransfer {
+				clips = append(clips, newPendingClip(s.stateKey, s.run.id(), &detection))
+			}
+			if err := tx.Create(&note).Error; err != nil {
+				return fmt.Errorf("failed to insert note: %w", err)
+			}
+			if err := saveMetadata(tx, &note, detectionMetadata(&detection, "")); err != nil {
+				return fmt.Errorf("failed to save note metadata: %w", err)
+			}
+			s.run.recordManifest(&note, clipTransfer{})
+			notes[i] = note
+		}
+		if len(clips) > 0 {
+			if err := tx.Create(&clips).Error; err != nil {
+				return fmt.Errorf("failed to queue clips: %w", err)
+			}
+		}
+		s.run.syncManifest()
+
+		state := &MigrationState{Source: s.stateKey, LastRowID: last.RowID, LastDate: last.Date, LastTime: last.Time}
+		return saveMigrationState(tx, state)
+	})
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, clips...)
+
+	for i := range notes {
+		s.run.recordNote(notes[i].ScientificName, notes[i].CommonName, nil)
+	}
+	s.cursor = last.RowID
+	return nil
+}
+
+// transferClip copies a pending clip. It reports whether the clip is done with: transferred,
+// failed, or given up on after missing from the source at maxClipRetries polls.
+func (s *syncer) transferClip(p *pendingClip) bool {
+	detection := p.detection()
+	transfer, err := transferClipWithFS(&detection, s.sourceFilesDir, s.targetFilesDir, CopyFile, s.fs)
+	if err == nil || !errors.Is(err, errSourceFileNotFound) {
+		s.run.recordClip(&detection, transfer, err)
+		s.run.recordManifest(nil, transfer)
+		s.run.report(StageSyncing)
+		return true
+	}
+
+	p.Attempts++
+	if p.Attempts >= maxClipRetries {
+		s.logger.Printf("Giving up on clip for %s %s %s after %d attempts", detection.Date, detection.Time, detection.ComName, p.Attempts)
+		s.run.recordClip(&detection, clipTransfer{}, err)
+		s.run.report(StageSyncing)
+		return true
+	}
+	return false
+}
+
+// transferPendingClips attempts to transfer the pending clips, keeping those still missing
+// from the source for the next poll. A sync skipping audio transfer leaves them queued.
+func (s *syncer) transferPendingClips() {
+	if s.skipAudioTransfer {
+		return
+	}
+
+	pending := s.pending
+	s.pending = nil
+
+	for i := range pending {
+		p := &pending[i]
+		var err error
+		if s.transferClip(p) {
+			err = s.targetDB.Delete(p).Error
+		} else {
+			err = s.targetDB.Model(p).Update("attempts", p.Attempts).Error
+			s.pending = append(s.pending, *p)
+		}
+		if err != nil {
+			s.logger.Printf("Failed to update pending clip %d: %v", p.ID, err)
+		}
+	}
+}
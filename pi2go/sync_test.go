@@ -0,0 +1,285 @@
+package pi2go
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSyncerPoll(t *testing.T) {
+	t.Parallel()
+
+	sourceDB, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+
+	mockFS := NewMockFS()
+	sourceDir, targetDir := "/birdsongs", "/clips"
+	writeSourceClip := func(d *Detection) {
+		path := filepath.Join(sourceDir, "Extracted", "By_Date", d.Date, d.ComName, d.FileName)
+		mockFS.MkdirAll(filepath.Dir(path), 0o755)
+		mockFS.WriteFile(path, []byte("audio"), 0o644)
+	}
+	writeSourceClip(&Detection{Date: "2023-01-15", ComName: "Common Raven", FileName: "raven.mp3"})
+
+	s, err := newSyncer(sourceDBPath, targetDBPath, sourceDir, targetDir, false, mockFS, log.Default())
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if s.cursor != 1 {
+		t.Errorf("cursor after first poll = %d, want 1", s.cursor)
+	}
+	if !mockFS.FileExists(filepath.Join(targetDir, "2023", "01", "corvus_corax_90p_20230115T100000Z.mp3")) {
+		t.Errorf("clip of first detection was not copied")
+	}
+
+	// A new detection arrives before its clip has been written
+	tit := &Detection{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "tit.mp3"}
+	insertMockDetection(t, sourceDB, tit)
+
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if len(s.pending) != 1 {
+		t.Fatalf("pending clips = %d, want 1", len(s.pending))
+	}
+
+	// Once the clip shows up it is transferred on the next poll
+	writeSourceClip(tit)
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("pending clips = %d, want 0", len(s.pending))
+	}
+	if !mockFS.FileExists(filepath.Join(targetDir, "2023", "01", "parus_major_80p_20230115T110000Z.mp3")) {
+		t.Errorf("clip of late detection was not copied")
+	}
+	s.close()
+
+	// Notes written by BirdNET-Go itself must not affect the sync cursor
+	targetDB, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	if err := targetDB.Create(&Note{Date: "2023-06-01", Time: "12:00:00", ScientificName: "Live note"}).Error; err != nil {
+		t.Fatalf("Failed to insert live note: %v", err)
+	}
+	closeDB(targetDB)
+
+	// A restarted syncer resumes from its persisted cursor
+	insertMockDetection(t, sourceDB, &Detection{Date: "2023-01-14", Time: "09:00:00", SciName: "Pica pica", ComName: "Eurasian Magpie", Confidence: 0.7, FileName: "magpie.mp3"})
+
+	s, err = newSyncer(sourceDBPath, targetDBPath, sourceDir, targetDir, true, mockFS, log.Default())
+	if err != nil {
+		t.Fatalf("newSyncer() after restart error = %v", err)
+	}
+	if s.cursor != 2 {
+		t.Errorf("cursor after restart = %d, want 2", s.cursor)
+	}
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	s.close()
+
+	verifyNoteCount(t, targetDBPath, 4)
+}
+
+func TestSyncerStartsFromMigrationState(t *testing.T) {
+	t.Parallel()
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", FileName: "b.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+
+	// A snapshot migration records its high-water mark, which the first sync continues from
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true, Snapshot: true})
+
+	s, err := newSyncer(sourceDBPath, targetDBPath, "", "", true, NewMockFS(), log.Default())
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+	defer s.close()
+
+	if s.cursor != 2 {
+		t.Errorf("initial sync cursor = %d, want 2", s.cursor)
+	}
+
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	s.close()
+
+	verifyNoteCount(t, targetDBPath, 2)
+}
+
+func TestSyncerResumesPendingClips(t *testing.T) {
+	t.Parallel()
+
+	_, sourceDBPath := setupSnapshotSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	mockFS := NewMockFS()
+	sourceDir, targetDir := "/birdsongs", "/clips"
+
+	// The clip is not written yet when the detection is synced
+	s, err := newSyncer(sourceDBPath, targetDBPath, sourceDir, targetDir, false, mockFS, log.Default())
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if s.cursor != 1 || len(s.pending) != 1 {
+		t.Fatalf("cursor = %d with %d pending clips, want 1 and 1", s.cursor, len(s.pending))
+	}
+	s.close()
+
+	// A restarted syncer still transfers it, although the cursor moved past its detection
+	clipPath := filepath.Join(sourceDir, "Extracted", "By_Date", "2023-01-15", "Common Raven", "raven.mp3")
+	mockFS.MkdirAll(filepath.Dir(clipPath), 0o755)
+	mockFS.WriteFile(clipPath, []byte("audio"), 0o644)
+
+	s, err = newSyncer(sourceDBPath, targetDBPath, sourceDir, targetDir, false, mockFS, log.Default())
+	if err != nil {
+		t.Fatalf("newSyncer() after restart error = %v", err)
+	}
+	defer s.close()
+	if len(s.pending) != 1 || s.pending[0].Attempts != 1 {
+		t.Fatalf("pending clips after restart = %+v, want 1 missed once", s.pending)
+	}
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if !mockFS.FileExists(filepath.Join(targetDir, "2023", "01", "corvus_corax_90p_20230115T100000Z.mp3")) {
+		t.Errorf("pending clip was not copied after restart")
+	}
+
+	var queued int64
+	if err := s.targetDB.Model(&pendingClip{}).Count(&queued).Error; err != nil || queued != 0 {
+		t.Errorf("%d clips left queued in the target, %v", queued, err)
+	}
+}
+
+func TestSyncWhileTargetInUse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("Skipping open file check without /proc")
+	}
+
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Corvus corax", ComName: "Common Raven", Confidence: 0.9, FileName: "raven.mp3"},
+	})
+	targetDBPath := filepath.Join(t.TempDir(), "birdnet.db")
+	insertTestNotes(t, targetDBPath, 1)
+
+	// Another process, as BirdNET-Go does, holds a connection to the target database in WAL mode
+	holder := exec.Command(os.Args[0], "-test.run=^TestHoldTargetOpen$")
+	holder.Env = append(os.Environ(), holdTargetEnv+"="+targetDBPath)
+	stdout, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to read holder output: %v", err)
+	}
+	if err := holder.Start(); err != nil {
+		t.Skipf("Cannot start a process: %v", err)
+	}
+	defer func() {
+		holder.Process.Kill()
+		holder.Wait()
+	}()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "ready\n" {
+		t.Fatalf("holder process output = %q, %v, want ready", line, err)
+	}
+
+	opts := Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SkipAudioTransfer: true}
+	m, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.Run(context.Background()); !errors.Is(err, ErrTargetInUse) {
+		t.Fatalf("copy into the open target error = %v, want %v", err, ErrTargetInUse)
+	}
+
+	// Sync runs next to it, stopped after its first poll
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts.Operation = OperationSync
+	opts.SyncInterval = time.Hour
+	opts.Progress = func(p Progress) {
+		if p.Stage == StageSyncing {
+			cancel()
+		}
+	}
+	m, err = New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := m.Run(ctx)
+	if err != nil {
+		t.Fatalf("sync into the open target error = %v", err)
+	}
+	if result.NotesInserted != 1 {
+		t.Errorf("sync inserted %d notes, want 1", result.NotesInserted)
+	}
+	verifyNoteCount(t, targetDBPath, 2)
+
+	// The journal mode BirdNET-Go set is left as it is
+	db, err := gorm.Open(sqlite.Open(targetDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	defer closeDB(db)
+	var mode string
+	if err := db.Raw("PRAGMA journal_mode").Scan(&mode).Error; err != nil || mode != "wal" {
+		t.Errorf("journal mode after sync = %q, %v, want wal", mode, err)
+	}
+}
+
+// holdTargetEnv names the database TestHoldTargetOpen holds open.
+const holdTargetEnv = "PI2GO_HOLD_TARGET"
+
+// TestHoldTargetOpen is run by TestSyncWhileTargetInUse in a process of its own, keeping a
+// connection to the target database in WAL mode as BirdNET-Go does, until it is killed.
+func TestHoldTargetOpen(t *testing.T) {
+	path := os.Getenv(holdTargetEnv)
+	if path == "" {
+		t.Skip("Helper process of TestSyncWhileTargetInUse")
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open target database: %v", err)
+	}
+	var mode string
+	if err := db.Raw("PRAGMA journal_mode = WAL").Scan(&mode).Error; err != nil || mode != "wal" {
+		t.Fatalf("journal mode = %q, %v, want wal", mode, err)
+	}
+	var notes int64
+	if err := db.Model(&Note{}).Count(&notes).Error; err != nil {
+		t.Fatalf("Failed to read target database: %v", err)
+	}
+
+	fmt.Println("ready")
+	time.Sleep(time.Minute)
+}
@@ -0,0 +1,220 @@
+// file taxonomy.go
+package pi2go
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundledTaxonomy maps scientific names older BirdNET label files used to those of the labels
+// BirdNET-Go uses, so notes of a species are not split under two names.
+//
+//go:embed taxonomy.csv
+var bundledTaxonomy []byte
+
+// taxon is the scientific name a remapping replaces a recorded one with, and the common name
+// replacing the recorded one, empty to keep it.
+type taxon struct {
+	scientificName string
+	commonName     string
+}
+
+// TaxonRemap is the number of source records whose species names were remapped from one
+// scientific name.
+type TaxonRemap struct {
+	From       string `json:"from"`        // Scientific name as recorded
+	To         string `json:"to"`          // Scientific name of the notes
+	CommonName string `json:"common_name"` // Common name of the notes
+	Notes      int    `json:"notes"`
+}
+
+// loadTaxonomy returns the remappings of opts by recorded scientific name: the bundled table,
+// then the rows of opts.TaxonomyPath taking precedence over it. It returns nil when remapping
+// is off.
+func loadTaxonomy(opts Options) (map[string]taxon, error) {
+	if !opts.RemapTaxonomy && opts.TaxonomyPath == "" {
+		return nil, nil
+	}
+
+	taxonomy := make(map[string]taxon)
+	if err := parseTaxonomy(bytes.NewReader(bundledTaxonomy), taxonomy); err != nil {
+		return nil, fmt.Errorf("bundled taxonomy: %w", err)
+	}
+	if opts.TaxonomyPath != "" {
+		f, err := os.Open(opts.TaxonomyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open taxonomy: %w", err)
+		}
+		defer f.Close()
+		if err := parseTaxonomy(f, taxonomy); err != nil {
+			return nil, fmt.Errorf("taxonomy %s: %w", filepath.Base(opts.TaxonomyPath), err)
+		}
+	}
+
+	// Follow names renamed more than once to the last one
+	for from, to := range taxonomy {
+		seen := map[string]bool{from: true}
+		for {
+			next, ok := taxonomy[to.scientificName]
+			if !ok || next.scientificName == to.scientificName {
+				break
+			}
+			if seen[to.scientificName] {
+				return nil, fmt.Errorf("taxonomy remaps %s in a cycle", from)
+			}
+			seen[to.scientificName] = true
+			if next.commonName != "" {
+				to.commonName = next.commonName
+			}
+			to.scientificName = next.scientificName
+		}
+		taxonomy[from] = to
+	}
+
+	// Rows remapping to the same names change nothing
+	for from, to := range taxonomy {
+		if to.scientificName == from && to.commonName == "" {
+			delete(taxonomy, from)
+		}
+	}
+	return taxonomy, nil
+}
+
+// parseTaxonomy adds the remappings in r to taxonomy. Rows are old_scientific_name,
+// scientific_name and an optional common_name; a header row and lines starting with # are
+// skipped.
+func parseTaxonomy(r io.Reader, taxonomy map[string]taxon) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "old_scientific_name") {
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 || len(record) > 3 {
+			return fmt.Errorf("line %d: expected old_scientific_name,scientific_name[,common_name]", line)
+		}
+		from, to := strings.TrimSpace(record[0]), taxon{scientificName: strings.TrimSpace(record[1])}
+		if len(record) == 3 {
+			to.commonName = strings.TrimSpace(record[2])
+		}
+		if from == "" || to.scientificName == "" {
+			return fmt.Errorf("line %d: scientific name is empty", line)
+		}
+		taxonomy[from] = to
+	}
+}
+
+// remapping returns the remapping of a recorded scientific name, if the run has one.
+func (m *migration) remapping(scientificName string) (taxon, bool) {
+	if m == nil {
+		return taxon{}, false
+	}
+	to, ok := m.taxonomy[scientificName]
+	return to, ok
+}
+
+// convertDetection converts detection into a Note as convertDetectionToNote does, with its
+// scientific name remapped first, so the clip is named after the remapped one, and its common
+// name remapped and translated. The recorded common name of detection is kept, the source clip
+// is found by it.
+func (m *migration) convertDetection(detection *Detection) Note {
+	from := detection.SciName
+	if to, ok := m.remapping(from); ok {
+		detection.SciName = to.scientificName
+	}
+	note := convertDetectionToNote(detection)
+	if _, err := time.Parse("2006-01-02", note.Date); err != nil {
+		m.logger().Printf("Error parsing date of detection %s %s: %v, using original value", note.Date, note.Time, err)
+	}
+	m.renameSpecies(&note, from)
+	return note
+}
+
+// remapNote remaps the species names of a merged note, renaming its clip after the remapped
+// scientific name, and translates its common name.
+func (m *migration) remapNote(note *Note) {
+	from := note.ScientificName
+	if to, ok := m.remapping(from); ok {
+		note.ClipName = renameClip(note.ClipName, from, to.scientificName)
+		note.ScientificName = to.scientificName
+	}
+	m.renameSpecies(note, from)
+}
+
+// renameSpecies sets the common name of note, recorded with the scientific name from, to that
+// of its remapping, if any, and then to its translation, and counts the remapping.
+func (m *migration) renameSpecies(note *Note, from string) {
+	to, ok := m.remapping(from)
+	if ok && to.commonName != "" {
+		note.CommonName = to.commonName
+	}
+	m.translate(note)
+	if ok {
+		m.recordRemap(from, note)
+	}
+}
+
+// renameClip returns clipName, named by GenerateClipName after the scientific name from,
+// named after to instead. Clip names of another form are returned unchanged.
+func renameClip(clipName, from, to string) string {
+	if clipName == "" || from == to {
+		return clipName
+	}
+	dir, name := filepath.Split(clipName)
+	prefix := formatClipSciName(from) + "_"
+	if !strings.HasPrefix(name, prefix) {
+		return clipName
+	}
+	return dir + formatClipSciName(to) + "_" + strings.TrimPrefix(name, prefix)
+}
+
+// recordRemap counts a note remapped from the scientific name from.
+func (m *migration) recordRemap(from string, note *Note) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.remapped == nil {
+		m.remapped = make(map[string]*TaxonRemap)
+	}
+	remap, ok := m.remapped[from]
+	if !ok {
+		remap = &TaxonRemap{From: from, To: note.ScientificName, CommonName: note.CommonName}
+		m.remapped[from] = remap
+	}
+	remap.Notes++
+	m.result.NotesRemapped++
+}
+
+// sortedRemaps returns the remapped names, by recorded scientific name. m.mu must be held.
+func (m *migration) sortedRemaps() []TaxonRemap {
+	if len(m.remapped) == 0 {
+		return nil
+	}
+	remaps := make([]TaxonRemap, 0, len(m.remapped))
+	for _, remap := range m.remapped {
+		remaps = append(remaps, *remap)
+	}
+	sort.Slice(remaps, func(i, j int) bool { return remaps[i].From < remaps[j].From })
+	return remaps
+}
@@ -0,0 +1,248 @@
+package pi2go
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoadTaxonomy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		csv     string // Content of TaxonomyPath, none if empty
+		remap   bool
+		want    map[string]taxon
+		wantErr string
+	}{
+		{name: "Off", want: nil},
+		{name: "Bundled", remap: true, want: map[string]taxon{
+			"Carduelis tristis": {"Spinus tristis", "American Goldfinch"},
+			"Parus caeruleus":   {"Cyanistes caeruleus", "Eurasian Blue Tit"},
+		}},
+		{
+			name: "User rows over the bundled ones",
+			csv:  "old_scientific_name,scientific_name,common_name\n# Local names\nParus caeruleus,Cyanistes caeruleus,Blue Tit\nPicus canus,Picus canus,Grey-headed Woodpecker\nPica pica,Pica pica\n",
+			want: map[string]taxon{
+				"Parus caeruleus":   {"Cyanistes caeruleus", "Blue Tit"},
+				"Picus canus":       {"Picus canus", "Grey-headed Woodpecker"},
+				"Pica pica":         {},
+				"Carduelis tristis": {"Spinus tristis", "American Goldfinch"},
+			},
+		},
+		{
+			name: "Renamed twice",
+			csv:  "Sylvia curruca,Curruca curruca,Lesser Whitethroat\nSylvia minula,Sylvia curruca\n",
+			want: map[string]taxon{"Sylvia minula": {"Curruca curruca", "Lesser Whitethroat"}},
+		},
+		{name: "Missing name", csv: "Parus major\n", wantErr: "line 1"},
+		{name: "Empty name", csv: "Parus major, \n", wantErr: "empty"},
+		{name: "Cycle", csv: "Parus major,Parus minor\nParus minor,Parus major\n", wantErr: "cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := Options{RemapTaxonomy: tt.remap}
+			if tt.csv != "" {
+				opts.TaxonomyPath = filepath.Join(t.TempDir(), "taxonomy.csv")
+				if err := os.WriteFile(opts.TaxonomyPath, []byte(tt.csv), 0o644); err != nil {
+					t.Fatalf("Failed to write taxonomy: %v", err)
+				}
+			}
+
+			taxonomy, err := loadTaxonomy(opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadTaxonomy() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTaxonomy() error = %v", err)
+			}
+			if tt.want == nil && taxonomy != nil {
+				t.Errorf("loadTaxonomy() = %d remappings, want none", len(taxonomy))
+			}
+			for from, want := range tt.want {
+				if got := taxonomy[from]; got != want {
+					t.Errorf("loadTaxonomy()[%q] = %+v, want %+v", from, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameClip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		clipName string
+		from, to string
+		want     string
+	}{
+		{"2023/01/carduelis_tristis_90p_20230115T100000Z.mp3", "Carduelis tristis", "Spinus tristis", "2023/01/spinus_tristis_90p_20230115T100000Z.mp3"},
+		{"2023/01/parus_caeruleus_90p_20230115T100000Z_1.wav", "Parus caeruleus", "Cyanistes caeruleus", "2023/01/cyanistes_caeruleus_90p_20230115T100000Z_1.wav"},
+		{"2023/01/recording.mp3", "Carduelis tristis", "Spinus tristis", "2023/01/recording.mp3"},
+		{"", "Carduelis tristis", "Spinus tristis", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clipName, func(t *testing.T) {
+			t.Parallel()
+			if got := renameClip(tt.clipName, tt.from, tt.to); got != tt.want {
+				t.Errorf("renameClip(%q) = %q, want %q", tt.clipName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemapTaxonomy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tempDir := t.TempDir()
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Carduelis tristis", ComName: "American Goldfinch", Confidence: 0.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Parus major", ComName: "Great Tit", Confidence: 0.8, FileName: "b.mp3"},
+	})
+	sourceDir := filepath.Join(tempDir, "BirdSongs")
+	clipPath := filepath.Join(sourceDir, "Extracted", "By_Date", "2023-01-15", "American_Goldfinch", "a.mp3")
+	if err := os.MkdirAll(filepath.Dir(clipPath), 0o755); err != nil {
+		t.Fatalf("Failed to create clip directory: %v", err)
+	}
+	if err := os.WriteFile(clipPath, []byte("goldfinch"), 0o644); err != nil {
+		t.Fatalf("Failed to write clip: %v", err)
+	}
+
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	targetDir := filepath.Join(tempDir, "clips")
+	result := runMigration(t, Options{
+		Operation:      OperationCopy,
+		SourceDBPath:   sourceDBPath,
+		SourceFilesDir: sourceDir,
+		TargetDBPath:   targetDBPath,
+		TargetFilesDir: targetDir,
+		RemapTaxonomy:  true,
+	})
+	if result.NotesInserted != 2 || result.NotesRemapped != 1 || result.ClipsTransferred != 1 {
+		t.Errorf("copy with remapping = %+v, want 2 notes inserted, 1 remapped and 1 clip", result.Counts)
+	}
+	if len(result.Remapped) != 1 || result.Remapped[0] != (TaxonRemap{From: "Carduelis tristis", To: "Spinus tristis", CommonName: "American Goldfinch", Notes: 1}) {
+		t.Errorf("Remapped = %+v, want Carduelis tristis to Spinus tristis", result.Remapped)
+	}
+
+	// The clip is named after the remapped species, where the note finds it
+	clipName := clipNamesByTime(t, targetDBPath)["10:00:00"]
+	if want := filepath.Join("2023", "01", "spinus_tristis_90p_20230115T100000Z.mp3"); clipName != want {
+		t.Errorf("clip name = %q, want %q", clipName, want)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, clipName)); err != nil {
+		t.Errorf("clip of the remapped note: %v", err)
+	}
+
+	// A merge remaps notes of a BirdNET-Go database and renames their clips
+	notesDBPath := filepath.Join(tempDir, "go.db")
+	notesDir := filepath.Join(tempDir, "goclips")
+	setupNotesDB(t, notesDBPath, []Note{
+		{Date: "2023-02-01", Time: "12:00:00", ScientificName: "Parus caeruleus", CommonName: "Blue Tit", Confidence: 0.7, ClipName: "2023/02/parus_caeruleus_70p_20230201T120000Z.mp3"},
+	}, notesDir, map[string]string{"2023/02/parus_caeruleus_70p_20230201T120000Z.mp3": "blue tit"})
+
+	taxonomyPath := filepath.Join(tempDir, "taxonomy.csv")
+	if err := os.WriteFile(taxonomyPath, []byte("Parus caeruleus,Cyanistes caeruleus\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write taxonomy: %v", err)
+	}
+	mergedDBPath := filepath.Join(tempDir, "merged.db")
+	mergedDir := filepath.Join(tempDir, "merged")
+	result = runMigration(t, Options{
+		Operation:      OperationMerge,
+		SourceDBPath:   notesDBPath,
+		SourceFilesDir: notesDir,
+		TargetDBPath:   mergedDBPath,
+		TargetFilesDir: mergedDir,
+		TaxonomyPath:   taxonomyPath,
+	})
+	if result.NotesInserted != 1 || result.NotesRemapped != 1 || result.ClipsTransferred != 1 {
+		t.Errorf("merge with remapping = %+v, want 1 note inserted, remapped and its clip", result.Counts)
+	}
+
+	db, err := gorm.Open(sqlite.Open(mergedDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open merged database: %v", err)
+	}
+	defer closeDB(db)
+	var note Note
+	if err := db.First(&note).Error; err != nil {
+		t.Fatalf("Failed to read note: %v", err)
+	}
+	if note.ScientificName != "Cyanistes caeruleus" || note.CommonName != "Blue Tit" ||
+		note.ClipName != "2023/02/cyanistes_caeruleus_70p_20230201T120000Z.mp3" {
+		t.Errorf("merged note = %s (%s) %s, want Cyanistes caeruleus with its clip renamed", note.ScientificName, note.CommonName, note.ClipName)
+	}
+	if content, err := os.ReadFile(filepath.Join(mergedDir, note.ClipName)); err != nil || string(content) != "blue tit" {
+		t.Errorf("renamed clip = %q, %v", content, err)
+	}
+}
+
+func TestRemapTaxonomyWithLocale(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// A German station, recorded with the older names
+	sourceDBPath := setupBirdNETPiSourceDB(t, []Detection{
+		{Date: "2023-01-15", Time: "10:00:00", SciName: "Parus caeruleus", ComName: "Blaumeise", Confidence: 0.9, FileName: "a.mp3"},
+		{Date: "2023-01-15", Time: "11:00:00", SciName: "Anas strepera", ComName: "Schnatterente", Confidence: 0.8, FileName: "b.mp3"},
+	})
+
+	tests := []struct {
+		name             string
+		locale           string
+		want             map[string]string
+		wantUntranslated []string
+	}{
+		{
+			name: "Common names of the taxonomy",
+			want: map[string]string{"Cyanistes caeruleus": "Eurasian Blue Tit", "Mareca strepera": "Gadwall"},
+		},
+		{
+			name:             "Translated after the remapping",
+			locale:           "de",
+			want:             map[string]string{"Cyanistes caeruleus": "Blaumeise", "Mareca strepera": "Gadwall"},
+			wantUntranslated: []string{"Mareca strepera"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := runMigration(t, Options{
+				Operation:         OperationCopy,
+				SourceDBPath:      sourceDBPath,
+				TargetDBPath:      filepath.Join(t.TempDir(), "birdnet.db"),
+				SkipAudioTransfer: true,
+				RemapTaxonomy:     true,
+				Locale:            tt.locale,
+			})
+			if result.NotesInserted != 2 || result.NotesRemapped != 2 {
+				t.Errorf("copy = %+v, want 2 notes inserted and remapped", result.Counts)
+			}
+			for _, species := range result.Species {
+				if species.CommonName != tt.want[species.ScientificName] {
+					t.Errorf("common name of %s = %q, want %q", species.ScientificName, species.CommonName, tt.want[species.ScientificName])
+				}
+			}
+			if !reflect.DeepEqual(result.Untranslated, tt.wantUntranslated) {
+				t.Errorf("Untranslated = %v, want %v", result.Untranslated, tt.wantUntranslated)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// file textlog.go
+package pi2go
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// BirdNET-Pi appends every detection to BirdDB.txt using these columns, in this order:
+// Date;Time;Sci_Name;Com_Name;Confidence;Lat;Lon;Cutoff;Week;Sens;Overlap
+// Some installations also append File_Name as a twelfth column.
+const (
+	birdDBTextFields         = 11
+	birdDBTextFieldsWithFile = 12
+	birdDBTextSeparator      = ";"
+
+	// defaultClipExtension is the audio format BirdNET-Pi uses unless configured otherwise.
+	defaultClipExtension = ".mp3"
+)
+
+// detectionsTableSchema is the BirdNET-Pi detections table definition.
+const detectionsTableSchema = `
+	CREATE TABLE IF NOT EXISTS detections (
+		Date DATE,
+		Time TIME,
+		Sci_Name VARCHAR(100) NOT NULL,
+		Com_Name VARCHAR(100) NOT NULL,
+		Confidence FLOAT,
+		Lat FLOAT,
+		Lon FLOAT,
+		Cutoff FLOAT,
+		Week INT,
+		Sens FLOAT,
+		Overlap FLOAT,
+		File_Name VARCHAR(100) NOT NULL
+	)`
+
+// readBirdDBText reads detections from a BirdNET-Pi BirdDB.txt log file.
+// Malformed lines are logged and skipped rather than aborting the import.
+func readBirdDBText(textPath string, logger *log.Logger) ([]Detection, error) {
+	file, err := os.Open(textPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open text log: %w", err)
+	}
+	defer file.Close()
+
+	var detections []Detection
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and the optional header row
+		if line == "" || strings.HasPrefix(line, "Date"+birdDBTextSeparator) {
+			continue
+		}
+
+		detection, err := parseBirdDBTextLine(line)
+		if err != nil {
+			logger.Printf("Skipping line %d of %s: %v", lineNumber, textPath, err)
+			continue
+		}
+
+		detections = append(detections, detection)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read text log: %w", err)
+	}
+
+	return detections, nil
+}
+
+// parseBirdDBTextLine parses a single BirdDB.txt line into a Detection.
+func parseBirdDBTextLine(line string) (Detection, error) {
+	fields := strings.Split(line, birdDBTextSeparator)
+	if len(fields) != birdDBTextFields && len(fields) != birdDBTextFieldsWithFile {
+		return Detection{}, fmt.Errorf("expected %d fields, got %d", birdDBTextFields, len(fields))
+	}
+
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	detection := Detection{
+		Date:    fields[0],
+		Time:    fields[1],
+		SciName: fields[2],
+		ComName: fields[3],
+	}
+
+	if detection.Date == "" || detection.Time == "" || detection.SciName == "" {
+		return Detection{}, fmt.Errorf("missing date, time or scientific name")
+	}
+
+	// Parse the numeric columns, keeping track of which column failed
+	floats := []struct {
+		name  string
+		value string
+		dest  *float64
+	}{
+		{"Confidence", fields[4], &detection.Confidence},
+		{"Lat", fields[5], &detection.Lat},
+		{"Lon", fields[6], &detection.Lon},
+		{"Cutoff", fields[7], &detection.Cutoff},
+		{"Sens", fields[9], &detection.Sens},
+		{"Overlap", fields[10], &detection.Overlap},
+	}
+
+	for _, f := range floats {
+		value, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return Detection{}, fmt.Errorf("invalid %s value %q", f.name, f.value)
+		}
+		*f.dest = value
+	}
+
+	week, err := strconv.Atoi(fields[8])
+	if err != nil {
+		return Detection{}, fmt.Errorf("invalid Week value %q", fields[8])
+	}
+	detection.Week = week
+
+	if len(fields) == birdDBTextFieldsWithFile && fields[11] != "" {
+		detection.FileName = fields[11]
+	} else {
+		detection.FileName = GenerateBirdNETPiFileName(&detection, defaultClipExtension)
+	}
+
+	return detection, nil
+}
+
+// isSourceDBUsable reports whether the source database can be opened and has a readable detections table.
+func isSourceDBUsable(sourceDBPath string) bool {
+	if _, err := os.Stat(sourceDBPath); err != nil {
+		return false
+	}
+
+	db, err := gorm.Open(sqlite.Open(sourceDBPath+"?mode=ro"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return false
+	}
+	defer closeDB(db)
+
+	var count int64
+	if err := db.Raw("SELECT COUNT(*) FROM detections").Scan(&count).Error; err != nil {
+		return false
+	}
+
+	return true
+}
+
+// buildTextLogSourceDB creates a BirdNET-Pi style database at outPath containing the detections
+// read from the BirdDB.txt log. When fillGaps is set, all rows of the source database are copied
+// first and only text log entries missing from it are added. It returns the number of rows
+// taken from the text log.
+func buildTextLogSourceDB(detections []Detection, sourceDBPath, outPath string, fillGaps bool) (int, error) {
+	db, err := gorm.Open(sqlite.Open(outPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create text log database: %w", err)
+	}
+	defer closeDB(db)
+
+	if err := db.Exec(detectionsTableSchema).Error; err != nil {
+		return 0, fmt.Errorf("failed to create detections table: %w", err)
+	}
+
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS detections_key ON detections (Date, Time, Sci_Name)").Error; err != nil {
+		return 0, fmt.Errorf("failed to create detections index: %w", err)
+	}
+
+	if fillGaps {
+		if err := db.Exec("ATTACH DATABASE ? AS src", sourceDBPath).Error; err != nil {
+			return 0, fmt.Errorf("failed to attach source database: %w", err)
+		}
+
+		err := db.Exec(`INSERT INTO detections (Date, Time, Sci_Name, Com_Name, Confidence, Lat, Lon, Cutoff, Week, Sens, Overlap, File_Name)
+			SELECT Date, Time, Sci_Name, Com_Name, Confidence, Lat, Lon, Cutoff, Week, Sens, Overlap, File_Name FROM src.detections`).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to copy source detections: %w", err)
+		}
+
+		if err := db.Exec("DETACH DATABASE src").Error; err != nil {
+			return 0, fmt.Errorf("failed to detach source database: %w", err)
+		}
+	}
+
+	added := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range detections {
+			d := &detections[i]
+			result := tx.Exec(`INSERT INTO detections (Date, Time, Sci_Name, Com_Name, Confidence, Lat, Lon, Cutoff, Week, Sens, Overlap, File_Name)
+				SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+				WHERE NOT EXISTS (SELECT 1 FROM detections WHERE Date = ? AND Time = ? AND Sci_Name = ?)`,
+				d.Date, d.Time, d.SciName, d.ComName, d.Confidence, d.Lat, d.Lon, d.Cutoff, d.Week, d.Sens, d.Overlap, d.FileName,
+				d.Date, d.Time, d.SciName)
+			if result.Error != nil {
+				return fmt.Errorf("failed to insert text log detection: %w", result.Error)
+			}
+			added += int(result.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, nil
+}
+
+// prepareTextLogSource returns the path of the database the migration should read from.
+// If textPath is empty the source database is used as is. Otherwise a temporary database is
+// built from the text log, merged with the source database when fillGaps is set and the
+// source database is usable. The returned cleanup function removes any temporary files.
+func prepareTextLogSource(sourceDBPath, textPath string, fillGaps bool, logger *log.Logger) (dbPath string, cleanup func(), err error) {
+	if textPath == "" {
+		return sourceDBPath, func() {}, nil
+	}
+
+	if fillGaps && !isSourceDBUsable(sourceDBPath) {
+		logger.Printf("Source database %s is not usable, importing from text log only", sourceDBPath)
+		fillGaps = false
+	}
+
+	detections, err := readBirdDBText(textPath, logger)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "birdnet-pi2go-textlog-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(tempDir) }
+
+	dbPath = filepath.Join(tempDir, "birds.db")
+	added, err := buildTextLogSourceDB(detections, sourceDBPath, dbPath, fillGaps)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if fillGaps {
+		logger.Printf("Text log filled %d detections missing from %s", added, sourceDBPath)
+	} else {
+		logger.Printf("Imported %d detections from text log %s", added, textPath)
+	}
+
+	return dbPath, cleanup, nil
+}
+
+// closeDB closes the connection pool behind a GORM handle.
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
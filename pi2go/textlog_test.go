@@ -0,0 +1,191 @@
+package pi2go
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseBirdDBTextLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		line     string
+		want     Detection
+		wantErr  bool
+		fileName string
+	}{
+		{
+			name: "Standard line",
+			line: "2023-01-15;13:45:30;Turdus migratorius;American Robin;0.8734;42.1;-71.4;0.7;3;1.25;0.0",
+			want: Detection{
+				Date: "2023-01-15", Time: "13:45:30", SciName: "Turdus migratorius", ComName: "American Robin",
+				Confidence: 0.8734, Lat: 42.1, Lon: -71.4, Cutoff: 0.7, Week: 3, Sens: 1.25, Overlap: 0.0,
+			},
+			fileName: "American_Robin-87-2023-01-15-birdnet-13:45:30.mp3",
+		},
+		{
+			name: "Line with file name column",
+			line: "2023-01-15;13:45:30;Corvus corax;Common Raven;0.95;42.1;-71.4;0.7;3;1.0;0.5;raven.wav",
+			want: Detection{
+				Date: "2023-01-15", Time: "13:45:30", SciName: "Corvus corax", ComName: "Common Raven",
+				Confidence: 0.95, Lat: 42.1, Lon: -71.4, Cutoff: 0.7, Week: 3, Sens: 1.0, Overlap: 0.5,
+			},
+			fileName: "raven.wav",
+		},
+		{
+			name:    "Too few fields",
+			line:    "2023-01-15;13:45:30;Corvus corax",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid confidence",
+			line:    "2023-01-15;13:45:30;Corvus corax;Common Raven;high;42.1;-71.4;0.7;3;1.0;0.5",
+			wantErr: true,
+		},
+		{
+			name:    "Missing scientific name",
+			line:    "2023-01-15;13:45:30;;Common Raven;0.9;42.1;-71.4;0.7;3;1.0;0.5",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseBirdDBTextLine(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseBirdDBTextLine() expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBirdDBTextLine() error = %v", err)
+			}
+
+			tt.want.FileName = tt.fileName
+			if got != tt.want {
+				t.Errorf("parseBirdDBTextLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBirdDBText(t *testing.T) {
+	t.Parallel()
+
+	textPath := filepath.Join(t.TempDir(), "BirdDB.txt")
+	content := strings.Join([]string{
+		"Date;Time;Sci_Name;Com_Name;Confidence;Lat;Lon;Cutoff;Week;Sens;Overlap",
+		"2023-01-15;13:45:30;Turdus migratorius;American Robin;0.87;42.1;-71.4;0.7;3;1.25;0.0",
+		"this line is garbage",
+		"",
+		"2023-01-16;08:00:00;Corvus corax;Common Raven;0.95;42.1;-71.4;0.7;3;1.25;0.0",
+	}, "\n")
+	if err := os.WriteFile(textPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write text log: %v", err)
+	}
+
+	detections, err := readBirdDBText(textPath, log.Default())
+	if err != nil {
+		t.Fatalf("readBirdDBText() error = %v", err)
+	}
+
+	if len(detections) != 2 {
+		t.Fatalf("readBirdDBText() returned %d detections, want 2", len(detections))
+	}
+
+	if detections[1].SciName != "Corvus corax" {
+		t.Errorf("readBirdDBText() second detection = %s, want Corvus corax", detections[1].SciName)
+	}
+
+	if _, err := readBirdDBText(filepath.Join(t.TempDir(), "missing.txt"), log.Default()); err == nil {
+		t.Errorf("readBirdDBText() with missing file did not return an error")
+	}
+}
+
+func TestPrepareTextLogSource(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	sourceDBPath := filepath.Join(tempDir, "birds.db")
+	textPath := filepath.Join(tempDir, "BirdDB.txt")
+
+	// The source database holds the first detection only
+	sourceDB, err := gorm.Open(sqlite.Open(sourceDBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to create source database: %v", err)
+	}
+	createMockDetectionTable(t, sourceDB)
+	insertMockDetection(t, sourceDB, &Detection{
+		Date: "2023-01-15", Time: "13:45:30", SciName: "Turdus migratorius", ComName: "American Robin",
+		Confidence: 0.87, FileName: "robin.mp3",
+	})
+	closeDB(sourceDB)
+
+	content := "2023-01-15;13:45:30;Turdus migratorius;American Robin;0.87;42.1;-71.4;0.7;3;1.25;0.0\n" +
+		"2023-01-16;08:00:00;Corvus corax;Common Raven;0.95;42.1;-71.4;0.7;3;1.25;0.0\n"
+	if err := os.WriteFile(textPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write text log: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		sourceDBPath string
+		fillGaps     bool
+		wantRows     int64
+		wantFileName string
+	}{
+		{"Text log only", sourceDBPath, false, 2, "American_Robin-87-2023-01-15-birdnet-13:45:30.mp3"},
+		{"Fill gaps from text log", sourceDBPath, true, 2, "robin.mp3"},
+		{"Fill gaps with unusable database", filepath.Join(tempDir, "missing.db"), true, 2, "American_Robin-87-2023-01-15-birdnet-13:45:30.mp3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath, cleanup, err := prepareTextLogSource(tt.sourceDBPath, textPath, tt.fillGaps, log.Default())
+			if err != nil {
+				t.Fatalf("prepareTextLogSource() error = %v", err)
+			}
+			defer cleanup()
+
+			db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			if err != nil {
+				t.Fatalf("Failed to open prepared database: %v", err)
+			}
+			defer closeDB(db)
+
+			var detections []Detection
+			if err := db.Order("date ASC, time ASC").Find(&detections).Error; err != nil {
+				t.Fatalf("Failed to read prepared detections: %v", err)
+			}
+
+			if int64(len(detections)) != tt.wantRows {
+				t.Fatalf("prepared database has %d rows, want %d", len(detections), tt.wantRows)
+			}
+
+			if detections[0].FileName != tt.wantFileName {
+				t.Errorf("first detection FileName = %s, want %s", detections[0].FileName, tt.wantFileName)
+			}
+		})
+	}
+
+	// Without a text log the source database is used directly
+	dbPath, cleanup, err := prepareTextLogSource(sourceDBPath, "", false, log.Default())
+	if err != nil {
+		t.Fatalf("prepareTextLogSource() without text log error = %v", err)
+	}
+	cleanup()
+	if dbPath != sourceDBPath {
+		t.Errorf("prepareTextLogSource() without text log = %s, want %s", dbPath, sourceDBPath)
+	}
+}
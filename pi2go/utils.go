@@ -1,2 +1,2 @@
 // file utils.go
-package main
+package pi2go
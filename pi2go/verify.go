@@ -0,0 +1,94 @@
+// file verify.go
+package pi2go
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Verification is the outcome of checking a BirdNET-Go database and clips directory.
+type Verification struct {
+	Integrity    string   // Result of SQLite's integrity check, "ok" for an intact database
+	Notes        int64    // Notes in the database
+	Duplicates   int64    // Notes with the same date, time and species as another one
+	WithClip     int      // Notes referring to a clip
+	ClipsPresent int      // Clips referred to that are in the clips directory
+	ClipsMissing int      // Clips referred to that are not
+	Missing      []string // Paths of missing clips, up to maxClipFailures
+}
+
+// OK reports whether the database is intact and no clip is missing.
+func (v *Verification) OK() bool {
+	return v.Integrity == "ok" && v.ClipsMissing == 0
+}
+
+// Verify checks the BirdNET-Go database at targetDBPath without writing to it: that it passes
+// SQLite's integrity check, how many of its notes duplicate another one and, if targetFilesDir
+// is given, that the clip of every note is in it.
+func Verify(targetDBPath, targetFilesDir string) (*Verification, error) {
+	if _, err := os.Stat(targetDBPath); err != nil {
+		return nil, fmt.Errorf("target database not accessible: %w", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+targetDBPath+"?mode=ro&_pragma=busy_timeout(5000)"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target database: %w", err)
+	}
+	defer closeDB(db)
+
+	v := &Verification{}
+	if err := db.Raw("PRAGMA integrity_check(1)").Scan(&v.Integrity).Error; err != nil {
+		return nil, fmt.Errorf("failed to check target database: %w", err)
+	}
+	if !db.Migrator().HasTable(&Note{}) {
+		return nil, errors.New("target database has no notes table")
+	}
+
+	if err := db.Model(&Note{}).Count(&v.Notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to count notes: %w", err)
+	}
+	err = db.Raw(`SELECT COALESCE(SUM(n - 1), 0) FROM
+		(SELECT COUNT(*) AS n FROM notes GROUP BY date, time, scientific_name HAVING COUNT(*) > 1)`).Scan(&v.Duplicates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count duplicate notes: %w", err)
+	}
+
+	if targetFilesDir == "" {
+		return v, nil
+	}
+
+	const batchSize = 1000
+	var cursor uint
+	for {
+		var notes []Note
+		err := db.Select("id, clip_name").Where("id > ? AND clip_name <> ''", cursor).Order("id").Limit(batchSize).Find(&notes).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read notes: %w", err)
+		}
+		if len(notes) == 0 {
+			break
+		}
+		cursor = notes[len(notes)-1].ID
+
+		for i := range notes {
+			v.WithClip++
+			path := filepath.Join(targetFilesDir, notes[i].ClipName)
+			if _, err := os.Stat(path); err == nil {
+				v.ClipsPresent++
+				continue
+			}
+			v.ClipsMissing++
+			if len(v.Missing) < maxClipFailures {
+				v.Missing = append(v.Missing, path)
+			}
+		}
+	}
+
+	return v, nil
+}
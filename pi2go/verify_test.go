@@ -0,0 +1,54 @@
+package pi2go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	detections := checkpointTestDetections(3)
+	_, sourceDBPath := setupSnapshotSourceDB(t, detections)
+
+	tempDir := t.TempDir()
+	sourceFilesDir := filepath.Join(tempDir, "BirdSongs")
+	targetFilesDir := filepath.Join(tempDir, "clips")
+	targetDBPath := filepath.Join(tempDir, "birdnet.db")
+	for i := range detections {
+		path := sourceClipPaths(&detections[i], sourceFilesDir)[0]
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte("audio"), 0o644)
+	}
+	runMigration(t, Options{Operation: OperationCopy, SourceDBPath: sourceDBPath, TargetDBPath: targetDBPath, SourceFilesDir: sourceFilesDir, TargetFilesDir: targetFilesDir})
+
+	v, err := Verify(targetDBPath, targetFilesDir)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !v.OK() || v.Notes != 3 || v.WithClip != 3 || v.ClipsPresent != 3 {
+		t.Errorf("Verify() = %+v, want an intact database with 3 notes and clips", v)
+	}
+
+	missing, _ := targetClipPath(&detections[1], targetFilesDir)
+	os.Remove(missing)
+	v, err = Verify(targetDBPath, targetFilesDir)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if v.OK() || v.ClipsMissing != 1 || len(v.Missing) != 1 || v.Missing[0] != missing {
+		t.Errorf("Verify() = %+v, want %s missing", v, missing)
+	}
+
+	// Without a clips directory only the database is checked
+	if v, err := Verify(targetDBPath, ""); err != nil || !v.OK() || v.WithClip != 0 {
+		t.Errorf("Verify() without clips = %+v, %v, want only the database checked", v, err)
+	}
+
+	if _, err := Verify(filepath.Join(tempDir, "missing.db"), ""); err == nil {
+		t.Error("Verify() of a missing database succeeded")
+	}
+}
@@ -0,0 +1,202 @@
+// file progress.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+// plainProgressInterval is how often a progress line is printed when output is not a terminal.
+const plainProgressInterval = 10 * time.Second
+
+// ttyRefreshInterval limits how often the progress line on a terminal is redrawn.
+const ttyRefreshInterval = 200 * time.Millisecond
+
+// progressView renders migration progress as a single updating line on a terminal, or as a
+// plain line every interval when output is redirected. Log output written through it appears
+// above the progress line instead of being mixed into it.
+type progressView struct {
+	mu       sync.Mutex
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	now      func() time.Time
+
+	current    pi2go.Progress
+	stageStart time.Time    // When the current stage started
+	stageBase  pi2go.Counts // Counts when the current stage started
+	rendered   time.Time    // When the last line was written
+	line       string       // Line currently shown on the terminal
+}
+
+// newProgressView returns a progress view writing to f, updating in place if f is a terminal.
+func newProgressView(f *os.File) *progressView {
+	interval := plainProgressInterval
+	tty := isTerminal(f)
+	if tty {
+		interval = ttyRefreshInterval
+	}
+
+	return &progressView{out: f, tty: tty, interval: interval, now: time.Now}
+}
+
+// isTerminal reports whether f is a character device, such as an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Update records p and renders it if the stage changed or the refresh interval has passed.
+// It can be used as a pi2go.ProgressFunc.
+func (v *progressView) Update(p pi2go.Progress) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	stageChanged := p.Stage != v.current.Stage
+	if stageChanged {
+		v.stageStart, v.stageBase = now, p.Counts
+	}
+	v.current = p
+
+	if stageChanged || now.Sub(v.rendered) >= v.interval {
+		v.render(now)
+	}
+}
+
+// Finish renders the final state and ends the progress line.
+func (v *progressView) Finish() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.current.Stage == "" {
+		return
+	}
+
+	v.render(v.now())
+	if v.tty {
+		fmt.Fprintln(v.out)
+		v.line = ""
+	}
+}
+
+// Clear removes the progress line from a terminal, before output that is not log output, such
+// as a prompt. It is drawn again on the next update.
+func (v *progressView) Clear() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.tty && v.line != "" {
+		fmt.Fprint(v.out, "\r\x1b[K")
+		v.line = ""
+	}
+}
+
+// Write prints log output, moving the progress line on a terminal below it.
+func (v *progressView) Write(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.tty || v.line == "" {
+		return v.out.Write(p)
+	}
+
+	fmt.Fprint(v.out, "\r\x1b[K")
+	n, err := v.out.Write(p)
+	fmt.Fprint(v.out, v.line)
+	return n, err
+}
+
+// render writes the current progress, in place on a terminal or as a new line otherwise.
+func (v *progressView) render(now time.Time) {
+	v.rendered = now
+	line := v.format(now)
+
+	if !v.tty {
+		fmt.Fprintln(v.out, line)
+		return
+	}
+
+	v.line = line
+	fmt.Fprint(v.out, "\r\x1b[K"+line)
+}
+
+// format describes the current progress with the rates of the current stage and its ETA.
+func (v *progressView) format(now time.Time) string {
+	p := v.current
+	if p.Stage == pi2go.StagePreparing {
+		return "preparing source"
+	}
+
+	var b strings.Builder
+	if p.Total > 0 {
+		fmt.Fprintf(&b, "%s %d/%d rows (%d%%)", p.Stage, p.Processed, p.Total, p.Processed*100/p.Total)
+	} else {
+		fmt.Fprintf(&b, "%s %d rows", p.Stage, p.Processed)
+	}
+	switch p.Stage {
+	case pi2go.StageMerging:
+	case pi2go.StageRollingBack:
+		fmt.Fprintf(&b, ", %d clips restored, %d notes removed", p.ClipsRestored, p.NotesRemoved)
+	default:
+		fmt.Fprintf(&b, ", %d clips, %s", p.ClipsTransferred, formatBytes(p.BytesTransferred))
+	}
+	if failed := p.NoteErrors + p.ClipErrors; failed > 0 {
+		fmt.Fprintf(&b, ", %d errors", failed)
+	}
+	if p.ClipsMissing > 0 {
+		fmt.Fprintf(&b, ", %d missing", p.ClipsMissing)
+	}
+
+	elapsed := now.Sub(v.stageStart).Seconds()
+	if elapsed < 1 {
+		return b.String()
+	}
+
+	rows := float64(p.Processed-v.stageBase.Processed) / elapsed
+	fmt.Fprintf(&b, " | %.0f rows/s", rows)
+	if p.Stage != pi2go.StageMerging && p.Stage != pi2go.StageRollingBack {
+		clips := float64(p.ClipsTransferred-v.stageBase.ClipsTransferred) / elapsed
+		bytes := float64(p.BytesTransferred-v.stageBase.BytesTransferred) / elapsed
+		fmt.Fprintf(&b, ", %.0f clips/s, %s/s", clips, formatBytes(int64(bytes)))
+	}
+
+	// Estimate from the records, or the clips while transferring clips after the records
+	done, rate := p.Processed, rows
+	if p.Stage == pi2go.StageTransferring {
+		done = p.ClipsTransferred + p.ClipsMissing + p.ClipErrors
+		rate = float64(done-v.stageBaseClips()) / elapsed
+	}
+	if p.Total > 0 && rate > 0 && done < p.Total {
+		eta := time.Duration(float64(p.Total-done) / rate * float64(time.Second))
+		fmt.Fprintf(&b, " | ETA %s", eta.Round(time.Second))
+	}
+
+	return b.String()
+}
+
+// stageBaseClips returns the clips handled when the current stage started.
+func (v *progressView) stageBaseClips() int {
+	return v.stageBase.ClipsTransferred + v.stageBase.ClipsMissing + v.stageBase.ClipErrors
+}
+
+// formatBytes formats a size in bytes with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
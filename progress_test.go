@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tphakala/birdnet-pi2go/pi2go"
+)
+
+// newTestProgressView returns a progress view writing to a buffer with a clock advanced by the test.
+func newTestProgressView(tty bool, interval time.Duration) (view *progressView, out *bytes.Buffer, clock *time.Time) {
+	out = &bytes.Buffer{}
+	clock = new(time.Time)
+	*clock = time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	view = &progressView{out: out, tty: tty, interval: interval, now: func() time.Time { return *clock }}
+	return view, out, clock
+}
+
+func TestFormatBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProgressViewPlain(t *testing.T) {
+	t.Parallel()
+
+	view, out, clock := newTestProgressView(false, 10*time.Second)
+
+	view.Update(pi2go.Progress{Stage: pi2go.StagePreparing})
+	view.Update(pi2go.Progress{Stage: pi2go.StageMigrating, Total: 4000})
+
+	// Updates within the interval are not printed
+	*clock = clock.Add(5 * time.Second)
+	view.Update(pi2go.Progress{Stage: pi2go.StageMigrating, Total: 4000, Counts: pi2go.Counts{Processed: 1000}})
+
+	*clock = clock.Add(5 * time.Second)
+	view.Update(pi2go.Progress{Stage: pi2go.StageMigrating, Total: 4000, Counts: pi2go.Counts{
+		Processed: 2000, ClipsTransferred: 1900, ClipsMissing: 90, ClipErrors: 10, BytesTransferred: 20 << 20,
+	}})
+	view.Finish()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"preparing source",
+		"migrating 0/4000 rows (0%), 0 clips, 0 B",
+		"migrating 2000/4000 rows (50%), 1900 clips, 20.0 MiB, 10 errors, 90 missing | 200 rows/s, 190 clips/s, 2.0 MiB/s | ETA 10s",
+		"migrating 2000/4000 rows (50%), 1900 clips, 20.0 MiB, 10 errors, 90 missing | 200 rows/s, 190 clips/s, 2.0 MiB/s | ETA 10s",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("printed %d lines, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestProgressViewTransferringETA(t *testing.T) {
+	t.Parallel()
+
+	view, out, clock := newTestProgressView(false, time.Second)
+
+	// Clips transferred after the records are estimated from the clips handled in this stage
+	done := pi2go.Counts{Processed: 1000, NotesInserted: 1000}
+	view.Update(pi2go.Progress{Stage: pi2go.StageTransferring, Total: 1000, Counts: done})
+	*clock = clock.Add(10 * time.Second)
+	done.ClipsTransferred = 250
+	view.Update(pi2go.Progress{Stage: pi2go.StageTransferring, Total: 1000, Counts: done})
+
+	if !strings.HasSuffix(strings.TrimSpace(out.String()), "ETA 30s") {
+		t.Errorf("transferring progress = %q, want ETA 30s", out.String())
+	}
+}
+
+func TestProgressViewTerminal(t *testing.T) {
+	t.Parallel()
+
+	view, out, clock := newTestProgressView(true, 200*time.Millisecond)
+
+	view.Update(pi2go.Progress{Stage: pi2go.StageMerging, Total: 10})
+	*clock = clock.Add(2 * time.Second)
+	view.Update(pi2go.Progress{Stage: pi2go.StageMerging, Total: 10, Counts: pi2go.Counts{Processed: 4}})
+
+	// Log output is printed above the progress line, which is then redrawn
+	view.Write([]byte("log line\n"))
+	view.Finish()
+
+	line := "merging 4/10 rows (40%) | 2 rows/s | ETA 3s"
+	want := "\r\x1b[Kmerging 0/10 rows (0%)" +
+		"\r\x1b[K" + line +
+		"\r\x1b[Klog line\n" + line +
+		"\r\x1b[K" + line + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("terminal output = %q, want %q", got, want)
+	}
+}
+
+func TestProgressViewClear(t *testing.T) {
+	t.Parallel()
+
+	view, out, _ := newTestProgressView(true, 200*time.Millisecond)
+
+	view.Update(pi2go.Progress{Stage: pi2go.StagePreparing})
+	view.Clear()
+
+	// With the line cleared, log output is not followed by it until the next update
+	view.Write([]byte("log line\n"))
+	view.Clear()
+	view.Update(pi2go.Progress{Stage: pi2go.StageMigrating, Total: 10})
+
+	want := "\r\x1b[Kpreparing source" +
+		"\r\x1b[K" +
+		"log line\n" +
+		"\r\x1b[Kmigrating 0/10 rows (0%), 0 clips, 0 B"
+	if got := out.String(); got != want {
+		t.Errorf("terminal output = %q, want %q", got, want)
+	}
+}